@@ -0,0 +1,305 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"pomu/rules"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+	log "github.com/sirupsen/logrus"
+)
+
+// ArchiveRule decides which livestreams listed on holodex are queued by QueueUpcomingStreams
+type ArchiveRule struct {
+	Id      int64  `json:"id"`
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+	rules.Rule
+	// Quality is the quality matched livestreams are recorded in, 0 picks the best one
+	Quality   int32     `json:"quality"`
+	CreatedBy string    `json:"createdBy"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type archiveRuleRequest struct {
+	Name string `json:"name"`
+	// Enabled defaults to true
+	Enabled *bool `json:"enabled"`
+	rules.Rule
+	Quality int32 `json:"quality"`
+}
+
+func scanArchiveRule(row interface{ Scan(...any) error }, rule *ArchiveRule) error {
+	return row.Scan(
+		&rule.Id,
+		&rule.Name,
+		&rule.Enabled,
+		pq.Array(&rule.IncludeOrgs),
+		pq.Array(&rule.ExcludeOrgs),
+		pq.Array(&rule.IncludeChannels),
+		pq.Array(&rule.ExcludeChannels),
+		pq.Array(&rule.IncludeTopics),
+		pq.Array(&rule.ExcludeTopics),
+		pq.Array(&rule.TitleInclude),
+		pq.Array(&rule.TitleExclude),
+		&rule.IncludeMentions,
+		&rule.Quality,
+		&rule.CreatedBy,
+		&rule.CreatedAt)
+}
+
+// ruleArrays returns the lists of `rule` in column order, lists which are not set are stored empty
+func ruleArrays(rule *rules.Rule) []any {
+	var arrays []any
+
+	for _, list := range [][]string{
+		rule.IncludeOrgs,
+		rule.ExcludeOrgs,
+		rule.IncludeChannels,
+		rule.ExcludeChannels,
+		rule.IncludeTopics,
+		rule.ExcludeTopics,
+		rule.TitleInclude,
+		rule.TitleExclude,
+	} {
+		if list == nil {
+			list = []string{}
+		}
+
+		arrays = append(arrays, pq.Array(list))
+	}
+
+	return arrays
+}
+
+// envArchiveRules returns the rule configured through HOLODEX_ORGS and HOLODEX_TOPIC, which is used as long as no
+// rules are stored in the database
+func envArchiveRules() []ArchiveRule {
+	orgsList := strings.TrimSpace(os.Getenv("HOLODEX_ORGS"))
+
+	// skip if no args were selected in the .env file
+	if len(orgsList) <= 0 {
+		return nil
+	}
+
+	rule := ArchiveRule{
+		Name:      "HOLODEX_ORGS",
+		Enabled:   true,
+		CreatedBy: "pomu.app",
+	}
+
+	for _, org := range strings.Split(orgsList, ",") {
+		rule.IncludeOrgs = append(rule.IncludeOrgs, strings.TrimSpace(org))
+	}
+
+	if topic := strings.TrimSpace(os.Getenv("HOLODEX_TOPIC")); len(topic) > 0 {
+		rule.IncludeTopics = []string{topic}
+	}
+
+	return []ArchiveRule{rule}
+}
+
+// queryArchiveRules returns every rule, or only the enabled ones if `enabledOnly` is set
+func (app *Application) queryArchiveRules(enabledOnly bool) ([]ArchiveRule, error) {
+	rows, err := app.db.Query("select * from archive_rules where enabled or not $1 order by id", enabledOnly)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	archiveRules := []ArchiveRule{}
+
+	for rows.Next() {
+		var rule ArchiveRule
+
+		if err := scanArchiveRule(rows, &rule); err != nil {
+			return nil, err
+		}
+
+		archiveRules = append(archiveRules, rule)
+	}
+
+	return archiveRules, rows.Err()
+}
+
+// activeArchiveRules returns the enabled rules of the database, or the rule configured through the environment if
+// there are none
+func (app *Application) activeArchiveRules() ([]ArchiveRule, error) {
+	var count int
+
+	if err := app.db.QueryRow("select count(*) from archive_rules").Scan(&count); err != nil {
+		return nil, err
+	}
+
+	if count == 0 {
+		return envArchiveRules(), nil
+	}
+
+	return app.queryArchiveRules(true)
+}
+
+// resolveArchiveRuleOwner returns the rule in the url if the logged-in user created it. Otherwise an error is written
+// to `w`.
+func (app *Application) resolveArchiveRuleOwner(w http.ResponseWriter, r *http.Request) (*ArchiveRule, bool) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+
+	if err != nil {
+		http.Error(w, "invalid rule id", http.StatusBadRequest)
+		return nil, false
+	}
+
+	user, err := app.ResolveUserFromRequest(r)
+
+	if user == nil || err != nil {
+		http.Error(w, "please login first", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	var rule ArchiveRule
+
+	if err := scanArchiveRule(app.db.QueryRow("select * from archive_rules where id = $1", id), &rule); err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "rule not found", http.StatusNotFound)
+			return nil, false
+		}
+
+		sentry.CaptureException(err)
+		http.Error(w, "failed to query rule", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if rule.CreatedBy != user.Provider+"/"+user.Id {
+		http.Error(w, "only the user who created the rule can change it", http.StatusForbidden)
+		return nil, false
+	}
+
+	return &rule, true
+}
+
+// decodeArchiveRuleRequest decodes and validates the request body, writing an error to `w` if it is invalid
+func decodeArchiveRuleRequest(w http.ResponseWriter, r *http.Request) (*archiveRuleRequest, bool) {
+	var request archiveRuleRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "failed to decode json request body", http.StatusBadRequest)
+		return nil, false
+	}
+
+	if len(strings.TrimSpace(request.Name)) == 0 {
+		http.Error(w, "rule needs a name", http.StatusBadRequest)
+		return nil, false
+	}
+
+	if err := request.Validate(); err != nil {
+		http.Error(w, fmt.Sprintf("invalid rule: %s", err), http.StatusBadRequest)
+		return nil, false
+	}
+
+	if request.Enabled == nil {
+		enabled := true
+		request.Enabled = &enabled
+	}
+
+	return &request, true
+}
+
+func (app *Application) GetArchiveRules(w http.ResponseWriter, _ *http.Request) {
+	archiveRules, err := app.queryArchiveRules(false)
+
+	if err != nil {
+		sentry.CaptureException(err)
+		http.Error(w, "failed to query rules", http.StatusInternalServerError)
+		return
+	}
+
+	SerializeJson(w, archiveRules)
+}
+
+func (app *Application) CreateArchiveRule(w http.ResponseWriter, r *http.Request) {
+	user, err := app.ResolveUserFromRequest(r)
+
+	if user == nil || err != nil {
+		http.Error(w, "please login first", http.StatusUnauthorized)
+		return
+	}
+
+	request, ok := decodeArchiveRuleRequest(w, r)
+	if !ok {
+		return
+	}
+
+	args := []any{request.Name, *request.Enabled}
+	args = append(args, ruleArrays(&request.Rule)...)
+	args = append(args, request.IncludeMentions, request.Quality, user.Provider+"/"+user.Id)
+
+	var rule ArchiveRule
+
+	row := app.db.QueryRow(
+		"insert into archive_rules (name, enabled, include_orgs, exclude_orgs, include_channels, exclude_channels, include_topics, exclude_topics, title_include, title_exclude, include_mentions, quality, created_by) values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13) returning *",
+		args...)
+
+	if err := scanArchiveRule(row, &rule); err != nil {
+		sentry.CaptureException(err)
+		http.Error(w, "failed to create rule", http.StatusInternalServerError)
+		return
+	}
+
+	log.WithFields(log.Fields{"rule_id": rule.Id, "name": rule.Name, "created_by": rule.CreatedBy}).Info("created archive rule")
+
+	SerializeJson(w, rule)
+}
+
+func (app *Application) UpdateArchiveRule(w http.ResponseWriter, r *http.Request) {
+	rule, ok := app.resolveArchiveRuleOwner(w, r)
+	if !ok {
+		return
+	}
+
+	request, ok := decodeArchiveRuleRequest(w, r)
+	if !ok {
+		return
+	}
+
+	args := []any{request.Name, *request.Enabled}
+	args = append(args, ruleArrays(&request.Rule)...)
+	args = append(args, request.IncludeMentions, request.Quality, rule.Id)
+
+	row := app.db.QueryRow(
+		"update archive_rules set name = $1, enabled = $2, include_orgs = $3, exclude_orgs = $4, include_channels = $5, exclude_channels = $6, include_topics = $7, exclude_topics = $8, title_include = $9, title_exclude = $10, include_mentions = $11, quality = $12 where id = $13 returning *",
+		args...)
+
+	if err := scanArchiveRule(row, rule); err != nil {
+		sentry.CaptureException(err)
+		http.Error(w, "failed to update rule", http.StatusInternalServerError)
+		return
+	}
+
+	SerializeJson(w, rule)
+}
+
+func (app *Application) DeleteArchiveRule(w http.ResponseWriter, r *http.Request) {
+	rule, ok := app.resolveArchiveRuleOwner(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := app.db.Exec("delete from archive_rules where id = $1", rule.Id); err != nil {
+		sentry.CaptureException(err)
+		http.Error(w, "failed to delete rule", http.StatusInternalServerError)
+		return
+	}
+
+	log.WithFields(log.Fields{"rule_id": rule.Id, "name": rule.Name}).Info("deleted archive rule")
+
+	w.WriteHeader(http.StatusNoContent)
+}
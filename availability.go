@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/getsentry/sentry-go"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"pomu/availability"
+	"pomu/source"
+	"time"
+)
+
+// availabilityCheckTimeout is how long yt-dlp may take to check a single video
+const availabilityCheckTimeout = time.Minute
+
+// CheckAvailability checks whether finished archives are still available on YouTube. The YouTube API is asked first,
+// yt-dlp is only used for videos the API does not return, which are private, deleted or members-only.
+func CheckAvailability(app *Application) {
+	rows, err := app.db.Query("select id, availability from videos where finished = true")
+
+	if err != nil {
+		sentry.CaptureException(err)
+		log.WithFields(log.Fields{"error": err}).Error("failed to query videos to check availability of")
+		return
+	}
+
+	var videoIds []string
+	previous := make(map[string]*string)
+
+	for rows.Next() {
+		var videoId string
+		var current *string
+
+		if err := rows.Scan(&videoId, &current); err != nil {
+			sentry.CaptureException(err)
+			continue
+		}
+
+		// availability is only tracked on youtube
+		if !source.IsYouTube(videoId) {
+			continue
+		}
+
+		videoIds = append(videoIds, videoId)
+		previous[videoId] = current
+	}
+
+	_ = rows.Close()
+
+	unknown := 0
+
+	for start := 0; start < len(videoIds); start += metadataBatchSize {
+		end := start + metadataBatchSize
+
+		if end > len(videoIds) {
+			end = len(videoIds)
+		}
+
+		metadata, err := GetVideosMetadata(videoIds[start:end])
+
+		if err != nil {
+			sentry.CaptureException(err)
+			log.WithFields(log.Fields{"error": err}).Error("failed to get metadata from youtube")
+			return
+		}
+
+		for _, videoId := range videoIds[start:end] {
+			var current string
+			ok := false
+
+			if videoMetadata, found := metadata[videoId]; found && videoMetadata.Status != nil {
+				current, ok = availability.FromPrivacyStatus(videoMetadata.Status.PrivacyStatus)
+			}
+
+			if !ok {
+				ctx, cancel := context.WithTimeout(context.Background(), availabilityCheckTimeout)
+				current, ok = availability.CheckYtdlp(ctx, os.Getenv("YT_DLP"), fmt.Sprintf("https://www.youtube.com/watch?v=%s", videoId))
+				cancel()
+			}
+
+			if !ok {
+				unknown += 1
+				continue
+			}
+
+			if err := app.setAvailability(videoId, previous[videoId], current); err != nil {
+				log.WithFields(log.Fields{"video_id": videoId, "error": err}).Warn("failed to store availability")
+			}
+		}
+	}
+
+	log.WithFields(log.Fields{"videos": len(videoIds), "unknown": unknown}).Info("checked availability of archives")
+}
+
+// setAvailability stores the availability of `videoId`, `previous` is what has been stored until now
+func (app *Application) setAvailability(videoId string, previous *string, current string) error {
+	if previous != nil && *previous == current {
+		_, err := app.db.Exec("update videos set availability_checked_at = now() where id = $1", videoId)
+		return err
+	}
+
+	// the first check only counts as change if the video is gone already, so that it shows up as recently gone
+	var changedAt *time.Time
+
+	if previous != nil || !availability.Available(current) {
+		now := time.Now()
+		changedAt = &now
+	}
+
+	tx, err := app.db.Begin()
+
+	if err != nil {
+		sentry.CaptureException(err)
+		return err
+	}
+
+	defer tx.Rollback()
+
+	var archive Video
+
+	err = scanVideo(tx.QueryRow(
+		"update videos set availability = $1, availability_changed_at = coalesce($2::timestamptz, availability_changed_at), availability_checked_at = now() where id = $3 returning *",
+		current, changedAt, videoId), &archive)
+
+	if err != nil {
+		sentry.CaptureException(err)
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		sentry.CaptureException(err)
+		return err
+	}
+
+	if !availability.Available(current) {
+		log.WithFields(log.Fields{"video_id": videoId, "availability": current}).Warn("archived livestream is not available on youtube anymore")
+	}
+
+	go app.UpsertVideo(archive)
+	return nil
+}
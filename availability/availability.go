@@ -0,0 +1,106 @@
+package availability
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+)
+
+const (
+	Public      = "public"
+	Unlisted    = "unlisted"
+	Private     = "private"
+	Deleted     = "deleted"
+	MembersOnly = "members-only"
+)
+
+// All contains every availability
+var All = []string{Public, Unlisted, Private, Deleted, MembersOnly}
+
+// Valid returns whether `availability` is a known availability
+func Valid(availability string) bool {
+	for _, known := range All {
+		if known == availability {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Available returns whether the video can still be watched on YouTube by everyone who has the link
+func Available(availability string) bool {
+	return availability == Public || availability == Unlisted
+}
+
+// FromPrivacyStatus maps `status.privacyStatus` of the YouTube Data API. Returns false for unknown statuses.
+func FromPrivacyStatus(status string) (string, bool) {
+	switch status {
+	case "public":
+		return Public, true
+	case "unlisted":
+		return Unlisted, true
+	case "private":
+		return Private, true
+	default:
+		return "", false
+	}
+}
+
+// FromYtdlp classifies the output of `yt-dlp --print availability`. If yt-dlp failed, `output` is its error message.
+// Returns false if the output does not tell for sure, e.g. because of network errors or region locks.
+func FromYtdlp(output string, failed bool) (string, bool) {
+	output = strings.TrimSpace(output)
+
+	if !failed {
+		switch output {
+		case "public":
+			return Public, true
+		case "unlisted":
+			return Unlisted, true
+		case "private":
+			return Private, true
+		case "subscriber_only":
+			return MembersOnly, true
+		default:
+			// needs_auth (age restricted) and premium_only do not tell anything about the livestream itself
+			return "", false
+		}
+	}
+
+	switch {
+	// region locked videos are just unavailable to us
+	case strings.Contains(output, "not made this video available in your country"):
+		return "", false
+	case strings.Contains(output, "Private video"):
+		return Private, true
+	case strings.Contains(output, "members-only"), strings.Contains(output, "available to this channel's members"):
+		return MembersOnly, true
+	case strings.Contains(output, "has been removed"),
+		strings.Contains(output, "no longer available"),
+		strings.Contains(output, "has been terminated"),
+		strings.Contains(output, "This video does not exist"),
+		strings.Contains(output, "Video unavailable"):
+		return Deleted, true
+	default:
+		return "", false
+	}
+}
+
+// CheckYtdlp asks yt-dlp (at path `ytdlp`) for the availability of the video at `url`.
+// Returns false if the availability could not be determined.
+func CheckYtdlp(ctx context.Context, ytdlp string, url string) (string, bool) {
+	output := new(strings.Builder)
+
+	cmd := exec.CommandContext(ctx, ytdlp, "--force-ipv4", "--skip-download", "--no-warnings", "--print", "availability", url)
+	cmd.Stdout = output
+	cmd.Stderr = output
+
+	err := cmd.Run()
+
+	if ctx.Err() != nil {
+		return "", false
+	}
+
+	return FromYtdlp(output.String(), err != nil)
+}
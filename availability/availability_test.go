@@ -0,0 +1,40 @@
+package availability
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFromYtdlp(t *testing.T) {
+	tests := []struct {
+		output       string
+		failed       bool
+		availability string
+		ok           bool
+	}{
+		{"public\n", false, Public, true},
+		{"subscriber_only", false, MembersOnly, true},
+		{"needs_auth", false, "", false},
+		{"ERROR: [youtube] m7Mzgmpr-Qc: Private video. Sign in if you've been granted access to this video", true, Private, true},
+		{"ERROR: [youtube] m7Mzgmpr-Qc: Join this channel to get access to members-only content like this video, and other exclusive perks.", true, MembersOnly, true},
+		{"ERROR: [youtube] m7Mzgmpr-Qc: Video unavailable. This video has been removed by the uploader", true, Deleted, true},
+		{"ERROR: [youtube] m7Mzgmpr-Qc: Video unavailable. The uploader has not made this video available in your country", true, "", false},
+		{"ERROR: [youtube] m7Mzgmpr-Qc: Unable to download API page: <urlopen error timed out>", true, "", false},
+	}
+
+	for _, test := range tests {
+		availability, ok := FromYtdlp(test.output, test.failed)
+		assert.Equal(t, test.availability, availability, test.output)
+		assert.Equal(t, test.ok, ok, test.output)
+	}
+}
+
+func TestFromPrivacyStatus(t *testing.T) {
+	availability, ok := FromPrivacyStatus("unlisted")
+	assert.True(t, ok)
+	assert.Equal(t, Unlisted, availability)
+
+	_, ok = FromPrivacyStatus("")
+	assert.False(t, ok)
+}
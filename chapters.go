@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"github.com/getsentry/sentry-go"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"os"
+	"pomu/chapters"
+	"pomu/source"
+	"pomu/video"
+	"time"
+)
+
+const (
+	// ChapterSourceDescription chapters come from timestamps in the video description
+	ChapterSourceDescription = "description"
+	// ChapterSourceTitle chapters come from title changes observed during the livestream
+	ChapterSourceTitle = "title"
+)
+
+// defaultTitlePollInterval is how often the title is checked during a recording unless TITLE_POLL_INTERVAL is set
+const defaultTitlePollInterval = 5 * time.Minute
+
+// VideoChapter is a named section of an archive
+type VideoChapter struct {
+	Part int32 `json:"part"`
+	// Offset is the amount of seconds since the part started recording
+	Offset float64 `json:"offset"`
+	Title  string  `json:"title"`
+	Source string  `json:"source"`
+}
+
+// addChapter stores a single chapter
+func (app *Application) addChapter(videoId string, part int32, offset time.Duration, title string, source string) {
+	_, err := app.db.Exec(
+		`insert into video_chapters (video_id, part, "offset", title, source) values ($1, $2, $3, $4, $5)`,
+		videoId, part, offset.Seconds(), title, source)
+
+	if err != nil {
+		sentry.CaptureException(err)
+		log.WithFields(log.Fields{
+			"video_id": videoId,
+			"error":    err,
+		}).Error("failed to store chapter")
+	}
+}
+
+// watchTitle adds a chapter every time the title of the livestream changes until `ctx` is cancelled.
+// The title at the start of the recording is the first chapter.
+func (app *Application) watchTitle(ctx context.Context, videoId string, part int32, start time.Time) {
+	if source.IsYouTube(videoId) && len(os.Getenv("GOOGLE_API_KEY")) == 0 {
+		return
+	}
+
+	interval := defaultTitlePollInterval
+
+	if configured, err := time.ParseDuration(os.Getenv("TITLE_POLL_INTERVAL")); err == nil && configured > 0 {
+		interval = configured
+	}
+
+	title := ""
+
+	for {
+		metadata, err := GetVideoMetadata(videoId)
+
+		if err != nil {
+			log.WithFields(log.Fields{"video_id": videoId, "error": err}).Warn("failed to check livestream title")
+		} else if len(metadata.Title) > 0 && metadata.Title != title {
+			if len(title) > 0 {
+				log.WithFields(log.Fields{"video_id": videoId, "title": metadata.Title}).Info("livestream title changed")
+			}
+
+			title = metadata.Title
+			app.addChapter(videoId, part, time.Since(start), title, ChapterSourceTitle)
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// storeDescriptionChapters replaces the description chapters of `videoId` with the timestamps currently in its
+// description. Timestamps in the description are relative to the start of the livestream, which is why they always
+// belong to the first part.
+func (app *Application) storeDescriptionChapters(videoId string) error {
+	metadata, err := GetVideoMetadata(videoId)
+	if err != nil {
+		return err
+	}
+
+	parsed := chapters.ParseDescription(metadata.Description)
+
+	tx, err := app.db.Begin()
+
+	if err != nil {
+		sentry.CaptureException(err)
+		return err
+	}
+
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("delete from video_chapters where video_id = $1 and source = $2", videoId, ChapterSourceDescription); err != nil {
+		sentry.CaptureException(err)
+		return err
+	}
+
+	for _, chapter := range parsed {
+		_, err := tx.Exec(
+			`insert into video_chapters (video_id, part, "offset", title, source) values ($1, 1, $2, $3, $4)`,
+			videoId, chapter.Start.Seconds(), chapter.Title, ChapterSourceDescription)
+
+		if err != nil {
+			sentry.CaptureException(err)
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		sentry.CaptureException(err)
+		return err
+	}
+
+	if len(parsed) > 0 {
+		log.WithFields(log.Fields{"video_id": videoId, "chapters": len(parsed)}).Info("found chapters in description")
+	}
+
+	return nil
+}
+
+// queryChapters returns every chapter of `videoId`, ordered by part and offset
+func queryChapters(db *sql.DB, videoId string) ([]VideoChapter, error) {
+	rows, err := db.Query(`select part, "offset", title, source from video_chapters where video_id = $1 order by part, "offset"`, videoId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	videoChapters := []VideoChapter{}
+
+	for rows.Next() {
+		var chapter VideoChapter
+
+		if err := rows.Scan(&chapter.Part, &chapter.Offset, &chapter.Title, &chapter.Source); err != nil {
+			return nil, err
+		}
+
+		videoChapters = append(videoChapters, chapter)
+	}
+
+	return videoChapters, rows.Err()
+}
+
+// partChapters picks the chapters which are embedded into `part`. Chapters from the description are preferred as
+// they have been placed by hand, title changes are only used if there are at least two of them.
+func partChapters(videoChapters []VideoChapter, part int32) []chapters.Chapter {
+	bySource := make(map[string][]chapters.Chapter)
+
+	for _, chapter := range videoChapters {
+		if chapter.Part != part {
+			continue
+		}
+
+		bySource[chapter.Source] = append(bySource[chapter.Source], chapters.Chapter{
+			Start: time.Duration(chapter.Offset * float64(time.Second)),
+			Title: chapter.Title,
+		})
+	}
+
+	for _, source := range []string{ChapterSourceDescription, ChapterSourceTitle} {
+		if len(bySource[source]) >= 2 {
+			// the first chapter has to start at the beginning, otherwise players hide everything before it
+			selected := bySource[source]
+			selected[0].Start = 0
+
+			return selected
+		}
+	}
+
+	return nil
+}
+
+// embedChapters adds the stored chapters of every part to its mp4
+func (app *Application) embedChapters(videoId string) error {
+	videoChapters, err := queryChapters(app.db, videoId)
+	if err != nil {
+		sentry.CaptureException(err)
+		return err
+	}
+
+	embedded := 0
+
+	replaced, err := app.remuxParts(videoId, func(ctx context.Context, part int32, parts int32, input string, output string) (bool, error) {
+		selected := partChapters(videoChapters, part)
+
+		if len(selected) == 0 {
+			return false, nil
+		}
+
+		probe, err := video.Probe(ctx, input)
+		if err != nil {
+			return false, err
+		}
+
+		metadata, err := os.CreateTemp("", "pomu-chapters-*.txt")
+		if err != nil {
+			return false, err
+		}
+
+		defer os.Remove(metadata.Name())
+
+		err = chapters.WriteFfmetadata(metadata, selected, probe.Duration)
+		_ = metadata.Close()
+
+		if err != nil {
+			return false, err
+		}
+
+		if err := video.AddChapters(ctx, input, metadata.Name(), output); err != nil {
+			return false, err
+		}
+
+		embedded += len(selected)
+		return true, nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	// remuxParts leaves the video untouched if no part had any chapters
+	if replaced > 0 {
+		log.WithFields(log.Fields{"video_id": videoId, "chapters": embedded, "parts": replaced}).Info("embedded chapters into archive")
+	}
+
+	return nil
+}
+
+func (app *Application) GetChapters(w http.ResponseWriter, r *http.Request) {
+	videoChapters, err := queryChapters(app.db, mux.Vars(r)["id"])
+
+	if err != nil {
+		sentry.CaptureException(err)
+		http.Error(w, "failed to query for chapters", http.StatusInternalServerError)
+		return
+	}
+
+	SerializeJson(w, videoChapters)
+}
@@ -0,0 +1,143 @@
+package chapters
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Chapter is a named section of a video which lasts until the next chapter starts
+type Chapter struct {
+	Start time.Duration
+	Title string
+}
+
+var timestampRegex = regexp.MustCompile(`\b(?:(\d{1,2}):)?(\d{1,2}):(\d{2})\b`)
+
+// listMarkerRegex matches numbering and bullet points in front of a timestamp, e.g. `12. ` or `- `
+var listMarkerRegex = regexp.MustCompile(`^(?:\d+[.)]\s+|[-*•・]\s*)`)
+
+// titleTrim is what separates the timestamp from the title, e.g. `0:00 - Opening`
+const titleTrim = " \t-–—:|・"
+
+// parseTimestamp parses the submatches of timestampRegex
+func parseTimestamp(match []string) (time.Duration, bool) {
+	hours := 0
+
+	if len(match[1]) > 0 {
+		hours, _ = strconv.Atoi(match[1])
+	}
+
+	minutes, _ := strconv.Atoi(match[2])
+	seconds, _ := strconv.Atoi(match[3])
+
+	// minutes may only exceed 59 if there is no hour, e.g. `75:00`
+	if seconds > 59 || (len(match[1]) > 0 && minutes > 59) {
+		return 0, false
+	}
+
+	return time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute + time.Duration(seconds)*time.Second, true
+}
+
+// ParseDescription extracts chapters from a video description. A line is a chapter if it starts or ends with a
+// timestamp, like YouTube itself does it. Chapters which do not come after the previous one are skipped, so that for
+// example the length of a song in a setlist is not mistaken for a chapter. Returns nil if there are less than two.
+func ParseDescription(description string) []Chapter {
+	var chapters []Chapter
+
+	scanner := bufio.NewScanner(strings.NewReader(description))
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		line = listMarkerRegex.ReplaceAllString(line, "")
+
+		location := timestampRegex.FindStringSubmatchIndex(line)
+
+		if location == nil {
+			continue
+		}
+
+		// brackets around the timestamp belong to it, e.g. `Opening (0:00)` or `[0:00] Opening`
+		match := timestampRegex.FindStringSubmatch(line[location[0]:location[1]])
+
+		if location[0] > 0 && location[1] < len(line) && strings.Contains("([", line[location[0]-1:location[0]]) &&
+			strings.Contains(")]", line[location[1]:location[1]+1]) {
+			location[0] -= 1
+			location[1] += 1
+		}
+
+		var title string
+
+		if start := strings.TrimLeft(line[:location[0]], titleTrim); len(start) == 0 {
+			title = line[location[1]:]
+		} else if end := strings.TrimRight(line[location[1]:], titleTrim); len(end) == 0 {
+			title = line[:location[0]]
+		} else {
+			continue
+		}
+
+		// ranges like `0:00 - 3:45 Opening` only use the start
+		title = strings.TrimLeft(title, titleTrim)
+
+		if end := timestampRegex.FindStringIndex(title); end != nil && end[0] == 0 {
+			title = title[end[1]:]
+		}
+
+		title = strings.Trim(title, titleTrim)
+
+		if len(title) == 0 {
+			continue
+		}
+
+		start, ok := parseTimestamp(match)
+
+		if !ok || (len(chapters) > 0 && start <= chapters[len(chapters)-1].Start) {
+			continue
+		}
+
+		chapters = append(chapters, Chapter{Start: start, Title: title})
+	}
+
+	if len(chapters) < 2 {
+		return nil
+	}
+
+	return chapters
+}
+
+var metadataEscaper = strings.NewReplacer("\\", "\\\\", "=", "\\=", ";", "\\;", "#", "\\#", "\n", "\\\n")
+
+// WriteFfmetadata writes `chapters` into `w` in the ffmpeg metadata format. The last chapter ends at `length`,
+// chapters starting after `length` are dropped.
+func WriteFfmetadata(w io.Writer, chapters []Chapter, length time.Duration) error {
+	writer := bufio.NewWriter(w)
+
+	if _, err := writer.WriteString(";FFMETADATA1\n"); err != nil {
+		return err
+	}
+
+	for i, chapter := range chapters {
+		if chapter.Start >= length {
+			break
+		}
+
+		end := length
+
+		if i+1 < len(chapters) && chapters[i+1].Start < length {
+			end = chapters[i+1].Start
+		}
+
+		_, err := fmt.Fprintf(writer, "\n[CHAPTER]\nTIMEBASE=1/1000\nSTART=%d\nEND=%d\ntitle=%s\n",
+			chapter.Start.Milliseconds(), end.Milliseconds(), metadataEscaper.Replace(chapter.Title))
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return writer.Flush()
+}
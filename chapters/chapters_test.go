@@ -0,0 +1,58 @@
+package chapters
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDescription(t *testing.T) {
+	description := `Thank you for coming to my karaoke stream!
+
+Setlist:
+0:00 - Opening
+1. 05:12 Song (Cover)
+2. 12:40 Another Song - Artist
+[1:02:03] Ending talk
+Superchat reading (1:30:00)
+Follow me on twitter at 12:00!
+0:30 this comes before the previous chapter`
+
+	assert.Equal(t, []Chapter{
+		{Start: 0, Title: "Opening"},
+		{Start: 5*time.Minute + 12*time.Second, Title: "Song (Cover)"},
+		{Start: 12*time.Minute + 40*time.Second, Title: "Another Song - Artist"},
+		{Start: time.Hour + 2*time.Minute + 3*time.Second, Title: "Ending talk"},
+		{Start: 90 * time.Minute, Title: "Superchat reading"},
+	}, ParseDescription(description))
+}
+
+func TestParseDescriptionRanges(t *testing.T) {
+	chapters := ParseDescription("0:00 - 3:45 First\n3:45 - 7:00 Second")
+
+	assert.Equal(t, []Chapter{
+		{Start: 0, Title: "First"},
+		{Start: 3*time.Minute + 45*time.Second, Title: "Second"},
+	}, chapters)
+}
+
+func TestParseDescriptionWithoutChapters(t *testing.T) {
+	assert.Nil(t, ParseDescription("Stream starts at 20:00 JST"))
+	assert.Nil(t, ParseDescription(""))
+}
+
+func TestWriteFfmetadata(t *testing.T) {
+	chapters := []Chapter{
+		{Start: 0, Title: "Opening; = #1"},
+		{Start: time.Minute, Title: "Song"},
+		{Start: time.Hour, Title: "after the end"},
+	}
+
+	var buffer bytes.Buffer
+	assert.NoError(t, WriteFfmetadata(&buffer, chapters, 2*time.Minute))
+	assert.Equal(t, ";FFMETADATA1\n"+
+		"\n[CHAPTER]\nTIMEBASE=1/1000\nSTART=0\nEND=60000\ntitle=Opening\\; \\= \\#1\n"+
+		"\n[CHAPTER]\nTIMEBASE=1/1000\nSTART=60000\nEND=120000\ntitle=Song\n", buffer.String())
+}
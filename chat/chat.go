@@ -0,0 +1,100 @@
+package chat
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"time"
+)
+
+const (
+	TypeText       = "text"
+	TypeSuperChat  = "superchat"
+	TypeMembership = "membership"
+)
+
+// ErrNoChat is returned by a Source if the livestream does not have a live chat
+var ErrNoChat = errors.New("livestream does not have a live chat")
+
+// Message is a single chat message
+type Message struct {
+	Id string `json:"id"`
+	// Time is when the message has been sent
+	Time time.Time `json:"time"`
+	// Offset is when the message has been sent in seconds since the recording started
+	Offset   float64 `json:"offset"`
+	Type     string  `json:"type"`
+	Author   string  `json:"author"`
+	AuthorId string  `json:"authorId"`
+	Message  string  `json:"message"`
+	// Amount is the formatted amount of a super chat, e.g. `¥1,000`
+	Amount string `json:"amount,omitempty"`
+}
+
+// Source provides the live chat of a livestream
+type Source interface {
+	// Run sends every chat message of `videoId` into `messages` until the chat ends or `ctx` is cancelled.
+	// Returns nil if the chat ended regularly.
+	Run(ctx context.Context, videoId string, messages chan<- Message) error
+}
+
+// Record writes every message received from `source` into `w` as json lines until the chat ends or `ctx` is
+// cancelled. Offsets are relative to `start`. Returns the amount of messages written.
+func Record(ctx context.Context, source Source, videoId string, start time.Time, w io.Writer) (int, error) {
+	messages := make(chan Message, 100)
+	sourceErr := make(chan error, 1)
+
+	go func() {
+		defer close(messages)
+		sourceErr <- source.Run(ctx, videoId, messages)
+	}()
+
+	encoder := json.NewEncoder(w)
+	var writeErr error
+	count := 0
+
+	for message := range messages {
+		// keep on draining after a failed write so that the source never blocks
+		if writeErr != nil {
+			continue
+		}
+
+		message.Offset = message.Time.Sub(start).Seconds()
+
+		if writeErr = encoder.Encode(message); writeErr == nil {
+			count += 1
+		}
+	}
+
+	if writeErr != nil {
+		return count, writeErr
+	}
+
+	return count, <-sourceErr
+}
+
+// ReadMessages reads messages written by Record from `reader`
+func ReadMessages(reader io.Reader) ([]Message, error) {
+	var messages []Message
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var message Message
+
+		if err := json.Unmarshal(scanner.Bytes(), &message); err != nil {
+			return messages, err
+		}
+
+		messages = append(messages, message)
+	}
+
+	return messages, scanner.Err()
+}
@@ -0,0 +1,62 @@
+package chat
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeSource struct {
+	messages []Message
+}
+
+func (source *fakeSource) Run(ctx context.Context, videoId string, messages chan<- Message) error {
+	for _, message := range source.messages {
+		select {
+		case messages <- message:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func TestRecord(t *testing.T) {
+	start := time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+	source := &fakeSource{messages: []Message{
+		{Id: "1", Time: start.Add(1500 * time.Millisecond), Type: TypeText, Author: "Pomu", Message: "hello"},
+		{Id: "2", Time: start.Add(90 * time.Second), Type: TypeSuperChat, Author: "Rainbow", Message: "", Amount: "¥1,000"},
+	}}
+
+	var buffer bytes.Buffer
+	count, err := Record(context.Background(), source, "m7Mzgmpr-Qc", start, &buffer)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	messages, err := ReadMessages(&buffer)
+	assert.NoError(t, err)
+
+	if assert.Len(t, messages, 2) {
+		assert.Equal(t, 1.5, messages[0].Offset)
+		assert.Equal(t, "hello", messages[0].Message)
+		assert.Equal(t, 90.0, messages[1].Offset)
+		assert.Equal(t, "¥1,000", messages[1].Amount)
+		assert.True(t, start.Add(90*time.Second).Equal(messages[1].Time))
+	}
+}
+
+func TestRecordCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// the source gives up on the cancelled context instead of blocking forever
+	source := &fakeSource{messages: make([]Message, 1000)}
+
+	var buffer bytes.Buffer
+	_, err := Record(ctx, source, "m7Mzgmpr-Qc", time.Now(), &buffer)
+	assert.NoError(t, err)
+}
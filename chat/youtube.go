@@ -0,0 +1,155 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	"google.golang.org/api/youtube/v3"
+)
+
+// DefaultPollInterval is the shortest time between two requests unless YouTubeSource.PollInterval is set. Every
+// request costs 5 units of the API quota, 10,000 units a day by default, so this allows about 5 hours of chat a day.
+const DefaultPollInterval = 30 * time.Second
+
+// maxResults is the largest page YouTube returns, long polling intervals would lose messages in busy chats otherwise
+const maxResults = 2000
+
+// YouTubeSource reads the live chat through the YouTube Data API
+type YouTubeSource struct {
+	ApiKey string
+	// PollInterval is the shortest time between two requests, YouTube's suggested interval is used if it is longer.
+	// Defaults to DefaultPollInterval.
+	PollInterval time.Duration
+}
+
+func (source *YouTubeSource) Run(ctx context.Context, videoId string, messages chan<- Message) error {
+	service, err := youtube.NewService(ctx, option.WithAPIKey(source.ApiKey))
+	if err != nil {
+		return err
+	}
+
+	videos, err := service.Videos.List([]string{"liveStreamingDetails"}).Id(videoId).Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+
+	if len(videos.Items) != 1 || videos.Items[0].LiveStreamingDetails == nil || len(videos.Items[0].LiveStreamingDetails.ActiveLiveChatId) <= 0 {
+		return ErrNoChat
+	}
+
+	chatId := videos.Items[0].LiveStreamingDetails.ActiveLiveChatId
+	pageToken := ""
+
+	for {
+		call := service.LiveChatMessages.List(chatId, []string{"snippet", "authorDetails"}).MaxResults(maxResults).Context(ctx)
+
+		if len(pageToken) > 0 {
+			call = call.PageToken(pageToken)
+		}
+
+		response, err := call.Do()
+
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			var apiErr *googleapi.Error
+
+			// the chat is gone once the livestream has ended
+			if errors.As(err, &apiErr) && (apiErr.Code == http.StatusForbidden || apiErr.Code == http.StatusNotFound) {
+				return nil
+			}
+
+			return err
+		}
+
+		for _, item := range response.Items {
+			message, ok := convertMessage(item)
+
+			if !ok {
+				continue
+			}
+
+			select {
+			case messages <- message:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+
+		if len(response.OfflineAt) > 0 {
+			return nil
+		}
+
+		pageToken = response.NextPageToken
+		interval := time.Duration(response.PollingIntervalMillis) * time.Millisecond
+
+		if interval < source.pollInterval() {
+			interval = source.pollInterval()
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (source *YouTubeSource) pollInterval() time.Duration {
+	if source.PollInterval > 0 {
+		return source.PollInterval
+	}
+
+	return DefaultPollInterval
+}
+
+// convertMessage converts messages of the types pomu keeps, everything else (deletions, bans, polls, ...) is skipped
+func convertMessage(item *youtube.LiveChatMessage) (Message, bool) {
+	if item.Snippet == nil || item.AuthorDetails == nil {
+		return Message{}, false
+	}
+
+	sent, err := time.Parse(time.RFC3339, item.Snippet.PublishedAt)
+	if err != nil {
+		return Message{}, false
+	}
+
+	message := Message{
+		Id:       item.Id,
+		Time:     sent,
+		Author:   item.AuthorDetails.DisplayName,
+		AuthorId: item.AuthorDetails.ChannelId,
+		Message:  item.Snippet.DisplayMessage,
+	}
+
+	switch item.Snippet.Type {
+	case "textMessageEvent":
+		message.Type = TypeText
+	case "superChatEvent":
+		message.Type = TypeSuperChat
+
+		if item.Snippet.SuperChatDetails != nil {
+			message.Amount = item.Snippet.SuperChatDetails.AmountDisplayString
+		}
+	case "superStickerEvent":
+		message.Type = TypeSuperChat
+
+		if item.Snippet.SuperStickerDetails != nil {
+			message.Amount = item.Snippet.SuperStickerDetails.AmountDisplayString
+		}
+	case "newSponsorEvent", "memberMilestoneChatEvent", "membershipGiftingEvent", "giftMembershipReceivedEvent":
+		message.Type = TypeMembership
+	default:
+		return Message{}, false
+	}
+
+	return message, true
+}
+
+var _ Source = (*YouTubeSource)(nil)
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"pomu/storage"
+	"pomu/video"
+
+	"github.com/joho/godotenv"
+)
+
+// remux re-muxes segments which have been kept using `KEEP_SEGMENTS=true` into a fresh mp4.
+//
+// Usage: remux <segments prefix> <output path> [audio segments prefix] [subtitles]
+// Example: remux m7Mzgmpr-Qc.segments m7Mzgmpr-Qc.remux.mp4
+//
+// The audio segments prefix is only needed if the audio has been recorded from a separate rendition, pass `-` to skip
+// it. Subtitles (a local path or url, e.g. of `<id>.vtt`) are added as subtitle track.
+func main() {
+	err := godotenv.Load()
+
+	if err != nil {
+		log.Fatalf("Failed to load .env file")
+	}
+
+	if len(os.Args) < 3 {
+		log.Fatalln("Usage: remux <segments prefix> <output path> [audio segments prefix] [subtitles]")
+	}
+
+	prefix := os.Args[1]
+	output := os.Args[2]
+
+	backend, err := storage.New()
+	if err != nil {
+		log.Fatalln("storage.New():", err)
+	}
+
+	manifest, err := video.LoadManifest(backend, prefix)
+	if err != nil {
+		log.Fatalln("video.LoadManifest():", err)
+	}
+
+	var audioManifest *video.Manifest
+
+	if len(os.Args) > 3 && os.Args[3] != "-" {
+		audioManifest, err = video.LoadManifest(backend, os.Args[3])
+		if err != nil {
+			log.Fatalln("video.LoadManifest():", err)
+		}
+	}
+
+	fmt.Println("Re-muxing", len(manifest.Segments), "segments of", manifest.VideoId, "into", output)
+
+	if len(manifest.Dropped) > 0 {
+		fmt.Println("Warning:", len(manifest.Dropped), "segments could not be archived and are missing:", manifest.Dropped)
+	}
+
+	muxer := &video.Muxer{SeparateAudio: audioManifest != nil}
+	muxer.Stderr = os.Stderr
+
+	if len(os.Args) > 4 {
+		muxer.Subtitles = []video.SubtitleTrack{{Path: os.Args[4]}}
+	}
+
+	err = muxer.Start(context.Background())
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	go copySegments(backend, manifest, muxer)
+
+	if audioManifest != nil {
+		go copySegments(backend, audioManifest, muxer.AudioWriter())
+	}
+
+	if err := backend.Upload(context.Background(), output, muxer, "video/mp4"); err != nil {
+		log.Fatalln("backend.Upload():", err)
+	}
+
+	// the upload only sees the end of the output, ffmpeg might still have failed to mux it
+	if err := muxer.Wait(); err != nil {
+		log.Fatalln("ffmpeg failed:", err)
+	}
+
+	fmt.Println("Finished re-muxing into", output)
+}
+
+// copySegments writes every segment of `manifest` into `w` and closes it afterwards
+func copySegments(backend storage.Backend, manifest *video.Manifest, w io.WriteCloser) {
+	defer w.Close()
+
+	for _, segment := range manifest.Segments {
+		reader, err := backend.Download(segment.Key)
+		if err != nil {
+			log.Println("Skipping missing segment", segment.Key, err)
+			continue
+		}
+
+		_, err = io.Copy(w, reader)
+		reader.Close()
+
+		if err != nil {
+			log.Fatalln("io.Copy():", err)
+		}
+	}
+}
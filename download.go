@@ -8,8 +8,8 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"net/http"
-	"os"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
@@ -17,6 +17,8 @@ const (
 	TypeVideo     = "video"
 	TypeFfmpegLog = "ffmpeg"
 	TypeThumbnail = "thumbnail"
+	TypeChat      = "chat"
+	TypeSubtitles = "subtitles"
 )
 
 var crawlerUserAgentRegex = regexp.MustCompile("/bot|crawler|spider|crawling/i")
@@ -39,6 +41,18 @@ var thumbnailDownloadCounter = promauto.NewCounter(prometheus.CounterOpts{
 	Help:      "Number of thumbnail requests (excludes HEAD)",
 })
 
+var chatDownloadCounter = promauto.NewCounter(prometheus.CounterOpts{
+	Subsystem: "downloads",
+	Name:      "chat",
+	Help:      "Number of live chat requests (excludes HEAD)",
+})
+
+var subtitlesDownloadCounter = promauto.NewCounter(prometheus.CounterOpts{
+	Subsystem: "downloads",
+	Name:      "subtitles",
+	Help:      "Number of subtitle requests (excludes HEAD)",
+})
+
 func (app *Application) VideoDownload(w http.ResponseWriter, r *http.Request) {
 	userAgent := r.UserAgent()
 
@@ -52,7 +66,7 @@ func (app *Application) VideoDownload(w http.ResponseWriter, r *http.Request) {
 	videoId := variables["id"]
 	type_ := variables["type"]
 
-	if type_ != TypeVideo && type_ != TypeFfmpegLog && type_ != TypeThumbnail {
+	if type_ != TypeVideo && type_ != TypeFfmpegLog && type_ != TypeThumbnail && type_ != TypeChat && type_ != TypeSubtitles {
 		http.Error(w, fmt.Sprintf("unknown type \"%s\"", type_), http.StatusNotFound)
 		return
 	}
@@ -67,7 +81,7 @@ func (app *Application) VideoDownload(w http.ResponseWriter, r *http.Request) {
 
 	defer tx.Rollback()
 
-	statement, err := tx.Prepare("select finished, thumbnail from videos where id = $1 limit 1")
+	statement, err := tx.Prepare("select finished, parts from videos where id = $1 limit 1")
 
 	if err != nil {
 		sentry.CaptureException(err)
@@ -76,9 +90,9 @@ func (app *Application) VideoDownload(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var finished bool
-	var thumbnail string
+	var parts int32
 
-	if err = statement.QueryRow(videoId).Scan(&finished, &thumbnail); err != nil {
+	if err = statement.QueryRow(videoId).Scan(&finished, &parts); err != nil {
 		if err == sql.ErrNoRows {
 			http.Error(w, "video not found", http.StatusNotFound)
 		} else {
@@ -89,11 +103,35 @@ func (app *Application) VideoDownload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !finished && type_ != TypeThumbnail {
+	if !finished && type_ != TypeThumbnail && type_ != TypeChat && type_ != TypeSubtitles {
 		http.Error(w, "video not yet finished", http.StatusBadRequest)
 		return
 	}
 
+	part := int32(1)
+
+	if type_ == TypeVideo || type_ == TypeFfmpegLog || type_ == TypeChat || type_ == TypeSubtitles {
+		partStr := r.URL.Query().Get("part")
+
+		if len(partStr) > 0 {
+			parsedPart, err := strconv.ParseInt(partStr, 10, 32)
+
+			if err != nil || parsedPart < 1 || int32(parsedPart) > parts {
+				http.Error(w, fmt.Sprintf("part has to be between 1 and %d", parts), http.StatusBadRequest)
+				return
+			}
+
+			part = int32(parsedPart)
+		} else if parts > 1 {
+			// the recording got interrupted and is stored in multiple parts, let the client choose
+			video := Video{Id: videoId, Parts: parts}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusMultipleChoices)
+			SerializeJson(w, video.PartDownloadUrls(type_))
+			return
+		}
+	}
+
 	increaseCount := r.Method != "HEAD" && !crawlerUserAgentRegex.MatchString(r.UserAgent())
 	var url string
 
@@ -107,22 +145,54 @@ func (app *Application) VideoDownload(w http.ResponseWriter, r *http.Request) {
 			_, _ = tx.Exec("update videos set downloads = downloads + 1 where id = $1", videoId)
 		}
 
-		url = fmt.Sprintf("%s/%s.mp4", os.Getenv("S3_DOWNLOAD_URL"), videoId)
+		url, err = app.storage.Url(VideoPartKey(videoId, part, parts, "mp4"))
 		break
 	case TypeFfmpegLog:
 		if increaseCount {
 			ffmpegLogDownloadCounter.Inc()
 		}
 
-		url = fmt.Sprintf("%s/%s.log", os.Getenv("S3_DOWNLOAD_URL"), videoId)
+		url, err = app.storage.Url(VideoPartKey(videoId, part, parts, "log"))
 		break
 	case TypeThumbnail:
 		if increaseCount {
 			thumbnailDownloadCounter.Inc()
 		}
 
-		url = thumbnail
+		url, err = app.storage.Url(ThumbnailKey(videoId))
 		break
+	case TypeChat:
+		if increaseCount {
+			chatDownloadCounter.Inc()
+		}
+
+		url, err = app.storage.Url(VideoPartKey(videoId, part, parts, "chat.jsonl"))
+		break
+	case TypeSubtitles:
+		format := r.URL.Query().Get("format")
+
+		if len(format) == 0 {
+			format = "vtt"
+		}
+
+		// ass subtitles only exist for chat, as scrolling danmaku
+		if format != "vtt" && format != "ass" {
+			http.Error(w, fmt.Sprintf("unknown subtitle format \"%s\"", format), http.StatusBadRequest)
+			return
+		}
+
+		if increaseCount {
+			subtitlesDownloadCounter.Inc()
+		}
+
+		url, err = app.storage.Url(VideoPartKey(videoId, part, parts, format))
+		break
+	}
+
+	if err != nil {
+		sentry.CaptureException(err)
+		http.Error(w, "failed to get download url", http.StatusInternalServerError)
+		return
 	}
 
 	if err := tx.Commit(); err != nil {
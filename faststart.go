@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/getsentry/sentry-go"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"pomu/video"
+	"time"
+)
+
+// faststartTimeout is how long remuxing and uploading a single part may take
+const faststartTimeout = 2 * time.Hour
+
+// faststartTolerance is how much the length of the remuxed archive may differ from the original
+const faststartTolerance = 2 * time.Second
+
+// faststartVideo remuxes every part of `videoId` from the fragmented mp4 the muxer produces into a regular mp4 with
+// the moov atom at the start, which seeks a lot better in most players
+func (app *Application) faststartVideo(videoId string) error {
+	var faststart bool
+
+	if err := app.db.QueryRow("select faststart from videos where id = $1", videoId).Scan(&faststart); err != nil {
+		sentry.CaptureException(err)
+		return err
+	}
+
+	if faststart {
+		return nil
+	}
+
+	replaced, err := app.remuxParts(videoId, faststartPart)
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"video_id": videoId,
+		"parts":    replaced,
+	}).Info("remuxed archive with faststart")
+
+	return nil
+}
+
+// faststartPart is a remuxFunc which remuxes `input` with faststart and verifies that the remuxed file has the same
+// streams and length as the original
+func faststartPart(ctx context.Context, _ int32, _ int32, input string, output string) (bool, error) {
+	original, err := video.Probe(ctx, input)
+	if err != nil {
+		return false, err
+	}
+
+	if err := video.Remux(ctx, input, output, "-map", "0", "-movflags", "+faststart"); err != nil {
+		return false, err
+	}
+
+	remuxed, err := video.Probe(ctx, output)
+	if err != nil {
+		return false, err
+	}
+
+	if remuxed.VideoCodec != original.VideoCodec || remuxed.AudioCodec != original.AudioCodec {
+		return false, fmt.Errorf("remuxed streams %s/%s do not match original %s/%s",
+			remuxed.VideoCodec, remuxed.AudioCodec, original.VideoCodec, original.AudioCodec)
+	}
+
+	if difference := remuxed.Duration - original.Duration; difference > faststartTolerance || difference < -faststartTolerance {
+		return false, fmt.Errorf("remuxed length %s does not match original %s", remuxed.Duration, original.Duration)
+	}
+
+	return true, nil
+}
+
+// remuxFunc writes a remuxed copy of `input`, which is part `part` of `parts`, into `output`.
+// Returns false if the part should be kept as it is.
+type remuxFunc func(ctx context.Context, part int32, parts int32, input string, output string) (bool, error)
+
+// remuxParts replaces every part of `videoId` with the output of `remux` and updates the file size afterwards.
+// `remux` has to produce a regular mp4 with faststart. Returns how many parts have been replaced, the video is left
+// untouched if there are none.
+func (app *Application) remuxParts(videoId string, remux remuxFunc) (int, error) {
+	var parts int32
+
+	if err := app.db.QueryRow("select parts from videos where id = $1", videoId).Scan(&parts); err != nil {
+		sentry.CaptureException(err)
+		return 0, err
+	}
+
+	var fileSize int64
+	replaced := 0
+
+	for part := int32(1); part <= parts; part++ {
+		size, replacedPart, err := app.remuxPart(VideoPartKey(videoId, part, parts, "mp4"), part, parts, remux)
+
+		if err != nil {
+			return replaced, fmt.Errorf("failed to remux part %d: %w", part, err)
+		}
+
+		if replacedPart {
+			replaced++
+		}
+
+		fileSize += size
+	}
+
+	if replaced == 0 {
+		return 0, nil
+	}
+
+	tx, err := app.db.Begin()
+
+	if err != nil {
+		sentry.CaptureException(err)
+		return replaced, err
+	}
+
+	defer tx.Rollback()
+
+	var archive Video
+
+	if err := scanVideo(tx.QueryRow("update videos set faststart = true, file_size = $1 where id = $2 returning *", fileSize, videoId), &archive); err != nil {
+		sentry.CaptureException(err)
+		return replaced, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		sentry.CaptureException(err)
+		return replaced, err
+	}
+
+	go app.UpsertVideo(archive)
+	return replaced, nil
+}
+
+// remuxPart replaces the stored file at `key` with the output of `remux`. Returns the size of the stored file and
+// whether it has been replaced.
+func (app *Application) remuxPart(key string, part int32, parts int32, remux remuxFunc) (int64, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), faststartTimeout)
+	defer cancel()
+
+	input, err := app.storageInput(key)
+	if err != nil {
+		return 0, false, err
+	}
+
+	// NOTE: faststart moves the moov atom to the front after muxing, so the output has to be seekable
+	output, err := os.CreateTemp("", "pomu-remux-*.mp4")
+	if err != nil {
+		return 0, false, err
+	}
+
+	_ = output.Close()
+	defer os.Remove(output.Name())
+
+	replace, err := remux(ctx, part, parts, input, output.Name())
+	if err != nil {
+		return 0, false, err
+	}
+
+	if !replace {
+		info, err := app.storage.Stat(key)
+		if err != nil {
+			return 0, false, err
+		}
+
+		return info.Size, false, nil
+	}
+
+	size, err := app.replaceStored(ctx, key, output.Name(), "video/mp4")
+	return size, err == nil, err
+}
+
+// replaceStored replaces the stored file at `key` with the local file at `path`. Returns the size of the new file.
+func (app *Application) replaceStored(ctx context.Context, key string, path string, contentType string) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	// upload next to the original first, so that the original stays untouched until the upload is complete
+	staging := key + ".staging"
+
+	if err := app.storage.Upload(ctx, staging, file, contentType); err != nil {
+		return 0, err
+	}
+
+	info, err := app.storage.Stat(staging)
+	if err != nil {
+		return 0, err
+	}
+
+	if info.Size != stat.Size() {
+		_ = app.storage.Delete(staging)
+		return 0, fmt.Errorf("uploaded %d bytes instead of %d", info.Size, stat.Size())
+	}
+
+	// replacing is atomic in every backend, clients either get the original or the new file
+	if err := app.storage.Copy(staging, key); err != nil {
+		return 0, err
+	}
+
+	if err := app.storage.Delete(staging); err != nil {
+		log.WithFields(log.Fields{"key": staging, "error": err}).Warn("failed to delete staged upload")
+	}
+
+	return info.Size, nil
+}
@@ -0,0 +1,106 @@
+// Package feed reads the Atom feeds YouTube publishes for every channel. The same format is pushed to WebSub
+// subscribers whenever a channel uploads or schedules a video.
+package feed
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Entry is a video of a channel
+type Entry struct {
+	VideoId   string    `xml:"http://www.youtube.com/xml/schemas/2015 videoId"`
+	ChannelId string    `xml:"http://www.youtube.com/xml/schemas/2015 channelId"`
+	Title     string    `xml:"title"`
+	Published time.Time `xml:"published"`
+	Updated   time.Time `xml:"updated"`
+}
+
+// DeletedEntry is sent instead of an Entry if a video has been deleted or made private
+type DeletedEntry struct {
+	// Ref is yt:video:<video id>
+	Ref  string    `xml:"ref,attr"`
+	When time.Time `xml:"when,attr"`
+}
+
+// VideoId returns the id of the deleted video
+func (d *DeletedEntry) VideoId() string {
+	return strings.TrimPrefix(d.Ref, "yt:video:")
+}
+
+type Feed struct {
+	ChannelId string         `xml:"http://www.youtube.com/xml/schemas/2015 channelId"`
+	Title     string         `xml:"title"`
+	Entries   []Entry        `xml:"entry"`
+	Deleted   []DeletedEntry `xml:"http://purl.org/atompub/tombstones/1.0 deleted-entry"`
+}
+
+// Url returns the url of the feed of `channelId`
+func Url(channelId string) string {
+	return "https://www.youtube.com/feeds/videos.xml?channel_id=" + url.QueryEscape(channelId)
+}
+
+// Topic returns the WebSub topic of the feed of `channelId`
+func Topic(channelId string) string {
+	return "https://www.youtube.com/xml/feeds/videos.xml?channel_id=" + url.QueryEscape(channelId)
+}
+
+// TopicChannelId returns the channel id of a WebSub topic, or an empty string if it is not the topic of a channel
+func TopicChannelId(topic string) string {
+	u, err := url.Parse(topic)
+	if err != nil {
+		return ""
+	}
+
+	return u.Query().Get("channel_id")
+}
+
+// Parse reads a feed. Entries without a video id are dropped.
+func Parse(r io.Reader) (*Feed, error) {
+	var feed Feed
+
+	if err := xml.NewDecoder(r).Decode(&feed); err != nil {
+		return nil, err
+	}
+
+	entries := feed.Entries[:0]
+
+	for _, entry := range feed.Entries {
+		if len(entry.VideoId) > 0 {
+			entries = append(entries, entry)
+		}
+	}
+
+	feed.Entries = entries
+
+	return &feed, nil
+}
+
+// Fetch gets the current feed of `channelId`. YouTube only lists the latest 15 videos.
+func Fetch(ctx context.Context, channelId string) (*Feed, error) {
+	request, err := http.NewRequestWithContext(ctx, "GET", Url(channelId), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	request.Header.Set("User-Agent", "pomu.app")
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch feed of %s: %s", channelId, response.Status)
+	}
+
+	return Parse(response.Body)
+}
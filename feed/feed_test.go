@@ -0,0 +1,66 @@
+package feed
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const channelFeed = `<?xml version="1.0" encoding="UTF-8"?>
+<feed xmlns:yt="http://www.youtube.com/xml/schemas/2015" xmlns:media="http://search.yahoo.com/mrss/" xmlns="http://www.w3.org/2005/Atom">
+ <link rel="self" href="http://www.youtube.com/feeds/videos.xml?channel_id=UCl_gCybOJRIgOXw6Qb4qJzQ"/>
+ <id>yt:channel:UCl_gCybOJRIgOXw6Qb4qJzQ</id>
+ <yt:channelId>UCl_gCybOJRIgOXw6Qb4qJzQ</yt:channelId>
+ <title>Rushia Ch. 潤羽るしあ</title>
+ <published>2019-07-04T07:47:33+00:00</published>
+ <entry>
+  <id>yt:video:m7Mzgmpr-Qc</id>
+  <yt:videoId>m7Mzgmpr-Qc</yt:videoId>
+  <yt:channelId>UCl_gCybOJRIgOXw6Qb4qJzQ</yt:channelId>
+  <title>【歌枠】singing</title>
+  <link rel="alternate" href="https://www.youtube.com/watch?v=m7Mzgmpr-Qc"/>
+  <published>2022-09-01T12:00:00+00:00</published>
+  <updated>2022-09-01T12:05:00+00:00</updated>
+  <media:group>
+   <media:title>【歌枠】singing</media:title>
+  </media:group>
+ </entry>
+ <entry>
+  <id>yt:channel:UCl_gCybOJRIgOXw6Qb4qJzQ</id>
+  <title>not a video</title>
+ </entry>
+</feed>`
+
+const deletedFeed = `<feed xmlns:at="http://purl.org/atompub/tombstones/1.0" xmlns="http://www.w3.org/2005/Atom">
+ <at:deleted-entry ref="yt:video:m7Mzgmpr-Qc" when="2022-09-02T00:00:00+00:00"/>
+</feed>`
+
+func TestParse(t *testing.T) {
+	feed, err := Parse(strings.NewReader(channelFeed))
+
+	assert.NoError(t, err)
+	assert.Equal(t, "UCl_gCybOJRIgOXw6Qb4qJzQ", feed.ChannelId)
+	assert.Equal(t, "Rushia Ch. 潤羽るしあ", feed.Title)
+	assert.Len(t, feed.Entries, 1)
+	assert.Equal(t, "m7Mzgmpr-Qc", feed.Entries[0].VideoId)
+	assert.Equal(t, "UCl_gCybOJRIgOXw6Qb4qJzQ", feed.Entries[0].ChannelId)
+	assert.Equal(t, "【歌枠】singing", feed.Entries[0].Title)
+	assert.Equal(t, time.Date(2022, 9, 1, 12, 5, 0, 0, time.UTC), feed.Entries[0].Updated.UTC())
+
+	feed, err = Parse(strings.NewReader(deletedFeed))
+
+	assert.NoError(t, err)
+	assert.Empty(t, feed.Entries)
+	assert.Len(t, feed.Deleted, 1)
+	assert.Equal(t, "m7Mzgmpr-Qc", feed.Deleted[0].VideoId())
+}
+
+func TestTopic(t *testing.T) {
+	topic := Topic("UCl_gCybOJRIgOXw6Qb4qJzQ")
+
+	assert.Equal(t, "https://www.youtube.com/xml/feeds/videos.xml?channel_id=UCl_gCybOJRIgOXw6Qb4qJzQ", topic)
+	assert.Equal(t, "UCl_gCybOJRIgOXw6Qb4qJzQ", TopicChannelId(topic))
+	assert.Equal(t, "", TopicChannelId("https://www.youtube.com/xml/feeds/videos.xml"))
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"database/sql"
+	"github.com/getsentry/sentry-go"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"pomu/video"
+	"time"
+)
+
+// VideoGap is a segment which is missing from an archive
+type VideoGap struct {
+	Part int32 `json:"part"`
+	// Sequence is the media sequence number of the first missing segment
+	Sequence int64 `json:"sequence"`
+	// Segments is the amount of consecutive segments which are missing
+	Segments int32 `json:"segments"`
+	// Offset is the amount of seconds since the part started recording
+	Offset     float64   `json:"offset"`
+	Reason     string    `json:"reason"`
+	Attempts   int32     `json:"attempts"`
+	OccurredAt time.Time `json:"occurredAt"`
+}
+
+// recordGap stores a gap reported by video.Download
+func (app *Application) recordGap(videoId string, part int32, gap video.Gap) {
+	_, err := app.db.Exec(
+		`insert into video_gaps (video_id, part, "offset", reason, attempts, occurred_at, sequence, segments) values ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		videoId, part, gap.Time.Seconds(), gap.Reason, gap.Attempts, gap.OccurredAt, int64(gap.Sequence), gap.Count)
+
+	if err != nil {
+		sentry.CaptureException(err)
+		log.WithFields(log.Fields{
+			"video_id": videoId,
+			"error":    err,
+		}).Error("failed to record gap")
+	}
+}
+
+func (app *Application) GetGaps(w http.ResponseWriter, r *http.Request) {
+	tx, err := app.db.Begin()
+
+	if err != nil {
+		sentry.CaptureException(err)
+		http.Error(w, "cannot start transaction", http.StatusInternalServerError)
+		return
+	}
+
+	defer tx.Rollback()
+
+	videoId := mux.Vars(r)["id"]
+
+	rows, err := tx.Query(`select part, sequence, segments, "offset", reason, attempts, occurred_at from video_gaps where video_id = $1 order by part, "offset"`, videoId)
+
+	if err != nil {
+		sentry.CaptureException(err)
+		http.Error(w, "failed to query for gaps", http.StatusInternalServerError)
+		return
+	}
+
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			sentry.CaptureException(err)
+		}
+	}(rows)
+
+	gaps := []VideoGap{}
+
+	for rows.Next() {
+		var gap VideoGap
+
+		if err := rows.Scan(&gap.Part, &gap.Sequence, &gap.Segments, &gap.Offset, &gap.Reason, &gap.Attempts, &gap.OccurredAt); err != nil {
+			sentry.CaptureException(err)
+			continue
+		}
+
+		gaps = append(gaps, gap)
+	}
+
+	if err := tx.Commit(); err != nil {
+		sentry.CaptureException(err)
+		http.Error(w, "cannot commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	SerializeJson(w, gaps)
+}
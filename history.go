@@ -7,6 +7,7 @@ import (
 	log "github.com/sirupsen/logrus"
 	"net/http"
 	"net/url"
+	"pomu/availability"
 	"strconv"
 	"strings"
 
@@ -23,6 +24,20 @@ func (app *Application) GetHistory(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// e.g. `availability=private,deleted,members-only` for livestreams which only survive on pomu
+	var availabilities []string
+
+	if availabilityStr := r.URL.Query().Get("availability"); len(availabilityStr) > 0 {
+		availabilities = strings.Split(availabilityStr, ",")
+
+		for _, value := range availabilities {
+			if !availability.Valid(value) {
+				http.Error(w, fmt.Sprintf("unknown availability \"%s\"", value), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
 	tx, err := app.db.Begin()
 
 	if err != nil {
@@ -33,13 +48,25 @@ func (app *Application) GetHistory(w http.ResponseWriter, r *http.Request) {
 
 	defer tx.Rollback()
 
-	whereClause := ""
+	var conditions []string
+	var args []any
 
 	if !showUnfinished {
-		whereClause = "where finished = true"
+		conditions = append(conditions, "finished = true")
 	}
 
-	rows, err := tx.Query(fmt.Sprintf("select * from videos %s order by start %s limit %d offset %d", whereClause, sort, limit+1, page*limit))
+	if len(availabilities) > 0 {
+		args = append(args, pq.Array(availabilities))
+		conditions = append(conditions, fmt.Sprintf("availability = any($%d)", len(args)))
+	}
+
+	whereClause := ""
+
+	if len(conditions) > 0 {
+		whereClause = "where " + strings.Join(conditions, " and ")
+	}
+
+	rows, err := tx.Query(fmt.Sprintf("select * from videos %s order by start %s limit %d offset %d", whereClause, sort, limit+1, page*limit), args...)
 
 	if err != nil {
 		sentry.CaptureException(err)
@@ -60,13 +87,18 @@ func (app *Application) GetHistory(w http.ResponseWriter, r *http.Request) {
 	for rows.Next() {
 		var video Video
 
-		if err := rows.Scan(&video.Id, pq.Array(&video.Submitters), &video.Start, &video.Finished, &video.Title, &video.ChannelName, &video.ChannelId, &video.Thumbnail, &video.FileSize, &video.Length, &video.Downloads); err != nil {
+		if err := scanVideo(rows, &video); err != nil {
 			sentry.CaptureException(err)
 			continue
 		}
 
 		if video.Finished {
 			video.DownloadUrl = fmt.Sprintf("/api/download/%s/video", video.Id)
+
+			if video.Parts > 1 {
+				video.PartUrls = video.PartDownloadUrls(TypeVideo)
+				video.DownloadUrl = video.PartUrls[0]
+			}
 		}
 
 		videos = append(videos, video)
@@ -79,7 +111,7 @@ func (app *Application) GetHistory(w http.ResponseWriter, r *http.Request) {
 
 	videoCount := 0
 
-	if err := tx.QueryRow(fmt.Sprintf("select count(*) from videos %s", whereClause)).Scan(&videoCount); err != nil {
+	if err := tx.QueryRow(fmt.Sprintf("select count(*) from videos %s", whereClause), args...).Scan(&videoCount); err != nil {
 		log.Printf("%s\n", err)
 		sentry.CaptureException(err)
 		http.Error(w, "failed to query total video count", http.StatusInternalServerError)
@@ -1,7 +1,11 @@
 package hls
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"time"
@@ -9,22 +13,38 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	"github.com/getsentry/sentry-go"
-	"github.com/golang/groupcache/lru"
 	"github.com/kz26/m3u8"
 )
 
 type Segment struct {
-	Url  string
+	Url string
+	// Time is the wall-clock time since the playlist started at which the segment was received
 	Time time.Duration
+	// Sequence is the media sequence number of the segment
+	Sequence uint64
+	// Duration is the duration of the segment as advertised by the playlist
+	Duration time.Duration
+	// Discontinuity is set if the encoding changes between the previous segment and this one,
+	// or if the media sequence has been reset
+	Discontinuity bool
+	// ProgramDateTime is the absolute time of the first sample of the segment, zero if the playlist does not include it
+	ProgramDateTime time.Time
 }
 
 type Client struct {
-	client              *http.Client
-	cache               *lru.Cache
+	client *http.Client
+	// Policy picks the variant to record if the playlist is a master playlist, defaults to HighestBandwidth
+	Policy VariantPolicy
+	// OnRefresh, if set, is called every time the playlist has been refreshed successfully
+	OnRefresh func(refreshedAt time.Time)
+	// variant is the currently recorded variant of a master playlist
+	variant *m3u8.Variant
+	// lastSegment is the sequence number of the last segment which has been sent to Segments
+	lastSegment         uint64
+	hasSegment          bool
 	Segments            chan Segment
 	playlistUrl         string
 	playlistUrlDeadline time.Time
-	done                bool
 	lastSeq             int
 	noChange            int
 	videoId             string
@@ -62,115 +82,232 @@ func (client *Client) getPlaylistUrl(force bool, remotePlaylist RemotePlaylist)
 	return
 }
 
-func (client *Client) getPlaylist(remotePlaylist RemotePlaylist) (m3u8.Playlist, error) {
-	playlistUrl, err := client.getPlaylistUrl(false, remotePlaylist)
+// fetchPlaylist requests and decodes the playlist at `playlistUrl`
+func (client *Client) fetchPlaylist(ctx context.Context, playlistUrl string) (m3u8.Playlist, error) {
+	request, err := http.NewRequestWithContext(ctx, "GET", playlistUrl, nil)
 	if err != nil {
-		client.log(err).Error("Failed to get playlist URL from RemotePlaylist")
 		return nil, err
 	}
 
-	tries := 0
-	var playlist m3u8.Playlist
-	for tries >= 0 {
-		playlist, err = func() (playlist m3u8.Playlist, err error) {
-			request, err := http.NewRequest("GET", playlistUrl, nil)
-			if err != nil {
-				return nil, err
-			}
+	request.Header.Set("User-Agent", os.Getenv("HTTP_USERAGENT"))
+
+	resp, err := client.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
 
-			request.Header.Set("User-Agent", os.Getenv("HTTP_USERAGENT"))
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
 
-			resp, err := client.client.Do(request)
-			if err != nil {
-				// We weren't able to get this url for whatever reason.
-				// Try and refresh playlist url and try again
-				client.log(err).Warn("try=", tries, " failed to request playlist:", err)
-				tries += 1
-				return
-			}
-			defer resp.Body.Close()
+	playlist, _, err := m3u8.Decode(*bytes.NewBuffer(data), true)
+	if err != nil {
+		return nil, err
+	}
+
+	if master, ok := playlist.(*m3u8.MasterPlaylist); ok {
+		attachAlternatives(master, data)
+	}
+
+	return playlist, nil
+}
+
+// selectVariant picks the variant to record from a master playlist. Once a variant has been picked, the same
+// rendition is followed across refreshes even if its URI changes.
+func (client *Client) selectVariant(master *m3u8.MasterPlaylist) (*m3u8.Variant, error) {
+	var variants []*m3u8.Variant
+
+	for _, variant := range master.Variants {
+		// I-frame only variants cannot be muxed into a regular recording
+		if variant != nil && !variant.Iframe {
+			variants = append(variants, variant)
+		}
+	}
 
-			playlist, _, err = m3u8.DecodeFrom(resp.Body, true)
-			if err != nil {
-				client.log(err).Warn("Failed to decode playlist")
+	if len(variants) == 0 {
+		return nil, errors.New("master playlist does not contain any variants")
+	}
+
+	if client.variant != nil {
+		for _, variant := range variants {
+			if sameRendition(client.variant, variant) {
+				client.variant = variant
+				return variant, nil
 			}
-			return
-		}()
+		}
+
+		client.log(nil).Warn("Previously recorded variant ", client.variant.Resolution, " disappeared from master playlist")
+	}
+
+	policy := client.Policy
+
+	if policy == nil {
+		policy = HighestBandwidth{}
+	}
+
+	client.variant = policy.Select(variants)
+	client.log(nil).Info("Selected variant ", client.variant.Resolution, " (", client.variant.Codecs, ", ", client.variant.Bandwidth, " bps)")
+	return client.variant, nil
+}
+
+// resolvePlaylist fetches the playlist at `playlistUrl` and, if it is a master playlist, the media playlist of the
+// selected variant. Returns the media playlist and its url.
+func (client *Client) resolvePlaylist(ctx context.Context, playlistUrl string) (*m3u8.MediaPlaylist, string, error) {
+	playlist, err := client.fetchPlaylist(ctx, playlistUrl)
+	if err != nil {
+		return nil, "", err
+	}
+
+	switch playlist := playlist.(type) {
+	case *m3u8.MediaPlaylist:
+		return playlist, playlistUrl, nil
+	case *m3u8.MasterPlaylist:
+		variant, err := client.selectVariant(playlist)
+		if err != nil {
+			return nil, "", err
+		}
+
+		mediaUrl := resolveReference(playlistUrl, variant.URI)
+		media, err := client.fetchPlaylist(ctx, mediaUrl)
+		if err != nil {
+			return nil, "", err
+		}
+
+		mediaPlaylist, ok := media.(*m3u8.MediaPlaylist)
+		if !ok {
+			return nil, "", fmt.Errorf("variant %s is not a media playlist", mediaUrl)
+		}
+
+		return mediaPlaylist, mediaUrl, nil
+	default:
+		return nil, "", fmt.Errorf("unexpected playlist type %T", playlist)
+	}
+}
+
+func (client *Client) getPlaylist(ctx context.Context, remotePlaylist RemotePlaylist) (*m3u8.MediaPlaylist, string, error) {
+	playlistUrl, err := client.getPlaylistUrl(false, remotePlaylist)
+	if err != nil {
+		client.log(err).Error("Failed to get playlist URL from RemotePlaylist")
+		return nil, "", err
+	}
+
+	for tries := 0; ; tries++ {
+		playlist, mediaUrl, err := client.resolvePlaylist(ctx, playlistUrl)
 
 		if err == nil {
-			break
+			return playlist, mediaUrl, nil
 		}
 
+		// the recording has been stopped, there is no point in retrying
+		if ctx.Err() != nil {
+			return nil, "", ctx.Err()
+		}
+
+		// We weren't able to get this url for whatever reason.
+		// Try and refresh playlist url and try again
+		client.log(err).Warn("try=", tries, " failed to request playlist:", err)
+
 		if tries > 20 {
 			client.log(err).Error("Giving up retrying playlist, reached max retries")
-			break
+			return nil, "", err
 		}
 
 		// We failed, re-get playlist url and then try again
 		playlistUrl, err = client.getPlaylistUrl(true, remotePlaylist)
 		if err != nil {
 			client.log(err).Error("Failed to get playlist URL from RemotePlaylist")
-			return nil, err
+			return nil, "", err
 		}
 	}
-
-	return playlist, nil
 }
 
-func (client *Client) playlistFrame(start time.Time, remotePlaylist RemotePlaylist) (sleepDuration time.Duration, err error) {
-	playlistVariant, err := client.getPlaylist(remotePlaylist)
+func (client *Client) playlistFrame(ctx context.Context, start time.Time, remotePlaylist RemotePlaylist) (sleepDuration time.Duration, err error) {
+	playlist, mediaUrl, err := client.getPlaylist(ctx, remotePlaylist)
 
 	if err != nil {
 		return 0, err
 	}
 
-	switch playlist := playlistVariant.(type) {
-	case *m3u8.MediaPlaylist:
-		if playlist.SeqNo == uint64(client.lastSeq) {
-			client.log(nil).Warn("Sequence index did not change", client.noChange)
-			client.noChange += 1
-		} else {
-			client.noChange = 0
+	if client.OnRefresh != nil {
+		client.OnRefresh(time.Now())
+	}
+
+	if playlist.SeqNo == uint64(client.lastSeq) {
+		client.log(nil).Warn("Sequence index did not change", client.noChange)
+		client.noChange += 1
+	} else {
+		client.noChange = 0
+	}
+
+	discontinuity := false
+
+	// The media sequence went backwards by more than what the playlist contains, the stream has been restarted
+	if client.hasSegment && playlist.SeqNo+uint64(2*len(playlist.Segments)) < client.lastSegment {
+		client.log(nil).Warn("Media sequence has been reset from ", client.lastSegment, " to ", playlist.SeqNo)
+		client.hasSegment = false
+		discontinuity = true
+	}
+
+	for i, v := range playlist.Segments {
+		if v == nil {
+			continue
 		}
 
-		for _, v := range playlist.Segments {
-			if client.done {
-				return 0, nil
-			}
+		sequence := playlist.SeqNo + uint64(i)
 
-			if v == nil {
-				continue
-			}
+		// Check whether we have already downloaded this segment
+		if client.hasSegment && sequence <= client.lastSegment {
+			continue
+		}
 
-			// Check whether we have already downloaded this segment
-			if _, hit := client.cache.Get(v.URI); !hit {
-				client.cache.Add(v.URI, nil)
-				client.Segments <- Segment{
-					v.URI,
-					time.Since(start),
-				}
-			}
+		if client.hasSegment && sequence > client.lastSegment+1 {
+			client.log(nil).Warn("Segments ", client.lastSegment+1, " to ", sequence-1, " left the playlist before they could be downloaded")
 		}
 
-		client.lastSeq = int(playlist.SeqNo)
+		client.lastSegment = sequence
+		client.hasSegment = true
 
-		if playlist.Closed {
+		segment := Segment{
+			Url:             resolveReference(mediaUrl, v.URI),
+			Time:            time.Since(start),
+			Sequence:        sequence,
+			Duration:        time.Duration(v.Duration * float64(time.Second)),
+			Discontinuity:   v.Discontinuity || discontinuity,
+			ProgramDateTime: v.ProgramDateTime,
+		}
+
+		select {
+		case client.Segments <- segment:
+		case <-ctx.Done():
 			return 0, nil
 		}
 
-		return time.Duration(int64(playlist.TargetDuration * float64(time.Second))), nil
-	default:
-		client.log(nil).Error("Unexpected playlist type ", playlistVariant, ", cannot download")
+		discontinuity = false
 	}
-	return 0, nil
+
+	client.lastSeq = int(playlist.SeqNo)
+
+	if playlist.Closed {
+		return 0, nil
+	}
+
+	return time.Duration(int64(playlist.TargetDuration * float64(time.Second))), nil
 }
 
-func (client *Client) Playlist(playlist RemotePlaylist) {
+// Playlist polls the playlist and sends every new segment into Segments until either the playlist ends or `ctx` is
+// cancelled. Segments is closed afterwards.
+func (client *Client) Playlist(ctx context.Context, playlist RemotePlaylist) {
 	start := time.Now()
 	defer close(client.Segments)
 
-	for !client.done {
-		t, err := client.playlistFrame(start, playlist)
+	for ctx.Err() == nil {
+		t, err := client.playlistFrame(ctx, start, playlist)
+		if ctx.Err() != nil {
+			break
+		}
+
 		if err != nil {
 			client.log(err).Error("Failed playlist frame:", err)
 			sentry.CaptureMessage(fmt.Sprint("failed playlist frame: ", err))
@@ -187,29 +324,21 @@ func (client *Client) Playlist(playlist RemotePlaylist) {
 			return
 		}
 
-		time.Sleep(t)
+		select {
+		case <-time.After(t):
+		case <-ctx.Done():
+		}
 	}
 
 	client.log(nil).Info("HLS CLient finished")
 }
 
-func (client *Client) Stop() {
-	client.done = true
-}
-
 func New(id string) *Client {
-	client := http.DefaultClient
-	cache := lru.New(1000)
-
 	return &Client{
-		client,
-		cache,
+		client: http.DefaultClient,
 		// Allow for a slight buffer of segments
-		make(chan Segment, 10),
-		"",
-		time.Now().Add(-20 * time.Minute),
-		false,
-		0, 0,
-		id,
+		Segments:            make(chan Segment, 10),
+		playlistUrlDeadline: time.Now().Add(-20 * time.Minute),
+		videoId:             id,
 	}
 }
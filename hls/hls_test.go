@@ -0,0 +1,142 @@
+package hls
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type staticPlaylist struct {
+	url string
+}
+
+func (p *staticPlaylist) Get() (string, error) {
+	return p.url, nil
+}
+
+func TestSequenceBasedDedup(t *testing.T) {
+	// every refresh rotates the query token of each segment and moves the window by one segment
+	refresh := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:1\n#EXT-X-MEDIA-SEQUENCE:%d\n", 10+refresh)
+
+		for i := 0; i < 3; i++ {
+			if refresh == 1 && i == 2 {
+				_, _ = fmt.Fprint(w, "#EXT-X-DISCONTINUITY\n")
+			}
+
+			_, _ = fmt.Fprintf(w, "#EXTINF:1.000,\nhttps://cdn.pomu.app/%d.ts?token=%d\n", 10+refresh+i, refresh)
+		}
+
+		refresh += 1
+	}))
+	defer server.Close()
+
+	client := New("m7Mzgmpr-Qc")
+
+	for i := 0; i < 2; i++ {
+		_, err := client.playlistFrame(context.Background(), time.Now(), &staticPlaylist{server.URL})
+		assert.NoError(t, err)
+	}
+
+	close(client.Segments)
+
+	var sequences []uint64
+	var discontinuities []uint64
+
+	for segment := range client.Segments {
+		sequences = append(sequences, segment.Sequence)
+		assert.Equal(t, time.Second, segment.Duration)
+
+		if segment.Discontinuity {
+			discontinuities = append(discontinuities, segment.Sequence)
+		}
+	}
+
+	assert.Equal(t, []uint64{10, 11, 12, 13}, sequences)
+	assert.Equal(t, []uint64{13}, discontinuities)
+}
+
+func TestMasterPlaylistFollowsVariant(t *testing.T) {
+	// the master playlist rotates the variant uris on every refresh and reorders them
+	refresh := 0
+	var requested []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/master.m3u8" {
+			_, _ = fmt.Fprint(w, "#EXTM3U\n")
+
+			if refresh == 0 {
+				_, _ = fmt.Fprintf(w, "#EXT-X-STREAM-INF:BANDWIDTH=1000000,RESOLUTION=1280x720,CODECS=\"avc1.4d401f,mp4a.40.2\"\n720p/%d.m3u8\n", refresh)
+				_, _ = fmt.Fprintf(w, "#EXT-X-STREAM-INF:BANDWIDTH=3000000,RESOLUTION=1920x1080,CODECS=\"avc1.640028,mp4a.40.2\"\n1080p/%d.m3u8\n", refresh)
+			} else {
+				_, _ = fmt.Fprintf(w, "#EXT-X-STREAM-INF:BANDWIDTH=3000000,RESOLUTION=1920x1080,CODECS=\"avc1.640028,mp4a.40.2\"\n1080p/%d.m3u8\n", refresh)
+				_, _ = fmt.Fprintf(w, "#EXT-X-STREAM-INF:BANDWIDTH=1000000,RESOLUTION=1280x720,CODECS=\"avc1.4d401f,mp4a.40.2\"\n720p/%d.m3u8\n", refresh)
+			}
+
+			refresh += 1
+			return
+		}
+
+		requested = append(requested, r.URL.Path)
+		_, _ = fmt.Fprint(w, "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:1\n#EXT-X-MEDIA-SEQUENCE:1\n#EXTINF:1.000,\n1.ts\n")
+	}))
+	defer server.Close()
+
+	client := New("m7Mzgmpr-Qc")
+	client.Policy = MaxResolution{Height: 720}
+
+	for i := 0; i < 2; i++ {
+		_, err := client.playlistFrame(context.Background(), time.Now(), &staticPlaylist{server.URL + "/master.m3u8"})
+		assert.NoError(t, err)
+	}
+
+	close(client.Segments)
+
+	assert.Equal(t, []string{"/720p/0.m3u8", "/720p/1.m3u8"}, requested)
+	assert.Equal(t, server.URL+"/720p/1.ts", (<-client.Segments).Url)
+}
+
+func TestParseVariantPolicy(t *testing.T) {
+	policy, err := ParseVariantPolicy("")
+	assert.NoError(t, err)
+	assert.Equal(t, HighestBandwidth{}, policy)
+
+	policy, err = ParseVariantPolicy("resolution:1080p")
+	assert.NoError(t, err)
+	assert.Equal(t, MaxResolution{Height: 1080}, policy)
+
+	policy, err = ParseVariantPolicy("codec:vp09")
+	assert.NoError(t, err)
+	assert.Equal(t, Codec{Prefix: "vp09"}, policy)
+
+	_, err = ParseVariantPolicy("fastest")
+	assert.Error(t, err)
+}
+
+func TestAudioRendition(t *testing.T) {
+	refresh := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, "#EXTM3U\n")
+		_, _ = fmt.Fprintf(w, "#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"aac\",NAME=\"commentary\",URI=\"commentary/%d.m3u8\"\n", refresh)
+		_, _ = fmt.Fprintf(w, "#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"aac\",NAME=\"main\",DEFAULT=YES,URI=\"main/%d.m3u8\"\n", refresh)
+		_, _ = fmt.Fprint(w, "#EXT-X-STREAM-INF:BANDWIDTH=1000000,RESOLUTION=1280x720,AUDIO=\"aac\"\n720p.m3u8\n")
+		refresh += 1
+	}))
+	defer server.Close()
+
+	client := New("m7Mzgmpr-Qc")
+	audio, err := client.AudioRendition(context.Background(), &staticPlaylist{server.URL})
+	assert.NoError(t, err)
+
+	if assert.NotNil(t, audio) {
+		// the rendition is resolved again from a fresh master playlist
+		url, err := audio.Get()
+		assert.NoError(t, err)
+		assert.Equal(t, server.URL+"/main/1.m3u8", url)
+	}
+}
@@ -0,0 +1,206 @@
+package hls
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/kz26/m3u8"
+)
+
+// renditionPlaylist is the RemotePlaylist of a separate audio rendition (EXT-X-MEDIA) of a master playlist.
+// The master playlist is re-requested whenever the url is needed, so that a rotating rendition uri is followed.
+type renditionPlaylist struct {
+	ctx    context.Context
+	client *Client
+	master RemotePlaylist
+	// groupId and name identify the rendition across refreshes
+	groupId string
+	name    string
+}
+
+func (p *renditionPlaylist) Get() (string, error) {
+	masterUrl, err := p.master.Get()
+	if err != nil {
+		return "", err
+	}
+
+	playlist, err := p.client.fetchPlaylist(p.ctx, masterUrl)
+	if err != nil {
+		return "", err
+	}
+
+	master, ok := playlist.(*m3u8.MasterPlaylist)
+	if !ok {
+		return "", errors.New("audio rendition requested from a playlist which is not a master playlist")
+	}
+
+	for _, alternative := range masterAlternatives(master) {
+		if alternative.Type == "AUDIO" && alternative.GroupId == p.groupId && alternative.Name == p.name && len(alternative.URI) > 0 {
+			return resolveReference(masterUrl, alternative.URI), nil
+		}
+	}
+
+	return "", fmt.Errorf("audio rendition %s of group %s disappeared from master playlist", p.name, p.groupId)
+}
+
+var _ RemotePlaylist = (*renditionPlaylist)(nil)
+
+// AudioRendition checks whether the variant which will be recorded from `remotePlaylist` has its audio published as a
+// separate rendition. If so, a RemotePlaylist of that rendition is returned which can be recorded using a second
+// Client. Returns nil if the audio is part of the variant itself.
+func (client *Client) AudioRendition(ctx context.Context, remotePlaylist RemotePlaylist) (RemotePlaylist, error) {
+	playlistUrl, err := client.getPlaylistUrl(false, remotePlaylist)
+	if err != nil {
+		return nil, err
+	}
+
+	playlist, err := client.fetchPlaylist(ctx, playlistUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	master, ok := playlist.(*m3u8.MasterPlaylist)
+	if !ok {
+		return nil, nil
+	}
+
+	variant, err := client.selectVariant(master)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(variant.Audio) <= 0 {
+		return nil, nil
+	}
+
+	var selected *m3u8.Alternative
+
+	for _, alternative := range variant.Alternatives {
+		// renditions without an uri are contained in the variant itself
+		if alternative.Type != "AUDIO" || alternative.GroupId != variant.Audio || len(alternative.URI) <= 0 {
+			continue
+		}
+
+		if selected == nil || (alternative.Default && !selected.Default) {
+			selected = alternative
+		}
+	}
+
+	if selected == nil {
+		return nil, nil
+	}
+
+	client.log(nil).Info("Variant uses separate audio rendition ", selected.Name, " (group ", selected.GroupId, ")")
+
+	return &renditionPlaylist{
+		ctx:     ctx,
+		client:  client,
+		master:  remotePlaylist,
+		groupId: selected.GroupId,
+		name:    selected.Name,
+	}, nil
+}
+
+// masterAlternatives returns every EXT-X-MEDIA rendition of `master`, see attachAlternatives
+func masterAlternatives(master *m3u8.MasterPlaylist) []*m3u8.Alternative {
+	var alternatives []*m3u8.Alternative
+
+	for _, variant := range master.Variants {
+		if variant != nil {
+			alternatives = append(alternatives, variant.Alternatives...)
+		}
+	}
+
+	return alternatives
+}
+
+// attachAlternatives parses the EXT-X-MEDIA renditions of the master playlist in `data` and attaches them to every variant
+// referencing their group.
+// NOTE: m3u8.DecodeFrom parses these tags but loses them before they can be attached to a variant
+func attachAlternatives(master *m3u8.MasterPlaylist, data []byte) {
+	var alternatives []*m3u8.Alternative
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if !strings.HasPrefix(line, "#EXT-X-MEDIA:") {
+			continue
+		}
+
+		alternative := &m3u8.Alternative{}
+
+		for key, value := range parseAttributes(strings.TrimPrefix(line, "#EXT-X-MEDIA:")) {
+			switch key {
+			case "TYPE":
+				alternative.Type = value
+			case "GROUP-ID":
+				alternative.GroupId = value
+			case "NAME":
+				alternative.Name = value
+			case "LANGUAGE":
+				alternative.Language = value
+			case "URI":
+				alternative.URI = value
+			case "DEFAULT":
+				alternative.Default = value == "YES"
+			case "AUTOSELECT":
+				alternative.Autoselect = value
+			}
+		}
+
+		alternatives = append(alternatives, alternative)
+	}
+
+	for _, variant := range master.Variants {
+		if variant == nil {
+			continue
+		}
+
+		variant.Alternatives = nil
+
+		for _, alternative := range alternatives {
+			if (alternative.Type == "AUDIO" && alternative.GroupId == variant.Audio) ||
+				(alternative.Type == "VIDEO" && alternative.GroupId == variant.Video) ||
+				(alternative.Type == "SUBTITLES" && alternative.GroupId == variant.Subtitles) {
+				variant.Alternatives = append(variant.Alternatives, alternative)
+			}
+		}
+	}
+}
+
+// parseAttributes parses an attribute list (`KEY=VALUE,KEY="VALUE"`), quoted values may contain commas
+func parseAttributes(list string) map[string]string {
+	attributes := make(map[string]string)
+
+	for len(list) > 0 {
+		key, rest, found := strings.Cut(list, "=")
+		if !found {
+			break
+		}
+
+		var value string
+
+		if strings.HasPrefix(rest, "\"") {
+			end := strings.Index(rest[1:], "\"")
+			if end < 0 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+			_, rest, _ = strings.Cut(rest, ",")
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+
+		attributes[strings.TrimSpace(key)] = value
+		list = rest
+	}
+
+	return attributes
+}
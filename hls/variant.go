@@ -0,0 +1,133 @@
+package hls
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/kz26/m3u8"
+)
+
+// VariantPolicy picks the variant which should be recorded from a master playlist
+type VariantPolicy interface {
+	// Select returns the variant to record. `variants` is never empty.
+	Select(variants []*m3u8.Variant) *m3u8.Variant
+}
+
+// HighestBandwidth picks the variant with the highest advertised bandwidth
+type HighestBandwidth struct{}
+
+func (HighestBandwidth) Select(variants []*m3u8.Variant) *m3u8.Variant {
+	var best *m3u8.Variant
+
+	for _, variant := range variants {
+		if best == nil || variant.Bandwidth > best.Bandwidth {
+			best = variant
+		}
+	}
+
+	return best
+}
+
+// MaxResolution picks the variant with the highest bandwidth which is at most `Height` pixels tall
+type MaxResolution struct {
+	Height int
+}
+
+func (policy MaxResolution) Select(variants []*m3u8.Variant) *m3u8.Variant {
+	var matching []*m3u8.Variant
+
+	for _, variant := range variants {
+		if height := variantHeight(variant); height > 0 && height <= policy.Height {
+			matching = append(matching, variant)
+		}
+	}
+
+	if len(matching) == 0 {
+		return HighestBandwidth{}.Select(variants)
+	}
+
+	return HighestBandwidth{}.Select(matching)
+}
+
+// Codec picks the variant with the highest bandwidth whose codecs start with `Prefix` (for example `avc1` or `vp09`)
+type Codec struct {
+	Prefix string
+}
+
+func (policy Codec) Select(variants []*m3u8.Variant) *m3u8.Variant {
+	var matching []*m3u8.Variant
+
+	for _, variant := range variants {
+		for _, codec := range strings.Split(variant.Codecs, ",") {
+			if strings.HasPrefix(strings.TrimSpace(codec), policy.Prefix) {
+				matching = append(matching, variant)
+				break
+			}
+		}
+	}
+
+	if len(matching) == 0 {
+		return HighestBandwidth{}.Select(variants)
+	}
+
+	return HighestBandwidth{}.Select(matching)
+}
+
+// ParseVariantPolicy parses a policy in the form of `bandwidth`, `resolution:<height>` or `codec:<prefix>`.
+// An empty string results in HighestBandwidth.
+func ParseVariantPolicy(policy string) (VariantPolicy, error) {
+	name, argument, _ := strings.Cut(strings.TrimSpace(policy), ":")
+
+	switch strings.ToLower(name) {
+	case "", "bandwidth":
+		return HighestBandwidth{}, nil
+	case "resolution":
+		height, err := strconv.Atoi(strings.TrimSuffix(argument, "p"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid resolution \"%s\"", argument)
+		}
+		return MaxResolution{Height: height}, nil
+	case "codec":
+		if len(argument) <= 0 {
+			return nil, fmt.Errorf("codec policy requires a codec")
+		}
+		return Codec{Prefix: argument}, nil
+	default:
+		return nil, fmt.Errorf("unknown variant policy \"%s\"", name)
+	}
+}
+
+// variantHeight returns the height of the variant, or 0 if the resolution is not advertised
+func variantHeight(variant *m3u8.Variant) int {
+	_, height, found := strings.Cut(variant.Resolution, "x")
+
+	if !found {
+		return 0
+	}
+
+	parsed, _ := strconv.Atoi(height)
+	return parsed
+}
+
+// sameRendition checks whether two variants, possibly from different refreshes of the master playlist, describe the
+// same rendition. The URI is intentionally ignored as it may change with every refresh.
+func sameRendition(a *m3u8.Variant, b *m3u8.Variant) bool {
+	return a.Resolution == b.Resolution && a.Codecs == b.Codecs && a.Bandwidth == b.Bandwidth
+}
+
+// resolveReference resolves a possibly relative uri found in a playlist against the url of the playlist
+func resolveReference(base string, reference string) string {
+	baseUrl, err := url.Parse(base)
+	if err != nil {
+		return reference
+	}
+
+	referenceUrl, err := url.Parse(reference)
+	if err != nil {
+		return reference
+	}
+
+	return baseUrl.ResolveReference(referenceUrl).String()
+}
@@ -4,153 +4,135 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/getsentry/sentry-go"
-	"github.com/lib/pq"
 	log "github.com/sirupsen/logrus"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
+	"pomu/rules"
+	"strconv"
 	"strings"
 	"time"
 )
 
+// upcomingStreamsLookahead is how far ahead streams are queued, QueueUpcomingStreams runs every hour anyways
+const upcomingStreamsLookahead = 24 * time.Hour
+
+// QueueUpcomingStreams queues every livestream listed on holodex which matches an archive rule
 func QueueUpcomingStreams(app *Application) {
-	orgsList := strings.TrimSpace(os.Getenv("HOLODEX_ORGS"))
+	archiveRules, err := app.activeArchiveRules()
 
-	// skip if no args were selected in the .env file
-	if len(orgsList) <= 0 {
+	if err != nil {
+		sentry.CaptureException(err)
+		log.WithFields(log.Fields{"error": err}).Error("failed to query archive rules")
 		return
 	}
 
-	orgs := strings.Split(orgsList, ",")
-
-	for _, org := range orgs {
-		streams, err := queryUpcomingStreams(org)
+	// skip if no rules were configured
+	if len(archiveRules) <= 0 {
+		return
+	}
 
-		if err != nil {
-			log.Printf("failed to query upcoming streams for org %s\n", org)
+	for _, stream := range queryRuleCandidates(archiveRules) {
+		if len(stream.Id) <= 0 {
+			log.Printf("%s has no reservation up, skipping\n", stream.Id)
 			continue
 		}
 
-		log.Printf("found %d streams for %s matching holodex criteria\n", len(streams), org)
+		candidate := stream.ruleStream()
+		var matched *ArchiveRule
+		var reason string
 
-		for _, stream := range streams {
-			if len(stream.Id) <= 0 {
-				log.Printf("%s has no reservation up, skipping\n", stream.Id)
-				continue
+		for i := range archiveRules {
+			if matchReason, ok := archiveRules[i].Match(candidate); ok {
+				matched = &archiveRules[i]
+				reason = matchReason
+				break
 			}
+		}
 
-			tx, err := app.db.Begin()
-
-			if err != nil {
-				sentry.CaptureException(err)
-				log.Printf("failed to start transaction: %s\n", err)
-				continue
-			}
+		if matched == nil {
+			log.WithFields(log.Fields{"video_id": stream.Id, "title": stream.Title}).Debug("stream matches no archive rule")
+			continue
+		}
 
-			var video Video
-			err = tx.QueryRow("select * from videos where id = $1 limit 1", stream.Id).Scan(
-				&video.Id,
-				pq.Array(&video.Submitters),
-				&video.Start,
-				&video.Finished,
-				&video.Title,
-				&video.ChannelName,
-				&video.ChannelId,
-				&video.Thumbnail,
-				&video.FileSize,
-				&video.Length,
-				&video.Downloads)
-
-			// Video already exists in db, skip it
-			if err == nil {
-				tx.Rollback()
-				log.Printf("skipping %s as it already is scheduled to be saved", stream.Id)
-				continue
-			}
+		video, err := app.queueLivestream(stream.Id, matched.CreatedBy, VideoRequest{
+			VideoUrl: fmt.Sprintf("https://youtu.be/%s", stream.Id),
+			Quality:  matched.Quality,
+		})
 
-			videoMetadata, err := GetVideoMetadata(stream.Id)
-			if err != nil {
-				tx.Rollback()
-				sentry.CaptureException(err)
-				log.Printf("failed to get video meta data for %s: %s", stream.Id, err)
-				continue
-			}
+		if err != nil {
+			log.Printf("failed to automatically schedule video %s: %s\n", stream.Id, err)
+			continue
+		}
 
-			startTime, err := GetVideoStartTime(videoMetadata)
+		if video == nil {
+			log.Printf("skipping %s as it already is scheduled to be saved", stream.Id)
+			continue
+		}
 
-			if err != nil {
-				tx.Rollback()
-				sentry.CaptureException(err)
-				log.Printf("failed to get video start time for %s: %s\n", stream.Id, err)
-				continue
-			}
+		log.WithFields(log.Fields{
+			"video_id":   video.Id,
+			"title":      video.Title,
+			"start":      video.Start.Format(time.RFC1123),
+			"rule_id":    matched.Id,
+			"rule":       matched.Name,
+			"reason":     reason,
+			"channel_id": stream.Channel.Id,
+		}).Info("automatically scheduled livestream matching archive rule")
+	}
+}
 
-			thumbnailUrl, err := SaveThumbnail(stream.Id, FindSuitableThumbnail(videoMetadata.Snippet.Thumbnails))
+// queryRuleCandidates returns the upcoming streams of every org and channel the rules track, and the collabs
+// mentioning an included channel if a rule includes mentions
+func queryRuleCandidates(archiveRules []ArchiveRule) []UpcomingStream {
+	var candidates []UpcomingStream
+	seen := make(map[string]bool)
+	queried := make(map[string]bool)
 
-			if err != nil {
-				tx.Rollback()
-				sentry.CaptureException(err)
-				log.Printf("failed to save thumbnail for %s: %s\n", stream.Id, err)
-				continue
-			}
+	collect := func(kind string, key string, query func() ([]UpcomingStream, error)) {
+		if len(key) == 0 || queried[kind+"/"+strings.ToLower(key)] {
+			return
+		}
 
-			statement, err := tx.Prepare("insert into videos (id, submitters, start, title, channel_name, channel_id, thumbnail) values ($1, $2, $3, $4, $5, $6, $7) returning *")
+		queried[kind+"/"+strings.ToLower(key)] = true
 
-			if err != nil {
-				tx.Rollback()
-				sentry.CaptureException(err)
-				log.Printf("failed to create video for %s\n", stream.Id)
-				continue
-			}
+		streams, err := query()
 
-			row := statement.QueryRow(stream.Id, pq.Array([]string{"pomu.app"}), startTime, videoMetadata.Snippet.Title, videoMetadata.Snippet.ChannelTitle, videoMetadata.Snippet.ChannelId, thumbnailUrl)
+		if err != nil {
+			log.Printf("failed to query upcoming streams for %s %s\n", kind, key)
+			return
+		}
 
-			if err := row.Err(); err != nil {
-				tx.Rollback()
-				sentry.CaptureException(err)
-				log.Printf("failed to create video for %s\n", stream.Id)
-				continue
-			}
+		log.Printf("found %d streams for %s %s on holodex\n", len(streams), kind, key)
 
-			if err = row.Scan(&video.Id,
-				pq.Array(&video.Submitters),
-				&video.Start,
-				&video.Finished,
-				&video.Title,
-				&video.ChannelName,
-				&video.ChannelId,
-				&video.Thumbnail,
-				&video.FileSize,
-				&video.Length,
-				&video.Downloads); err != nil {
-				tx.Rollback()
-				log.Printf("failed to get video for %s\n", stream.Id)
-				sentry.CaptureException(err)
-
-				continue
+		for _, stream := range streams {
+			if !seen[stream.Id] {
+				seen[stream.Id] = true
+				candidates = append(candidates, stream)
 			}
+		}
+	}
 
-			if err := tx.Commit(); err != nil {
-				sentry.CaptureException(err)
-				log.Printf("failed to commit transaction: %s\n", err)
-				continue
-			}
+	for _, rule := range archiveRules {
+		for _, org := range rule.IncludeOrgs {
+			org := org
+			collect("org", org, func() ([]UpcomingStream, error) { return queryUpcomingStreams(org) })
+		}
 
-			err = app.scheduleVideo(videoMetadata, video.Id, VideoRequest{
-				VideoUrl: fmt.Sprintf("https://youtu.be/%s", video.Id),
-				// Use 0 to auto-pick best quality
-				Quality: 0,
+		for _, channelId := range rule.IncludeChannels {
+			channelId := channelId
+			collect("channel", channelId, func() ([]UpcomingStream, error) {
+				return queryChannelStreams(channelId, upcomingStreamsLookahead)
 			})
 
-			if err != nil {
-				log.Printf("failed to automatically schedule video %s: %s\n", video.Id, err)
-				continue
+			if rule.IncludeMentions {
+				collect("mentions of", channelId, func() ([]UpcomingStream, error) { return queryMentioningStreams(channelId) })
 			}
-
-			go app.UpsertVideo(video)
-			log.Printf("Automatically scheduled %s (title: \"%s\") for %s\n", video.Id, video.Title, startTime.Format(time.RFC1123))
 		}
 	}
+
+	return candidates
 }
 
 type UpcomingStreamChannel struct {
@@ -164,10 +146,71 @@ type UpcomingStream struct {
 	Id               string                `json:"id"`
 	Title            string                `json:"title"`
 	StartedScheduled string                `json:"start_scheduled"`
+	TopicId          string                `json:"topic_id"`
 	Channel          UpcomingStreamChannel `json:"channel"`
+	// Mentions are the other channels taking part in a collab
+	Mentions []UpcomingStreamChannel `json:"mentions"`
+}
+
+// ruleStream converts the stream for matching it against archive rules
+func (stream *UpcomingStream) ruleStream() *rules.Stream {
+	converted := &rules.Stream{
+		Title:   stream.Title,
+		TopicId: stream.TopicId,
+		Channel: rules.Channel{Id: stream.Channel.Id, Org: stream.Channel.Organization},
+	}
+
+	for _, mention := range stream.Mentions {
+		converted.Mentions = append(converted.Mentions, rules.Channel{Id: mention.Id, Org: mention.Organization})
+	}
+
+	return converted
 }
 
 func queryUpcomingStreams(organization string) ([]UpcomingStream, error) {
+	query := url.Values{}
+
+	query.Set("include", "live_info,mentions")
+	query.Set("limit", "50")
+	query.Set("type", "stream")
+	query.Set("status", "upcoming")
+	query.Set("max_upcoming_hours", strconv.Itoa(int(upcomingStreamsLookahead.Hours())))
+	query.Set("org", organization)
+
+	return queryHolodexLive(query)
+}
+
+// queryChannelStreams returns the upcoming and running livestreams of the YouTube channel `channelId` which start
+// within `lookahead`
+func queryChannelStreams(channelId string, lookahead time.Duration) ([]UpcomingStream, error) {
+	query := url.Values{}
+
+	query.Set("include", "mentions")
+	query.Set("limit", "50")
+	query.Set("type", "stream")
+	query.Set("status", "upcoming,live")
+	query.Set("max_upcoming_hours", strconv.Itoa(int(lookahead.Hours())))
+	query.Set("channel_id", channelId)
+
+	return queryHolodexLive(query)
+}
+
+// queryMentioningStreams returns the upcoming collabs of other channels which mention the YouTube channel `channelId`
+func queryMentioningStreams(channelId string) ([]UpcomingStream, error) {
+	query := url.Values{}
+
+	query.Set("include", "mentions")
+	query.Set("limit", "50")
+	query.Set("type", "stream")
+	query.Set("status", "upcoming")
+	query.Set("max_upcoming_hours", strconv.Itoa(int(upcomingStreamsLookahead.Hours())))
+	query.Set("mentioned_channel_id", channelId)
+
+	return queryHolodexLive(query)
+}
+
+// queryHolodexLive queries the live endpoint of holodex, which returns upcoming and running livestreams
+func queryHolodexLive(query url.Values) ([]UpcomingStream, error) {
 	request, err := http.NewRequest("GET", "https://holodex.net/api/v2/live", nil)
 
 	if err != nil {
@@ -178,16 +221,6 @@ func queryUpcomingStreams(organization string) ([]UpcomingStream, error) {
 	request.Header.Set("X-APIKEY", os.Getenv("HOLODEX_API_KEY"))
 	request.Header.Set("User-Agent", "pomu.app")
 
-	query := request.URL.Query()
-
-	query.Set("include", "live_info")
-	query.Set("limit", "50")
-	query.Set("topic", os.Getenv("HOLODEX_TOPIC"))
-	query.Set("type", "stream")
-	query.Set("status", "upcoming")
-	query.Set("max_upcoming_hours", "24") // We query every hour anyways
-	query.Set("org", organization)
-
 	request.URL.RawQuery = query.Encode()
 
 	response, err := http.DefaultClient.Do(request)
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"database/sql"
+	"github.com/getsentry/sentry-go"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"time"
+)
+
+const (
+	JobStateScheduled = "scheduled"
+	JobStateWaiting   = "waiting"
+	JobStateRecording = "recording"
+	JobStateUploading = "uploading"
+	JobStateFinished  = "finished"
+	JobStateFailed    = "failed"
+)
+
+// RecordingJob is the persisted state of a single video recording. The scheduler is driven from these
+// rows so that a restart of pomu can pick up exactly where it left off.
+type RecordingJob struct {
+	VideoId     string     `json:"videoId"`
+	VideoUrl    string     `json:"videoUrl"`
+	Quality     int32      `json:"quality"`
+	State       string     `json:"state"`
+	Attempts    int32      `json:"attempts"`
+	Error       *string    `json:"error,omitempty"`
+	ScheduledAt time.Time  `json:"scheduledAt"`
+	StartedAt   *time.Time `json:"startedAt,omitempty"`
+	FinishedAt  *time.Time `json:"finishedAt,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
+	Concurrency int32      `json:"concurrency"`
+}
+
+// Request returns the VideoRequest which was originally used to schedule this job
+func (job *RecordingJob) Request() VideoRequest {
+	return VideoRequest{
+		VideoUrl:    job.VideoUrl,
+		Quality:     job.Quality,
+		Concurrency: job.Concurrency,
+	}
+}
+
+// UpsertRecordingJob creates a new job for `videoId` or, if one already exists, resets it into the scheduled state
+func UpsertRecordingJob(db *sql.DB, videoId string, request VideoRequest, scheduledAt time.Time) error {
+	tx, err := db.Begin()
+
+	if err != nil {
+		sentry.CaptureException(err)
+		return err
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		`insert into recording_jobs (video_id, video_url, quality, scheduled_at, concurrency) values ($1, $2, $3, $4, $5)
+		on conflict (video_id) do update set
+			video_url = excluded.video_url,
+			quality = excluded.quality,
+			concurrency = excluded.concurrency,
+			scheduled_at = excluded.scheduled_at,
+			state = 'scheduled',
+			error = null,
+			updated_at = current_timestamp`,
+		videoId, request.VideoUrl, request.Quality, scheduledAt, request.Concurrency)
+
+	if err != nil {
+		sentry.CaptureException(err)
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// BeginRecordingJobAttempt increases the attempt counter of the job and moves it into the waiting state
+func BeginRecordingJobAttempt(db *sql.DB, videoId string) error {
+	tx, err := db.Begin()
+
+	if err != nil {
+		sentry.CaptureException(err)
+		return err
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		"update recording_jobs set attempts = attempts + 1, state = 'waiting', updated_at = current_timestamp where video_id = $1",
+		videoId)
+
+	if err != nil {
+		sentry.CaptureException(err)
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SetRecordingJobState moves the job into `state`. `jobErr` is stored alongside if the job has failed.
+func SetRecordingJobState(db *sql.DB, videoId string, state string, jobErr error) error {
+	tx, err := db.Begin()
+
+	if err != nil {
+		sentry.CaptureException(err)
+		return err
+	}
+
+	defer tx.Rollback()
+
+	var errorMessage *string
+
+	if jobErr != nil {
+		message := jobErr.Error()
+		errorMessage = &message
+	}
+
+	_, err = tx.Exec(
+		`update recording_jobs set
+			state = $1,
+			error = $2,
+			started_at = case when $1 = 'recording' then coalesce(started_at, current_timestamp) else started_at end,
+			finished_at = case when $1 in ('finished', 'failed') then current_timestamp else finished_at end,
+			updated_at = current_timestamp
+		where video_id = $3`,
+		state, errorMessage, videoId)
+
+	if err != nil {
+		sentry.CaptureException(err)
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// setJobState updates the job state and only logs if that fails, as a failing bookkeeping update should never
+// interrupt a running recording
+func (app *Application) setJobState(videoId string, state string, jobErr error) {
+	if err := SetRecordingJobState(app.db, videoId, state, jobErr); err != nil {
+		log.WithFields(log.Fields{
+			"video_id": videoId,
+			"state":    state,
+			"error":    err,
+		}).Error("failed to update recording job state")
+	}
+}
+
+func scanRecordingJob(row interface{ Scan(...any) error }, job *RecordingJob) error {
+	return row.Scan(
+		&job.VideoId,
+		&job.VideoUrl,
+		&job.Quality,
+		&job.State,
+		&job.Attempts,
+		&job.Error,
+		&job.ScheduledAt,
+		&job.StartedAt,
+		&job.FinishedAt,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+		&job.Concurrency)
+}
+
+// FindRecordingJob returns the job for `videoId` or nil if there is none
+func FindRecordingJob(db *sql.DB, videoId string) (*RecordingJob, error) {
+	tx, err := db.Begin()
+
+	if err != nil {
+		sentry.CaptureException(err)
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	var job RecordingJob
+
+	if err := scanRecordingJob(tx.QueryRow("select * from recording_jobs where video_id = $1 limit 1", videoId), &job); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+
+		sentry.CaptureException(err)
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		sentry.CaptureException(err)
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+// PendingRecordingJobs returns all jobs which have neither finished nor failed
+func PendingRecordingJobs(db *sql.DB) ([]RecordingJob, error) {
+	tx, err := db.Begin()
+
+	if err != nil {
+		sentry.CaptureException(err)
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	rows, err := tx.Query("select * from recording_jobs where state not in ('finished', 'failed') order by scheduled_at")
+
+	if err != nil {
+		sentry.CaptureException(err)
+		return nil, err
+	}
+
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			sentry.CaptureException(err)
+		}
+	}(rows)
+
+	jobs := []RecordingJob{}
+
+	for rows.Next() {
+		var job RecordingJob
+
+		if err := scanRecordingJob(rows, &job); err != nil {
+			log.WithFields(log.Fields{"error": err}).Warn("failed to scan row into RecordingJob")
+			continue
+		}
+
+		jobs = append(jobs, job)
+	}
+
+	if err := tx.Commit(); err != nil {
+		sentry.CaptureException(err)
+		return jobs, err
+	}
+
+	return jobs, nil
+}
+
+func (app *Application) GetRecordingJob(w http.ResponseWriter, r *http.Request) {
+	videoId := mux.Vars(r)["id"]
+
+	job, err := FindRecordingJob(app.db, videoId)
+
+	if err != nil {
+		http.Error(w, "failed to query recording job", http.StatusInternalServerError)
+		return
+	}
+
+	if job == nil {
+		http.Error(w, "recording job not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-cache, max-age=0, must-revalidate")
+
+	SerializeJson(w, job)
+}
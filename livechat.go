@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"pomu/chat"
+	"pomu/source"
+	"strings"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	log "github.com/sirupsen/logrus"
+)
+
+// chatSource returns the source the live chat is recorded from, nil if chat recording is disabled
+func chatSource() chat.Source {
+	if strings.ToLower(os.Getenv("RECORD_CHAT")) != "true" {
+		return nil
+	}
+
+	youtubeSource := &chat.YouTubeSource{ApiKey: os.Getenv("GOOGLE_API_KEY")}
+
+	if configured, err := time.ParseDuration(os.Getenv("CHAT_POLL_INTERVAL")); err == nil && configured > 0 {
+		youtubeSource.PollInterval = configured
+	}
+
+	return youtubeSource
+}
+
+// recordChat records the live chat of `id` into `<id>.chat.jsonl` until the chat ends or `captureCtx` is cancelled.
+// Cancelling `ctx` additionally aborts the upload. The returned channel is closed once the chat has been uploaded.
+func (app *Application) recordChat(ctx context.Context, captureCtx context.Context, id string, part int32, start time.Time) <-chan struct{} {
+	done := make(chan struct{})
+
+	if app.chatSource == nil || !source.IsYouTube(id) {
+		close(done)
+		return done
+	}
+
+	reader, writer := io.Pipe()
+
+	go func() {
+		count, err := chat.Record(captureCtx, app.chatSource, id, start, writer)
+
+		if errors.Is(err, chat.ErrNoChat) {
+			log.WithFields(log.Fields{"video_id": id}).Info("Livestream does not have a live chat")
+		} else if err != nil {
+			log.WithFields(log.Fields{"video_id": id, "error": err}).Warn("Failed to record live chat")
+			sentry.CaptureException(err)
+		}
+
+		log.WithFields(log.Fields{"video_id": id}).Info("Recorded ", count, " chat messages")
+		_ = writer.Close()
+	}()
+
+	go func() {
+		defer close(done)
+
+		if err := app.storage.Upload(ctx, VideoPartKey(id, part, part, "chat.jsonl"), reader, "application/x-ndjson"); err != nil {
+			log.WithFields(log.Fields{"video_id": id, "error": err}).Error("Failed to upload live chat")
+			sentry.CaptureException(err)
+			// unblock the recorder
+			_ = reader.CloseWithError(err)
+		}
+	}()
+
+	return done
+}
@@ -11,6 +11,9 @@ import (
 	"golang.org/x/exp/rand"
 	"net/http"
 	"os/exec"
+	"pomu/chat"
+	"pomu/source"
+	"pomu/storage"
 	"strconv"
 	"strings"
 	"time"
@@ -34,6 +37,12 @@ import (
 type Application struct {
 	db           *sql.DB
 	secureCookie *securecookie.SecureCookie
+	storage      storage.Backend
+	recordings   *Recordings
+	// chatSource is where live chats are recorded from, nil if chat recording is disabled
+	chatSource chat.Source
+	// webSub receives pushed feeds of subscribed channels, nil if websub is disabled
+	webSub *WebSub
 
 	searchClient *meilisearch.Client
 	search       *meilisearch.Index
@@ -77,9 +86,26 @@ func main() {
 		}
 	}
 
+	backend, err := storage.New()
+
+	if err != nil {
+		log.WithFields(log.Fields{"error": err}).Fatal("failed to setup storage backend")
+	}
+
+	// providers have to be known before any pending recording is restarted
+	twitch := twitchProvider()
+
+	if twitch != nil {
+		source.Register(twitch)
+	}
+
 	app := &Application{
 		db:           db,
 		secureCookie: setupSecureCookie(),
+		storage:      backend,
+		recordings:   NewRecordings(),
+		chatSource:   chatSource(),
+		webSub:       webSub(),
 	}
 
 	go app.restartRecording()
@@ -93,6 +119,70 @@ func main() {
 		}
 	}
 
+	if strings.ToLower(os.Getenv("SUBSCRIPTIONS_ENABLE")) == "true" {
+		interval := os.Getenv("SUBSCRIPTIONS_POLL_INTERVAL")
+
+		if len(interval) == 0 {
+			interval = "15m"
+		}
+
+		log.WithFields(log.Fields{"interval": interval}).Info("channel subscriptions are enabled")
+
+		if _, err := Scheduler.SingletonMode().Every(interval).Do(PollSubscriptions, app); err != nil {
+			log.WithFields(log.Fields{"error": err}).Error("failed to schedule task for polling channel subscriptions")
+		}
+	}
+
+	if app.webSub != nil {
+		log.WithFields(log.Fields{"callback": app.webSub.callback}).Info("websub is enabled")
+
+		if _, err := Scheduler.SingletonMode().Every("1h").StartImmediately().Do(RenewWebSubLeases, app); err != nil {
+			log.WithFields(log.Fields{"error": err}).Error("failed to schedule task for renewing websub leases")
+		}
+	}
+
+	if twitch != nil {
+		interval := os.Getenv("TWITCH_POLL_INTERVAL")
+
+		if len(interval) == 0 {
+			interval = "1m"
+		}
+
+		log.WithFields(log.Fields{"interval": interval}).Info("twitch livestreams are enabled")
+
+		if _, err := Scheduler.SingletonMode().Every(interval).Do(WatchTwitchChannels, app, twitch); err != nil {
+			log.WithFields(log.Fields{"error": err}).Error("failed to schedule task for watching twitch channels")
+		}
+	}
+
+	if strings.ToLower(os.Getenv("METADATA_REFRESH_ENABLE")) == "true" {
+		interval := os.Getenv("METADATA_REFRESH_INTERVAL")
+
+		if len(interval) == 0 {
+			interval = "30m"
+		}
+
+		log.WithFields(log.Fields{"interval": interval}).Info("metadata refreshing is enabled")
+
+		if _, err := Scheduler.SingletonMode().Every(interval).Do(RefreshMetadata, app); err != nil {
+			log.WithFields(log.Fields{"error": err}).Error("failed to schedule task for refreshing metadata")
+		}
+	}
+
+	if strings.ToLower(os.Getenv("AVAILABILITY_CHECK_ENABLE")) == "true" {
+		interval := os.Getenv("AVAILABILITY_CHECK_INTERVAL")
+
+		if len(interval) == 0 {
+			interval = "24h"
+		}
+
+		log.WithFields(log.Fields{"interval": interval}).Info("availability checking is enabled")
+
+		if _, err := Scheduler.SingletonMode().Every(interval).Do(CheckAvailability, app); err != nil {
+			log.WithFields(log.Fields{"error": err}).Error("failed to schedule task for checking availability")
+		}
+	}
+
 	setupServer(address, app)
 }
 
@@ -122,6 +212,11 @@ func setupServer(address string, app *Application) {
 	fileServer := http.FileServer(http.Dir("./dist/assets"))
 	r.PathPrefix("/assets/").Handler(http.StripPrefix("/assets/", fileServer))
 
+	// Files of the local storage backend
+	if local, ok := app.storage.(*storage.Local); ok {
+		r.PathPrefix(storage.LocalUrlPrefix + "/").Handler(http.StripPrefix(storage.LocalUrlPrefix+"/", local))
+	}
+
 	// Prometheus
 	r.Handle("/metrics", middleware.WrapHandler("/metrics", promhttp.Handler()))
 
@@ -147,6 +242,40 @@ func setupServer(address string, app *Application) {
 
 	// Specific video
 	r.HandleFunc("/api/video/{id}/downloads", middleware.WrapHandler("/api/video/{id}/downloads", http.HandlerFunc(app.DownloadCount))).Methods("GET")
+	r.HandleFunc("/api/video/{id}/gaps", middleware.WrapHandler("/api/video/{id}/gaps", http.HandlerFunc(app.GetGaps))).Methods("GET")
+	r.HandleFunc("/api/video/{id}/chapters", middleware.WrapHandler("/api/video/{id}/chapters", http.HandlerFunc(app.GetChapters))).Methods("GET")
+	r.HandleFunc("/api/video/{id}/thumbnail", middleware.WrapHandler("/api/video/{id}/thumbnail", http.HandlerFunc(app.GetThumbnail))).Methods("GET")
+	r.HandleFunc("/api/video/{id}/revisions", middleware.WrapHandler("/api/video/{id}/revisions", http.HandlerFunc(app.GetRevisions))).Methods("GET")
+	r.HandleFunc("/api/video/{id}/job", middleware.WrapHandler("/api/video/{id}/job", http.HandlerFunc(app.GetRecordingJob))).Methods("GET")
+	r.HandleFunc("/api/video/{id}/progress", middleware.WrapHandler("/api/video/{id}/progress", http.HandlerFunc(app.GetProgress))).Methods("GET")
+	r.HandleFunc("/api/video/{id}/progress/events", middleware.WrapHandler("/api/video/{id}/progress/events", http.HandlerFunc(app.StreamProgress))).Methods("GET")
+	r.HandleFunc("/api/video/{id}/playlist.m3u8", middleware.WrapHandler("/api/video/{id}/playlist.m3u8", http.HandlerFunc(app.GetPlaylist))).Methods("GET")
+	r.HandleFunc("/api/video/{id}/cancel", middleware.WrapHandler("/api/video/{id}/cancel", http.HandlerFunc(app.CancelRecording))).Methods("POST")
+	r.HandleFunc("/api/video/{id}/subtitles", middleware.WrapHandler("/api/video/{id}/subtitles", http.HandlerFunc(app.UploadSubtitles))).Methods("POST")
+
+	// Channel subscriptions
+	r.HandleFunc("/api/subscriptions", middleware.WrapHandler("/api/subscriptions", methodHandlers{
+		"GET":  app.GetSubscriptions,
+		"POST": app.CreateSubscription,
+	})).Methods("GET", "POST")
+	r.HandleFunc("/api/subscriptions/{id}", middleware.WrapHandler("/api/subscriptions/{id}", methodHandlers{
+		"PUT":    app.UpdateSubscription,
+		"DELETE": app.DeleteSubscription,
+	})).Methods("PUT", "DELETE")
+
+	// Archive rules
+	r.HandleFunc("/api/archive-rules", middleware.WrapHandler("/api/archive-rules", methodHandlers{
+		"GET":  app.GetArchiveRules,
+		"POST": app.CreateArchiveRule,
+	})).Methods("GET", "POST")
+	r.HandleFunc("/api/archive-rules/{id}", middleware.WrapHandler("/api/archive-rules/{id}", methodHandlers{
+		"PUT":    app.UpdateArchiveRule,
+		"DELETE": app.DeleteArchiveRule,
+	})).Methods("PUT", "DELETE")
+
+	if app.webSub != nil {
+		r.Handle("/api/websub", middleware.WrapHandler("/api/websub", app.WebSubHandler())).Methods("GET", "POST")
+	}
 
 	// Downloads
 	// TODO: move this into the /api/video group, smth like /api/video/{id}/download/{type}
@@ -286,32 +415,47 @@ func initLogging() {
 }
 
 func (app *Application) restartRecording() {
-	queue, err := app.getQueue()
+	jobs, err := PendingRecordingJobs(app.db)
 
 	if err != nil {
-		log.WithFields(log.Fields{"error": err}).Error("failed to get queue from database")
+		log.WithFields(log.Fields{"error": err}).Error("failed to get pending recording jobs from database")
 		return
 	}
 
-	log.WithFields(log.Fields{"amount": len(queue)}).Info("found pending videos to restart")
+	log.WithFields(log.Fields{"amount": len(jobs)}).Info("found pending recording jobs to restart")
+
+	for _, job := range jobs {
+		// pomu got interrupted during the recording, keep what has been recorded so far. Done first so that it is kept
+		// even if the metadata lookup below has to be retried.
+		if job.State == JobStateRecording || job.State == JobStateUploading {
+			if err := app.resumeInterruptedRecording(job.VideoId); err != nil {
+				log.WithFields(log.Fields{
+					"video_id": job.VideoId,
+					"error":    err,
+				}).Error("failed to salvage interrupted recording")
+			}
+		}
 
-	for _, video := range queue {
-		videoMetadata, err := GetVideoMetadata(video.Id)
+		videoMetadata, err := GetVideoMetadata(job.VideoId)
 
 		if err != nil {
 			log.WithFields(log.Fields{
-				"video_id": video.Id,
+				"video_id": job.VideoId,
 				"error":    err,
-			}).Error("unable to get video meta data from youtube")
+			}).Error("unable to get video meta data, retrying later")
+			app.retryAfterMetadataError(job.VideoId, job.Request(), job.Attempts, err)
 			continue
 		}
 
+		log.WithFields(log.Fields{
+			"video_id": job.VideoId,
+			"state":    job.State,
+			"attempts": job.Attempts,
+			"quality":  job.Quality,
+		}).Info("restarting recording job")
+
 		// ignore any scheduling errors
-		_ = app.scheduleVideo(videoMetadata, video.Id, VideoRequest{
-			VideoUrl: fmt.Sprintf("https://youtu.be/%s", video.Id),
-			// Use 0 to auto-pick best quality
-			Quality: 0,
-		})
+		_ = app.scheduleVideo(videoMetadata, job.VideoId, job.Request())
 	}
 }
 
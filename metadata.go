@@ -2,45 +2,52 @@ package main
 
 import (
 	"context"
-	"fmt"
 	"os"
+	"pomu/source"
 
 	"google.golang.org/api/option"
 	"google.golang.org/api/youtube/v3"
 )
 
-func GetVideoMetadata(videoId string) (*youtube.Video, error) {
+// GetVideoMetadata gets the current metadata of `videoId` from the provider it has been submitted from
+func GetVideoMetadata(videoId string) (*source.Metadata, error) {
+	return source.GetMetadata(context.Background(), videoId)
+}
+
+// GetVideosMetadata gets the metadata of up to 50 videos at once. Videos which YouTube does not know (anymore) are
+// missing from the result.
+func GetVideosMetadata(videoIds []string) (map[string]*youtube.Video, error) {
 	service, err := youtube.NewService(context.Background(), option.WithAPIKey(os.Getenv("GOOGLE_API_KEY")))
 
 	if err != nil {
 		return nil, err
 	}
 
-	videoService := youtube.NewVideosService(service)
-
-	list, err := videoService.List([]string{"contentDetails", "liveStreamingDetails", "snippet"}).Id(videoId).Do()
+	list, err := service.Videos.List([]string{"liveStreamingDetails", "snippet", "status"}).Id(videoIds...).Do()
 
 	if err != nil {
 		return nil, err
 	}
 
-	if length := len(list.Items); length != 1 {
-		return nil, fmt.Errorf("didn't get items, length was %d", length)
+	videos := make(map[string]*youtube.Video, len(list.Items))
+
+	for _, item := range list.Items {
+		videos[item.Id] = item
 	}
 
-	return list.Items[0], nil
+	return videos, nil
 }
 
-func IsLivestream(video *youtube.Video) bool {
-	return video != nil && video.LiveStreamingDetails != nil && video.Snippet.LiveBroadcastContent != "none"
+func IsLivestream(video *source.Metadata) bool {
+	return video != nil && video.State != source.StateNone
 }
 
 // IsLivestreamStarted checks if the livestream is currently live
-func IsLivestreamStarted(video *youtube.Video) bool {
-	return IsLivestream(video) && video.Snippet.LiveBroadcastContent == "live"
+func IsLivestreamStarted(video *source.Metadata) bool {
+	return IsLivestream(video) && video.State == source.StateLive
 }
 
 // IsLivestreamEnded checks if the livestream has ended
-func IsLivestreamEnded(video *youtube.Video) bool {
-	return IsLivestream(video) && video.Snippet.LiveBroadcastContent == "completed"
+func IsLivestreamEnded(video *source.Metadata) bool {
+	return IsLivestream(video) && video.State == source.StateEnded
 }
@@ -0,0 +1,6 @@
+begin;
+
+drop table if exists recording_jobs;
+drop type if exists recording_job_state;
+
+commit;
@@ -0,0 +1,36 @@
+begin;
+
+do
+$$
+    begin
+        create type recording_job_state as enum ('scheduled', 'waiting', 'recording', 'uploading', 'finished', 'failed');
+    exception
+        when duplicate_object then null;
+    end
+$$;
+
+create table if not exists recording_jobs
+(
+    video_id     varchar                                         not null primary key,
+    video_url    varchar                                         not null,
+    quality      integer             default 0                   not null,
+    state        recording_job_state default 'scheduled'         not null,
+    attempts     integer             default 0                   not null,
+    error        text,
+    scheduled_at timestamptz                                     not null,
+    started_at   timestamptz,
+    finished_at  timestamptz,
+    created_at   timestamptz         default current_timestamp   not null,
+    updated_at   timestamptz         default current_timestamp   not null
+);
+
+comment on column recording_jobs.quality is 'yt-dlp format code, 0 picks the best available quality';
+
+-- carry over videos which have been queued before jobs were persisted
+insert into recording_jobs (video_id, video_url, scheduled_at)
+select id, 'https://youtu.be/' || id, start
+from videos
+where finished = false
+on conflict do nothing;
+
+commit;
@@ -0,0 +1,9 @@
+begin;
+
+alter table videos
+    add if not exists parts
+        integer default 1 not null;
+
+comment on column videos.parts is 'amount of separately stored parts, more than one if the recording has been interrupted';
+
+commit;
@@ -0,0 +1,18 @@
+begin;
+
+create table if not exists video_gaps
+(
+    id           serial                                    not null primary key,
+    video_id     varchar                                   not null,
+    part         integer     default 1                     not null,
+    "offset"     double precision                          not null,
+    reason       text                                      not null,
+    attempts     integer                                   not null,
+    occurred_at  timestamptz default current_timestamp     not null
+);
+
+create index if not exists video_gaps_video_id_index on video_gaps (video_id);
+
+comment on column video_gaps."offset" is 'in seconds since the part started recording';
+
+commit;
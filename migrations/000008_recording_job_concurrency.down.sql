@@ -0,0 +1,6 @@
+begin;
+
+alter table recording_jobs
+    drop column if exists concurrency;
+
+commit;
@@ -0,0 +1,9 @@
+begin;
+
+alter table recording_jobs
+    add if not exists concurrency
+        integer default 0 not null;
+
+comment on column recording_jobs.concurrency is 'amount of segments fetched at the same time, 0 uses the instance default';
+
+commit;
@@ -0,0 +1,7 @@
+begin;
+
+alter table video_gaps
+    drop column if exists sequence,
+    drop column if exists segments;
+
+commit;
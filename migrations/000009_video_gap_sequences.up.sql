@@ -0,0 +1,10 @@
+begin;
+
+alter table video_gaps
+    add if not exists sequence bigint default 0 not null,
+    add if not exists segments integer default 1 not null;
+
+comment on column video_gaps.sequence is 'media sequence number of the first missing segment';
+comment on column video_gaps.segments is 'amount of consecutive segments which are missing';
+
+commit;
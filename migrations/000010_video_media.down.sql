@@ -0,0 +1,15 @@
+begin;
+
+alter table videos
+    drop column if exists video_codec,
+    drop column if exists audio_codec,
+    drop column if exists width,
+    drop column if exists height,
+    drop column if exists fps,
+    drop column if exists bitrate,
+    drop column if exists audio_channels,
+    drop column if exists probed_length,
+    drop column if exists suspect,
+    drop column if exists verified_at;
+
+commit;
@@ -0,0 +1,18 @@
+begin;
+
+alter table videos
+    add if not exists video_codec text,
+    add if not exists audio_codec text,
+    add if not exists width integer,
+    add if not exists height integer,
+    add if not exists fps double precision,
+    add if not exists bitrate bigint,
+    add if not exists audio_channels integer,
+    add if not exists probed_length double precision,
+    add if not exists suspect boolean default false not null,
+    add if not exists verified_at timestamptz;
+
+comment on column videos.probed_length is 'length of the stored archive in seconds as reported by ffprobe';
+comment on column videos.suspect is 'whether the length of the archive differs badly from the length of the livestream';
+
+commit;
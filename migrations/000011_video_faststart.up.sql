@@ -0,0 +1,8 @@
+begin;
+
+alter table videos
+    add if not exists faststart boolean default false not null;
+
+comment on column videos.faststart is 'whether the archive has been remuxed into a regular, non-fragmented mp4';
+
+commit;
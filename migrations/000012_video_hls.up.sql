@@ -0,0 +1,8 @@
+begin;
+
+alter table videos
+    add if not exists hls boolean default false not null;
+
+comment on column videos.hls is 'whether the archive has been published as hls vod playlist';
+
+commit;
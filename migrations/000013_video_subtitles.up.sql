@@ -0,0 +1,8 @@
+begin;
+
+alter table videos
+    add if not exists subtitles varchar(16) default null;
+
+comment on column videos.subtitles is 'where the subtitle sidecar has been generated from, either chat or captions. null if there is none';
+
+commit;
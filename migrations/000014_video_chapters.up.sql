@@ -0,0 +1,19 @@
+begin;
+
+create table if not exists video_chapters
+(
+    id         serial                                    not null primary key,
+    video_id   varchar                                   not null,
+    part       integer     default 1                     not null,
+    "offset"   double precision                          not null,
+    title      text                                      not null,
+    source     varchar(16)                               not null,
+    created_at timestamptz default current_timestamp     not null
+);
+
+create index if not exists video_chapters_video_id_index on video_chapters (video_id);
+
+comment on column video_chapters."offset" is 'in seconds since the part started recording';
+comment on column video_chapters.source is 'either description (timestamps in the video description) or title (title changes during the livestream)';
+
+commit;
@@ -0,0 +1,11 @@
+begin;
+
+drop table if exists video_revisions;
+
+alter table videos
+    drop column if exists metadata_refreshed_at;
+
+alter table videos
+    drop column if exists description;
+
+commit;
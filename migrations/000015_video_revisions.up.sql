@@ -0,0 +1,26 @@
+begin;
+
+alter table videos
+    add if not exists description text default null;
+
+alter table videos
+    add if not exists metadata_refreshed_at timestamptz default null;
+
+comment on column videos.metadata_refreshed_at is 'when title, thumbnail and description have last been refreshed from youtube';
+
+create table if not exists video_revisions
+(
+    id             serial                                    not null primary key,
+    video_id       varchar                                   not null,
+    title          varchar                                   not null,
+    thumbnail      varchar                                   not null,
+    thumbnail_hash varchar(64)                               not null,
+    observed_at    timestamptz default current_timestamp     not null
+);
+
+create index if not exists video_revisions_video_id_index on video_revisions (video_id);
+
+comment on column video_revisions.thumbnail is 'storage key of the thumbnail as it looked like during this revision';
+comment on column video_revisions.thumbnail_hash is 'hex encoded sha256 of the thumbnail';
+
+commit;
@@ -0,0 +1,14 @@
+begin;
+
+drop index if exists videos_availability_index;
+
+alter table videos
+    drop column if exists availability_checked_at;
+
+alter table videos
+    drop column if exists availability_changed_at;
+
+alter table videos
+    drop column if exists availability;
+
+commit;
@@ -0,0 +1,17 @@
+begin;
+
+alter table videos
+    add if not exists availability varchar(16) default null;
+
+alter table videos
+    add if not exists availability_changed_at timestamptz default null;
+
+alter table videos
+    add if not exists availability_checked_at timestamptz default null;
+
+comment on column videos.availability is 'whether the video is still available on youtube: public, unlisted, private, deleted or members-only. null if it has not been checked yet';
+comment on column videos.availability_changed_at is 'when pomu noticed the availability change, null if it never changed';
+
+create index if not exists videos_availability_index on videos (availability);
+
+commit;
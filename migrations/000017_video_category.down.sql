@@ -0,0 +1,6 @@
+begin;
+
+alter table videos
+    drop column if exists category;
+
+commit;
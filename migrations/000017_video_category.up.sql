@@ -0,0 +1,8 @@
+begin;
+
+alter table videos
+    add if not exists category varchar default null;
+
+comment on column videos.category is 'game or topic the livestream was listed under, only known for sources which have categories such as twitch';
+
+commit;
@@ -0,0 +1,24 @@
+begin;
+
+create table if not exists channel_subscriptions
+(
+    id             serial                                    not null primary key,
+    source         varchar(16) default 'youtube'             not null,
+    channel_id     varchar                                   not null,
+    channel_name   varchar                                   not null,
+    quality        integer     default 0                     not null,
+    title_include  varchar     default null,
+    title_exclude  varchar     default null,
+    created_by     varchar                                   not null,
+    created_at     timestamptz default current_timestamp     not null,
+    last_polled_at timestamptz default null,
+    unique (source, channel_id)
+);
+
+comment on column channel_subscriptions.source is 'name of the source the channel is on, youtube or twitch';
+comment on column channel_subscriptions.quality is 'quality livestreams are recorded in, 0 picks the best quality';
+comment on column channel_subscriptions.title_include is 'case-insensitive regular expression titles have to match to be archived, null archives every livestream';
+comment on column channel_subscriptions.title_exclude is 'case-insensitive regular expression of titles which are not archived';
+comment on column channel_subscriptions.created_by is 'user who subscribed to the channel (Format: Provider/UserID), also submits every livestream';
+
+commit;
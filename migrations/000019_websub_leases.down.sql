@@ -0,0 +1,6 @@
+begin;
+
+alter table channel_subscriptions
+    drop column if exists websub_expires_at;
+
+commit;
@@ -0,0 +1,8 @@
+begin;
+
+alter table channel_subscriptions
+    add if not exists websub_expires_at timestamptz default null;
+
+comment on column channel_subscriptions.websub_expires_at is 'when the websub lease of the channel feed runs out, null if the hub has not verified a subscription yet';
+
+commit;
@@ -0,0 +1,31 @@
+begin;
+
+create table if not exists archive_rules
+(
+    id               serial                                not null primary key,
+    name             varchar                               not null,
+    enabled          boolean     default true              not null,
+    include_orgs     varchar[]   default '{}'              not null,
+    exclude_orgs     varchar[]   default '{}'              not null,
+    include_channels varchar[]   default '{}'              not null,
+    exclude_channels varchar[]   default '{}'              not null,
+    include_topics   varchar[]   default '{}'              not null,
+    exclude_topics   varchar[]   default '{}'              not null,
+    title_include    varchar[]   default '{}'              not null,
+    title_exclude    varchar[]   default '{}'              not null,
+    include_mentions boolean     default false             not null,
+    quality          integer     default 0                 not null,
+    created_by       varchar                               not null,
+    created_at       timestamptz default current_timestamp not null
+);
+
+comment on table archive_rules is 'rules deciding which livestreams listed on holodex are archived automatically';
+comment on column archive_rules.include_orgs is 'holodex orgs whose livestreams are archived';
+comment on column archive_rules.include_channels is 'youtube channel ids whose livestreams are archived';
+comment on column archive_rules.include_topics is 'holodex topics livestreams have to be listed under, empty allows every topic';
+comment on column archive_rules.title_include is 'case-insensitive regular expressions of which titles have to match at least one, empty allows every title';
+comment on column archive_rules.title_exclude is 'case-insensitive regular expressions of titles which are not archived';
+comment on column archive_rules.include_mentions is 'whether collabs of other channels which mention an included channel are archived as well, orgs are not looked up';
+comment on column archive_rules.created_by is 'user who created the rule (Format: Provider/UserID), also submits every matched livestream';
+
+commit;
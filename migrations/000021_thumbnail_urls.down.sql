@@ -0,0 +1,5 @@
+begin;
+
+-- the previous storage urls cannot be restored, /api/video/{id}/thumbnail keeps working
+
+commit;
@@ -0,0 +1,9 @@
+begin;
+
+-- thumbnails used to be stored as storage urls ending in <id>.jpg, which expire for private buckets, so they are served
+-- through pomu instead. Thumbnails which failed to upload kept the url of YouTube and are left untouched.
+update videos
+set thumbnail = '/api/video/' || id || '/thumbnail'
+where right(thumbnail, length(id) + 5) = '/' || id || '.jpg';
+
+commit;
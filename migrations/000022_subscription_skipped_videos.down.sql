@@ -0,0 +1,5 @@
+begin;
+
+drop table if exists subscription_skipped_videos;
+
+commit;
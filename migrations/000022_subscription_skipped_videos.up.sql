@@ -0,0 +1,13 @@
+begin;
+
+create table if not exists subscription_skipped_videos
+(
+    subscription_id integer                               not null references channel_subscriptions (id) on delete cascade,
+    video_id        varchar                               not null,
+    skipped_at      timestamptz default current_timestamp not null,
+    primary key (subscription_id, video_id)
+);
+
+comment on table subscription_skipped_videos is 'livestreams of subscribed channels which were not archived as their title does not match, so that their metadata is not fetched on every poll';
+
+commit;
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/getsentry/sentry-go"
+	log "github.com/sirupsen/logrus"
+	"pomu/storage"
+)
+
+// VideoPartKey returns the storage key of `part` (1-indexed) of a video consisting of `parts` parts.
+// Videos which only consist of a single part keep the plain `<id>.<extension>` key.
+func VideoPartKey(id string, part int32, parts int32, extension string) string {
+	if parts <= 1 {
+		return fmt.Sprintf("%s.%s", id, extension)
+	}
+
+	return fmt.Sprintf("%s.part%d.%s", id, part, extension)
+}
+
+// PartDownloadUrls returns the download urls of every part of `type_`
+func (video *Video) PartDownloadUrls(type_ string) []string {
+	urls := make([]string, 0, video.Parts)
+
+	for part := int32(1); part <= video.Parts; part++ {
+		urls = append(urls, fmt.Sprintf("/api/download/%s/%s?part=%d", video.Id, type_, part))
+	}
+
+	return urls
+}
+
+// VideoParts returns the amount of parts a video currently consists of
+func VideoParts(db *sql.DB, videoId string) (int32, error) {
+	var parts int32
+
+	if err := db.QueryRow("select parts from videos where id = $1", videoId).Scan(&parts); err != nil {
+		sentry.CaptureException(err)
+		return 0, err
+	}
+
+	return parts, nil
+}
+
+// recordedPartExtensions are the files written while recording a part
+var recordedPartExtensions = []string{"mp4", "log", "chat.jsonl"}
+
+// moveFirstPart moves the files of the only part of `videoId` to the keys of the first of two parts
+func moveFirstPart(backend storage.Backend, videoId string) error {
+	for _, extension := range recordedPartExtensions {
+		if err := storage.Rename(backend, VideoPartKey(videoId, 1, 1, extension), VideoPartKey(videoId, 1, 2, extension)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// resumeInterruptedRecording salvages whatever has been uploaded before pomu got interrupted while recording `videoId`
+// and adds it as a new part, so that restarting the recording does not overwrite what has been recorded so far
+func (app *Application) resumeInterruptedRecording(videoId string) error {
+	parts, err := VideoParts(app.db, videoId)
+
+	if err != nil {
+		return err
+	}
+
+	key := VideoPartKey(videoId, parts, parts, "mp4")
+
+	// an upload might have been left behind when pomu died, complete it so the data becomes readable
+	if recoverer, ok := app.storage.(storage.Recoverer); ok {
+		if _, err := recoverer.CompleteAbandonedUpload(key); err != nil {
+			return err
+		}
+	}
+
+	info, err := app.storage.Stat(key)
+
+	if err == storage.ErrNotExist && parts == 1 {
+		// we might have been interrupted after moving the first part but before counting it
+		info, err = app.storage.Stat(VideoPartKey(videoId, 1, 2, "mp4"))
+	} else if err == nil && parts == 1 {
+		// there is a second part coming, move the first one out of the way
+		if err := moveFirstPart(app.storage, videoId); err != nil {
+			return err
+		}
+	}
+
+	if err == storage.ErrNotExist {
+		log.WithFields(log.Fields{"video_id": videoId}).Info("interrupted recording did not upload anything, starting over")
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	tx, err := app.db.Begin()
+
+	if err != nil {
+		sentry.CaptureException(err)
+		return err
+	}
+
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("update videos set parts = parts + 1, file_size = file_size + $1 where id = $2", info.Size, videoId); err != nil {
+		sentry.CaptureException(err)
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		sentry.CaptureException(err)
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"video_id": videoId,
+		"part":     parts,
+		"size":     info.Size,
+	}).Info("salvaged interrupted recording, continuing as new part")
+
+	return nil
+}
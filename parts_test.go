@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"io"
+	"pomu/storage"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMoveFirstPart(t *testing.T) {
+	backend, err := storage.NewLocal(t.TempDir(), "/storage/")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	for _, key := range []string{"video.mp4", "video.log", "video.chat.jsonl"} {
+		assert.NoError(t, backend.Upload(context.Background(), key, strings.NewReader(key), "text/plain"))
+	}
+
+	assert.NoError(t, moveFirstPart(backend, "video"))
+
+	for _, extension := range recordedPartExtensions {
+		_, err := backend.Stat(VideoPartKey("video", 1, 1, extension))
+		assert.Equal(t, storage.ErrNotExist, err, extension)
+
+		reader, err := backend.Download(VideoPartKey("video", 1, 2, extension))
+		if assert.NoError(t, err, extension) {
+			content, _ := io.ReadAll(reader)
+			_ = reader.Close()
+
+			assert.Equal(t, "video."+extension, string(content))
+		}
+	}
+
+	// parts without a chat or log are moved as well
+	assert.NoError(t, backend.Upload(context.Background(), "other.mp4", strings.NewReader("other"), "video/mp4"))
+	assert.NoError(t, moveFirstPart(backend, "other"))
+
+	_, err = backend.Stat("other.part1.mp4")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"github.com/getsentry/sentry-go"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"pomu/source"
+	"strings"
+)
+
+// postProcessVideo runs everything that happens to an archive after it has been recorded successfully
+func (app *Application) postProcessVideo(videoId string) {
+	if strings.ToLower(os.Getenv("FASTSTART_ARCHIVES")) == "true" {
+		if err := app.faststartVideo(videoId); err != nil {
+			sentry.CaptureException(err)
+			log.WithFields(log.Fields{"video_id": videoId, "error": err}).Error("failed to remux archive with faststart, keeping original")
+		}
+	}
+
+	if err := app.verifyVideo(videoId); err != nil {
+		log.WithFields(log.Fields{"video_id": videoId, "error": err}).Error("failed to verify archive")
+	}
+
+	// chat is only recorded from youtube
+	if app.chatSource != nil && source.IsYouTube(videoId) {
+		if err := app.generateChatSubtitles(videoId); err != nil {
+			sentry.CaptureException(err)
+			log.WithFields(log.Fields{"video_id": videoId, "error": err}).Error("failed to generate subtitles from chat")
+		}
+	}
+
+	if strings.ToLower(os.Getenv("MUX_SUBTITLES")) == "true" {
+		if err := app.muxSubtitles(videoId); err != nil {
+			sentry.CaptureException(err)
+			log.WithFields(log.Fields{"video_id": videoId, "error": err}).Error("failed to mux subtitles into archive, keeping original")
+		}
+	}
+
+	if err := app.storeDescriptionChapters(videoId); err != nil {
+		log.WithFields(log.Fields{"video_id": videoId, "error": err}).Warn("failed to get chapters from description")
+	}
+
+	if strings.ToLower(os.Getenv("EMBED_CHAPTERS")) == "true" {
+		if err := app.embedChapters(videoId); err != nil {
+			sentry.CaptureException(err)
+			log.WithFields(log.Fields{"video_id": videoId, "error": err}).Error("failed to embed chapters into archive, keeping original")
+		}
+	}
+
+	if strings.ToLower(os.Getenv("PUBLISH_HLS")) == "true" {
+		if err := app.publishVod(videoId); err != nil {
+			sentry.CaptureException(err)
+			log.WithFields(log.Fields{"video_id": videoId, "error": err}).Error("failed to publish archive as hls vod")
+		}
+	}
+}
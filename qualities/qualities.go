@@ -9,6 +9,7 @@ import (
 	"net/url"
 	"os"
 	"os/exec"
+	"pomu/source"
 	"strconv"
 	"strings"
 	"time"
@@ -47,9 +48,7 @@ func GetVideoQualities(url string, ignoreCache bool) ([]VideoQuality, bool, erro
 	cmd.Stderr = output
 
 	if err := cmd.Run(); err != nil {
-		if strings.Contains(output.String(), "This live event will begin in") ||
-			strings.Contains(output.String(), "Premieres in") ||
-			strings.Contains(output.String(), "Premiere will begin") {
+		if source.IsNotStarted(output.String()) {
 			return []VideoQuality{{
 				Code:       -1,
 				Resolution: "Not yet started, will use best quality",
@@ -90,14 +89,27 @@ func GetVideoQualities(url string, ignoreCache bool) ([]VideoQuality, bool, erro
 		}
 
 		code, err := strconv.Atoi(formatId)
+		named := err != nil
 
-		if err != nil {
-			continue
+		if named {
+			// some sources such as twitch name their formats after the quality, these are identified by their height
+			height, ok := format["height"].(float64)
+
+			if !ok || height <= 0 || format["vcodec"] == "none" {
+				continue
+			}
+
+			code = int(height)
 		}
 
 		jsonVbr, ok := format["vbr"]
 		var vbr float64
 
+		if named {
+			// named formats only advertise their total bitrate
+			jsonVbr, ok = format["tbr"]
+		}
+
 		if ok {
 			switch jsonVbr.(type) {
 			case float64:
@@ -109,12 +121,20 @@ func GetVideoQualities(url string, ignoreCache bool) ([]VideoQuality, bool, erro
 			vbr = 0.0
 		}
 
-		qualities = append(qualities, VideoQuality{
+		quality := VideoQuality{
 			Code:       int32(code),
 			Resolution: format["resolution"].(string),
 			Vbr:        vbr,
 			Best:       false,
-		})
+		}
+
+		// e.g. 720p30 and 720p60 share their height, the later one is listed as the better one
+		if last := len(qualities) - 1; named && last >= 0 && qualities[last].Code == quality.Code {
+			qualities[last] = quality
+			continue
+		}
+
+		qualities = append(qualities, quality)
 	}
 
 	if len(qualities) <= 0 {
@@ -143,35 +163,23 @@ func GetVideoQualities(url string, ignoreCache bool) ([]VideoQuality, bool, erro
 
 func isValidUrl(videoUrl string) bool {
 	parsedUrl, err := url.Parse(videoUrl)
-	loweredHost := strings.ToLower(parsedUrl.Host)
 
-	hasYouTube := strings.Contains(loweredHost, "youtube.com")
-	hasYouTuDotBe := strings.Contains(loweredHost, "youtu.be")
+	if err != nil || len(parsedUrl.Scheme) <= 0 {
+		return false
+	}
 
-	return err == nil && len(parsedUrl.Scheme) > 0 && (hasYouTube || hasYouTuDotBe)
+	_, err = source.ForUrl(parsedUrl)
+	return err == nil
 }
 
+// ParseVideoID returns the id of the livestream at `videoUrl`, or `videoUrl` itself if no provider supports it
 func ParseVideoID(videoUrl string) string {
-	parsedUrl, _ := url.Parse(videoUrl)
-
-	removeWww := strings.TrimPrefix(parsedUrl.Host, "www.")
-	removeM := strings.TrimPrefix(removeWww, "m.")
-
-	switch removeM {
-	case "youtu.be":
-		// https://youtu.be/m7Mzgmpr-Qc
-		return parsedUrl.Path[1:]
-	case "youtube.com":
-		if parsedUrl.Path == "/watch" {
-			// https://youtube.com/watch?v=m7Mzgmpr-Qc
-			return parsedUrl.Query().Get("v")
-		} else if strings.HasPrefix(parsedUrl.Path, "/live") {
-			// https://youtube.com/live/m7Mzgmpr-Qc
-			parts := strings.Split(parsedUrl.Path[1:], "/")
-			return parts[len(parts)-1]
-		}
+	id, err := source.ParseId(videoUrl)
+
+	if err != nil {
+		sentry.CaptureMessage("Failed to parse video url \"" + videoUrl + "\" into video id")
+		return videoUrl
 	}
 
-	sentry.CaptureMessage("Failed to parse video url \"" + videoUrl + "\" into video id")
-	return videoUrl
+	return id
 }
@@ -2,11 +2,12 @@ package main
 
 import (
 	"database/sql"
+	"fmt"
+	"github.com/lib/pq"
 	log "github.com/sirupsen/logrus"
 	"net/http"
 
 	"github.com/getsentry/sentry-go"
-	"github.com/lib/pq"
 )
 
 func (app *Application) getQueue() (videos []Video, err error) {
@@ -39,18 +40,7 @@ func (app *Application) getQueue() (videos []Video, err error) {
 	for rows.Next() {
 		var video Video
 
-		if err := rows.Scan(
-			&video.Id,
-			pq.Array(&video.Submitters),
-			&video.Start,
-			&video.Finished,
-			&video.Title,
-			&video.ChannelName,
-			&video.ChannelId,
-			&video.Thumbnail,
-			&video.FileSize,
-			&video.Length,
-			&video.Downloads); err != nil {
+		if err := scanVideo(rows, &video); err != nil {
 			sentry.CaptureException(err)
 			log.Println("Error scanning videos:", err)
 			continue
@@ -79,3 +69,75 @@ func (app *Application) GetQueue(w http.ResponseWriter, _ *http.Request) {
 
 	SerializeJson(w, videos)
 }
+
+// queueLivestream adds the livestream `videoId` to the queue on behalf of `submitter` and schedules its recording.
+// Returns nil if the livestream already is in the queue or has been archived before.
+func (app *Application) queueLivestream(videoId string, submitter string, request VideoRequest) (*Video, error) {
+	tx, err := app.db.Begin()
+
+	if err != nil {
+		sentry.CaptureException(err)
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+
+	defer tx.Rollback()
+
+	var video Video
+
+	// Video already exists in db, skip it
+	if err := scanVideo(tx.QueryRow("select * from videos where id = $1 limit 1", videoId), &video); err == nil {
+		return nil, nil
+	} else if err != sql.ErrNoRows {
+		sentry.CaptureException(err)
+		return nil, err
+	}
+
+	videoMetadata, err := GetVideoMetadata(videoId)
+
+	if err != nil {
+		sentry.CaptureException(err)
+		return nil, fmt.Errorf("failed to get video meta data: %w", err)
+	}
+
+	startTime, err := GetVideoStartTime(videoMetadata)
+
+	if err != nil {
+		sentry.CaptureException(err)
+		return nil, fmt.Errorf("failed to get video start time: %w", err)
+	}
+
+	thumbnailUrl, err := app.SaveThumbnail(videoId, videoMetadata.Thumbnail)
+
+	if err != nil {
+		sentry.CaptureException(err)
+		return nil, fmt.Errorf("failed to save thumbnail: %w", err)
+	}
+
+	row := tx.QueryRow(
+		"insert into videos (id, submitters, start, title, channel_name, channel_id, thumbnail, category) values ($1, $2, $3, $4, $5, $6, $7, $8) returning *",
+		videoId,
+		pq.Array([]string{submitter}),
+		startTime,
+		videoMetadata.Title,
+		videoMetadata.ChannelName,
+		videoMetadata.ChannelId,
+		thumbnailUrl,
+		nullIfEmpty(videoMetadata.Category))
+
+	if err = scanVideo(row, &video); err != nil {
+		sentry.CaptureException(err)
+		return nil, fmt.Errorf("failed to create video: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		sentry.CaptureException(err)
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	if err := app.scheduleVideo(videoMetadata, video.Id, request); err != nil {
+		return nil, fmt.Errorf("failed to schedule video: %w", err)
+	}
+
+	go app.UpsertVideo(video)
+	return &video, nil
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"pomu/video"
+	"sync"
+	"time"
+)
+
+// RecordingProgress is a snapshot of what a recording in progress has done so far
+type RecordingProgress struct {
+	VideoId         string    `json:"videoId"`
+	Part            int32     `json:"part"`
+	StartedAt       time.Time `json:"startedAt"`
+	SegmentsFetched int64     `json:"segmentsFetched"`
+	SegmentsFailed  int64     `json:"segmentsFailed"`
+	BytesUploaded   int64     `json:"bytesUploaded"`
+	// FfmpegTime is how many seconds of the livestream ffmpeg has muxed so far
+	FfmpegTime float64 `json:"ffmpegTime"`
+	// FfmpegBitrate is the bitrate in kbit/s as reported by ffmpeg
+	FfmpegBitrate float64 `json:"ffmpegBitrate"`
+	// FfmpegSpeed is the muxing speed relative to real time as reported by ffmpeg
+	FfmpegSpeed           float64    `json:"ffmpegSpeed"`
+	FfmpegDuplicateFrames int64      `json:"ffmpegDuplicateFrames"`
+	FfmpegDroppedFrames   int64      `json:"ffmpegDroppedFrames"`
+	LastPlaylistRefresh   *time.Time `json:"lastPlaylistRefresh,omitempty"`
+	// Stopping is set once the recording has been cancelled and is being finalized
+	Stopping bool `json:"stopping"`
+}
+
+var recordingMuxedSecondsCounter = promauto.NewCounter(prometheus.CounterOpts{
+	Subsystem: "recording",
+	Name:      "muxed_seconds",
+	Help:      "Seconds of livestreams muxed by ffmpeg",
+})
+
+var recordingDuplicateFramesCounter = promauto.NewCounter(prometheus.CounterOpts{
+	Subsystem: "recording",
+	Name:      "duplicate_frames",
+	Help:      "Number of frames ffmpeg duplicated while muxing",
+})
+
+var recordingDroppedFramesCounter = promauto.NewCounter(prometheus.CounterOpts{
+	Subsystem: "recording",
+	Name:      "dropped_frames",
+	Help:      "Number of frames ffmpeg dropped while muxing",
+})
+
+// activeRecording is a recording which is currently in progress
+type activeRecording struct {
+	// stop stops capturing new segments, everything captured until then is still muxed and uploaded
+	stop context.CancelFunc
+	// done is closed once the recording has been removed
+	done chan struct{}
+
+	mutex    sync.Mutex
+	progress RecordingProgress
+}
+
+// update modifies the progress of the recording
+func (recording *activeRecording) update(modify func(progress *RecordingProgress)) {
+	recording.mutex.Lock()
+	defer recording.mutex.Unlock()
+
+	modify(&recording.progress)
+}
+
+// updateMuxer applies a progress report of ffmpeg to the progress of the recording and the metrics
+func (recording *activeRecording) updateMuxer(report video.Progress) {
+	recording.update(func(progress *RecordingProgress) {
+		// the counters only ever grow while ffmpeg is running, only add what is new since the last report
+		if seconds := report.OutTime.Seconds(); seconds > progress.FfmpegTime {
+			recordingMuxedSecondsCounter.Add(seconds - progress.FfmpegTime)
+			progress.FfmpegTime = seconds
+		}
+
+		if report.DuplicateFrames > progress.FfmpegDuplicateFrames {
+			recordingDuplicateFramesCounter.Add(float64(report.DuplicateFrames - progress.FfmpegDuplicateFrames))
+			progress.FfmpegDuplicateFrames = report.DuplicateFrames
+		}
+
+		if report.DroppedFrames > progress.FfmpegDroppedFrames {
+			recordingDroppedFramesCounter.Add(float64(report.DroppedFrames - progress.FfmpegDroppedFrames))
+			progress.FfmpegDroppedFrames = report.DroppedFrames
+		}
+
+		progress.FfmpegBitrate = report.Bitrate
+		progress.FfmpegSpeed = report.Speed
+	})
+}
+
+func (recording *activeRecording) snapshot() RecordingProgress {
+	recording.mutex.Lock()
+	defer recording.mutex.Unlock()
+
+	return recording.progress
+}
+
+// Recordings keeps track of every recording which is currently in progress
+type Recordings struct {
+	mutex  sync.Mutex
+	active map[string]*activeRecording
+}
+
+func NewRecordings() *Recordings {
+	return &Recordings{active: make(map[string]*activeRecording)}
+}
+
+func (recordings *Recordings) add(videoId string, part int32, stop context.CancelFunc) *activeRecording {
+	recordings.mutex.Lock()
+	defer recordings.mutex.Unlock()
+
+	recording := &activeRecording{
+		stop: stop,
+		done: make(chan struct{}),
+		progress: RecordingProgress{
+			VideoId:   videoId,
+			Part:      part,
+			StartedAt: time.Now(),
+		},
+	}
+
+	recordings.active[videoId] = recording
+	return recording
+}
+
+func (recordings *Recordings) remove(videoId string) {
+	recordings.mutex.Lock()
+	defer recordings.mutex.Unlock()
+
+	if recording, ok := recordings.active[videoId]; ok {
+		close(recording.done)
+		delete(recordings.active, videoId)
+	}
+}
+
+func (recordings *Recordings) get(videoId string) (*activeRecording, bool) {
+	recordings.mutex.Lock()
+	defer recordings.mutex.Unlock()
+
+	recording, ok := recordings.active[videoId]
+	return recording, ok
+}
+
+// Stop stops the recording of `videoId` and finalizes whatever has been captured so far.
+// Returns false if `videoId` is not being recorded.
+func (recordings *Recordings) Stop(videoId string) bool {
+	recordings.mutex.Lock()
+	defer recordings.mutex.Unlock()
+
+	recording, ok := recordings.active[videoId]
+
+	if !ok {
+		return false
+	}
+
+	recording.stop()
+	recording.update(func(progress *RecordingProgress) {
+		progress.Stopping = true
+	})
+	return true
+}
+
+func (app *Application) CancelRecording(w http.ResponseWriter, r *http.Request) {
+	videoId := mux.Vars(r)["id"]
+	user, ok := app.resolveSubmitter(w, r, videoId, "only submitters of this video can cancel its recording")
+
+	if !ok {
+		return
+	}
+
+	if !app.recordings.Stop(videoId) {
+		http.Error(w, "video is not being recorded", http.StatusConflict)
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"video_id": videoId,
+		"user":     user.Provider + "/" + user.Id,
+	}).Info("recording cancelled, finalizing what has been captured")
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (app *Application) GetProgress(w http.ResponseWriter, r *http.Request) {
+	recording, ok := app.recordings.get(mux.Vars(r)["id"])
+
+	if !ok {
+		http.Error(w, "video is not being recorded", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-cache, max-age=0, must-revalidate")
+
+	SerializeJson(w, recording.snapshot())
+}
+
+// progressStreamInterval is how often StreamProgress sends the progress of a recording
+const progressStreamInterval = 2 * time.Second
+
+// StreamProgress sends the progress of a recording as server-sent events until the recording is done
+func (app *Application) StreamProgress(w http.ResponseWriter, r *http.Request) {
+	recording, ok := app.recordings.get(mux.Vars(r)["id"])
+
+	if !ok {
+		http.Error(w, "video is not being recorded", http.StatusNotFound)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	ticker := time.NewTicker(progressStreamInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := writeEvent(w, "progress", recording.snapshot()); err != nil {
+			return
+		}
+
+		flusher.Flush()
+
+		select {
+		case <-ticker.C:
+		case <-recording.done:
+			_ = writeEvent(w, "finished", recording.snapshot())
+			flusher.Flush()
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// writeEvent writes `value` as json encoded server-sent event named `event`
+func writeEvent(w http.ResponseWriter, event string, value any) error {
+	data, err := json.Marshal(value)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	return err
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"github.com/getsentry/sentry-go"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/api/youtube/v3"
+	"io"
+	"net/http"
+	"os"
+	"pomu/source"
+	"time"
+)
+
+// defaultMetadataRefreshWindow is how long after its start a finished video is still refreshed unless
+// METADATA_REFRESH_WINDOW is set. Thumbnails are usually replaced within a few days after the livestream.
+const defaultMetadataRefreshWindow = 7 * 24 * time.Hour
+
+// metadataBatchSize is the maximum amount of videos the YouTube API returns per request
+const metadataBatchSize = 50
+
+// VideoRevision is how the title and thumbnail of a video looked like at some point
+type VideoRevision struct {
+	Title string `json:"title"`
+	// Thumbnail is the storage key of the thumbnail, which is resolved to a url when serving revisions
+	Thumbnail     string    `json:"thumbnail"`
+	ThumbnailHash string    `json:"thumbnailHash"`
+	ObservedAt    time.Time `json:"observedAt"`
+}
+
+// RefreshMetadata refreshes title, thumbnail and description of every queued or recently finished video
+func RefreshMetadata(app *Application) {
+	window := defaultMetadataRefreshWindow
+
+	if configured, err := time.ParseDuration(os.Getenv("METADATA_REFRESH_WINDOW")); err == nil && configured > 0 {
+		window = configured
+	}
+
+	rows, err := app.db.Query("select id from videos where not finished or start > $1", time.Now().Add(-window))
+
+	if err != nil {
+		sentry.CaptureException(err)
+		log.WithFields(log.Fields{"error": err}).Error("failed to query videos to refresh metadata of")
+		return
+	}
+
+	var videoIds []string
+
+	for rows.Next() {
+		var videoId string
+
+		if err := rows.Scan(&videoId); err != nil {
+			sentry.CaptureException(err)
+			continue
+		}
+
+		// other sources forget about livestreams once they are over
+		if !source.IsYouTube(videoId) {
+			continue
+		}
+
+		videoIds = append(videoIds, videoId)
+	}
+
+	_ = rows.Close()
+
+	refreshed := 0
+
+	for start := 0; start < len(videoIds); start += metadataBatchSize {
+		end := start + metadataBatchSize
+
+		if end > len(videoIds) {
+			end = len(videoIds)
+		}
+
+		metadata, err := GetVideosMetadata(videoIds[start:end])
+
+		if err != nil {
+			sentry.CaptureException(err)
+			log.WithFields(log.Fields{"error": err}).Error("failed to get metadata from youtube")
+			return
+		}
+
+		for _, videoId := range videoIds[start:end] {
+			// videos which went private or got deleted keep what has been known last
+			if videoMetadata, ok := metadata[videoId]; ok && videoMetadata.Snippet != nil {
+				if err := app.refreshVideoMetadata(videoId, videoMetadata); err != nil {
+					log.WithFields(log.Fields{"video_id": videoId, "error": err}).Warn("failed to refresh metadata")
+					continue
+				}
+
+				refreshed += 1
+			}
+		}
+	}
+
+	log.WithFields(log.Fields{"videos": refreshed}).Info("refreshed metadata")
+}
+
+// revisionThumbnailKey returns the storage key of the thumbnail of `videoId` with the hex encoded sha256 `hash`
+func revisionThumbnailKey(videoId string, hash string) string {
+	return fmt.Sprintf("%s.%s.jpg", videoId, hash[:16])
+}
+
+// downloadThumbnail returns the thumbnail at `url` and its hex encoded sha256 hash
+func downloadThumbnail(url string) ([]byte, string, error) {
+	response, err := http.Get(url)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("unexpected status %s", response.Status)
+	}
+
+	data, err := io.ReadAll(response.Body)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	hash := sha256.Sum256(data)
+	return data, hex.EncodeToString(hash[:]), nil
+}
+
+// refreshVideoMetadata stores a new revision if the title or the thumbnail of `videoId` changed, and updates the
+// video to the current metadata
+func (app *Application) refreshVideoMetadata(videoId string, metadata *youtube.Video) error {
+	var latest VideoRevision
+	hasRevision := true
+
+	err := app.db.QueryRow(
+		"select title, thumbnail, thumbnail_hash, observed_at from video_revisions where video_id = $1 order by observed_at desc, id desc limit 1",
+		videoId).Scan(&latest.Title, &latest.Thumbnail, &latest.ThumbnailHash, &latest.ObservedAt)
+
+	if err == sql.ErrNoRows {
+		hasRevision = false
+	} else if err != nil {
+		sentry.CaptureException(err)
+		return err
+	}
+
+	var description *string
+
+	if err := app.db.QueryRow("select description from videos where id = $1", videoId).Scan(&description); err != nil {
+		sentry.CaptureException(err)
+		return err
+	}
+
+	title := metadata.Snippet.Title
+	thumbnailUrl := source.YouTubeThumbnail(metadata.Snippet.Thumbnails)
+
+	data, hash, err := downloadThumbnail(thumbnailUrl)
+	if err != nil {
+		return fmt.Errorf("failed to download thumbnail: %w", err)
+	}
+
+	titleChanged := !hasRevision || latest.Title != title
+	thumbnailChanged := !hasRevision || latest.ThumbnailHash != hash
+	descriptionChanged := description == nil || *description != metadata.Snippet.Description
+
+	revisionThumbnail := latest.Thumbnail
+	thumbnail := ""
+
+	if thumbnailChanged {
+		// every version of the thumbnail is kept, the latest one is additionally stored as <id>.jpg. The same data is
+		// uploaded to both so that <id>.jpg always matches the hash of the latest revision.
+		revisionThumbnail = revisionThumbnailKey(videoId, hash)
+
+		if err := app.storage.Upload(context.Background(), revisionThumbnail, bytes.NewReader(data), "image/jpeg"); err != nil {
+			return err
+		}
+
+		if err := app.storage.Upload(context.Background(), ThumbnailKey(videoId), bytes.NewReader(data), "image/jpeg"); err != nil {
+			return err
+		}
+
+		thumbnail = ThumbnailUrl(videoId)
+	}
+
+	tx, err := app.db.Begin()
+
+	if err != nil {
+		sentry.CaptureException(err)
+		return err
+	}
+
+	defer tx.Rollback()
+
+	if titleChanged || thumbnailChanged {
+		_, err := tx.Exec(
+			"insert into video_revisions (video_id, title, thumbnail, thumbnail_hash) values ($1, $2, $3, $4)",
+			videoId, title, revisionThumbnail, hash)
+
+		if err != nil {
+			sentry.CaptureException(err)
+			return err
+		}
+	}
+
+	var archive Video
+	var row *sql.Row
+
+	if thumbnailChanged {
+		row = tx.QueryRow(
+			"update videos set title = $1, description = $2, thumbnail = $3, metadata_refreshed_at = now() where id = $4 returning *",
+			title, metadata.Snippet.Description, thumbnail, videoId)
+	} else {
+		row = tx.QueryRow(
+			"update videos set title = $1, description = $2, metadata_refreshed_at = now() where id = $3 returning *",
+			title, metadata.Snippet.Description, videoId)
+	}
+
+	if err := scanVideo(row, &archive); err != nil {
+		sentry.CaptureException(err)
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		sentry.CaptureException(err)
+		return err
+	}
+
+	if hasRevision && (titleChanged || thumbnailChanged) {
+		log.WithFields(log.Fields{
+			"video_id":          videoId,
+			"title":             title,
+			"thumbnail_changed": thumbnailChanged,
+		}).Info("metadata of video changed")
+	}
+
+	if titleChanged || thumbnailChanged || descriptionChanged {
+		go app.UpsertVideo(archive)
+	}
+
+	return nil
+}
+
+func (app *Application) GetRevisions(w http.ResponseWriter, r *http.Request) {
+	rows, err := app.db.Query(
+		"select title, thumbnail, thumbnail_hash, observed_at from video_revisions where video_id = $1 order by observed_at, id",
+		mux.Vars(r)["id"])
+
+	if err != nil {
+		sentry.CaptureException(err)
+		http.Error(w, "failed to query for revisions", http.StatusInternalServerError)
+		return
+	}
+
+	defer rows.Close()
+
+	revisions := []VideoRevision{}
+
+	for rows.Next() {
+		var revision VideoRevision
+
+		if err := rows.Scan(&revision.Title, &revision.Thumbnail, &revision.ThumbnailHash, &revision.ObservedAt); err != nil {
+			sentry.CaptureException(err)
+			continue
+		}
+
+		thumbnailUrl, err := app.storage.Url(revision.Thumbnail)
+
+		if err != nil {
+			sentry.CaptureException(err)
+			continue
+		}
+
+		revision.Thumbnail = thumbnailUrl
+
+		revisions = append(revisions, revision)
+	}
+
+	SerializeJson(w, revisions)
+}
@@ -0,0 +1,161 @@
+// Package rules decides which livestreams listed on Holodex are archived automatically
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Reasons a rule matched a stream, in the order they are checked
+const (
+	// ReasonChannel is a stream of a channel in IncludeChannels
+	ReasonChannel = "channel"
+	// ReasonOrg is a stream of a channel of an org in IncludeOrgs
+	ReasonOrg = "org"
+	// ReasonMention is a collab which mentions a channel in IncludeChannels
+	ReasonMention = "mention"
+)
+
+// Channel is a channel as listed on Holodex
+type Channel struct {
+	Id  string
+	Org string
+}
+
+// Stream is an upcoming or running livestream as listed on Holodex
+type Stream struct {
+	Title   string
+	TopicId string
+	Channel Channel
+	// Mentions are the other channels taking part in a collab
+	Mentions []Channel
+}
+
+// Rule selects livestreams by the channel they are on and filters them by topic and title. Orgs and topics are
+// compared case-insensitively, titles are matched by case-insensitive regular expressions.
+type Rule struct {
+	IncludeOrgs     []string `json:"includeOrgs"`
+	ExcludeOrgs     []string `json:"excludeOrgs"`
+	IncludeChannels []string `json:"includeChannels"`
+	ExcludeChannels []string `json:"excludeChannels"`
+	// IncludeTopics are the Holodex topics streams have to be listed under, every topic matches if empty
+	IncludeTopics []string `json:"includeTopics"`
+	ExcludeTopics []string `json:"excludeTopics"`
+	// TitleInclude are patterns of which the title has to match at least one, every title matches if empty
+	TitleInclude []string `json:"titleInclude"`
+	TitleExclude []string `json:"titleExclude"`
+	// IncludeMentions also matches collabs hosted by other channels which mention a channel in IncludeChannels.
+	// Mentions of channels which are only tracked through IncludeOrgs do not match, as Holodex cannot list them.
+	IncludeMentions bool `json:"includeMentions"`
+}
+
+// Validate checks that the rule tracks any channel and that every title pattern compiles. Rules including whole orgs
+// have to be narrowed down by topic or title, as they would archive hundreds of streams a day otherwise.
+func (r *Rule) Validate() error {
+	if len(r.IncludeOrgs) == 0 && len(r.IncludeChannels) == 0 {
+		return errors.New("at least one org or channel has to be included")
+	}
+
+	if len(r.IncludeOrgs) > 0 && len(r.IncludeTopics) == 0 && len(r.TitleInclude) == 0 {
+		return errors.New("rules including orgs need at least one included topic or title pattern")
+	}
+
+	for _, pattern := range append(append([]string{}, r.TitleInclude...), r.TitleExclude...) {
+		if _, err := compile(pattern); err != nil {
+			return fmt.Errorf("invalid title pattern %s: %w", pattern, err)
+		}
+	}
+
+	return nil
+}
+
+// Match returns whether the rule archives `stream` and why
+func (r *Rule) Match(stream *Stream) (string, bool) {
+	reason := r.track(stream)
+
+	if len(reason) == 0 {
+		return "", false
+	}
+
+	if contains(r.ExcludeChannels, stream.Channel.Id) || containsFold(r.ExcludeOrgs, stream.Channel.Org) {
+		return "", false
+	}
+
+	if len(r.IncludeTopics) > 0 && !containsFold(r.IncludeTopics, stream.TopicId) {
+		return "", false
+	}
+
+	if containsFold(r.ExcludeTopics, stream.TopicId) {
+		return "", false
+	}
+
+	if len(r.TitleInclude) > 0 && !matchesAny(r.TitleInclude, stream.Title) {
+		return "", false
+	}
+
+	if matchesAny(r.TitleExclude, stream.Title) {
+		return "", false
+	}
+
+	return reason, true
+}
+
+// track returns why the channel of `stream` is tracked, or an empty string if it is not
+func (r *Rule) track(stream *Stream) string {
+	if contains(r.IncludeChannels, stream.Channel.Id) {
+		return ReasonChannel
+	}
+
+	if containsFold(r.IncludeOrgs, stream.Channel.Org) {
+		return ReasonOrg
+	}
+
+	if !r.IncludeMentions {
+		return ""
+	}
+
+	for _, mention := range stream.Mentions {
+		if contains(r.IncludeChannels, mention.Id) {
+			return ReasonMention
+		}
+	}
+
+	return ""
+}
+
+func compile(pattern string) (*regexp.Regexp, error) {
+	return regexp.Compile("(?i)" + pattern)
+}
+
+func matchesAny(patterns []string, title string) bool {
+	for _, pattern := range patterns {
+		// invalid patterns are rejected by Validate, so they never match
+		if expression, err := compile(pattern); err == nil && expression.MatchString(title) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return len(value) > 0
+		}
+	}
+
+	return false
+}
+
+func containsFold(values []string, value string) bool {
+	for _, candidate := range values {
+		if strings.EqualFold(candidate, value) {
+			return len(value) > 0
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,82 @@
+package rules
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	pekora = "UC1DCedRgGHBdm81E1llLhOQ"
+	marine = "UCCzUftO8KOVkV4wQG1vkUvg"
+	kuzuha = "UCSFCh5NL4qXrAy9u-u2lX3g"
+)
+
+func TestValidate(t *testing.T) {
+	assert.Error(t, (&Rule{}).Validate())
+	assert.Error(t, (&Rule{IncludeTopics: []string{"singing"}}).Validate())
+	assert.Error(t, (&Rule{IncludeOrgs: []string{"Hololive"}, TitleInclude: []string{"karaoke"}, TitleExclude: []string{"("}}).Validate())
+	assert.Error(t, (&Rule{IncludeOrgs: []string{"Hololive"}}).Validate())
+	assert.Error(t, (&Rule{IncludeOrgs: []string{"Hololive"}, ExcludeTopics: []string{"minecraft"}}).Validate())
+	assert.NoError(t, (&Rule{IncludeOrgs: []string{"Hololive"}, IncludeTopics: []string{"singing"}}).Validate())
+	assert.NoError(t, (&Rule{IncludeOrgs: []string{"Hololive"}, TitleInclude: []string{"歌枠"}}).Validate())
+	assert.NoError(t, (&Rule{IncludeChannels: []string{pekora}, TitleInclude: []string{"歌枠|karaoke"}}).Validate())
+}
+
+func TestMatch(t *testing.T) {
+	rule := &Rule{
+		IncludeOrgs:     []string{"hololive"},
+		IncludeChannels: []string{kuzuha},
+		ExcludeChannels: []string{marine},
+		IncludeTopics:   []string{"singing"},
+		TitleExclude:    []string{"unarchived"},
+	}
+
+	for _, test := range []struct {
+		name   string
+		stream Stream
+		reason string
+	}{
+		{"org", Stream{Title: "歌枠", TopicId: "Singing", Channel: Channel{Id: pekora, Org: "Hololive"}}, ReasonOrg},
+		{"channel", Stream{Title: "karaoke", TopicId: "singing", Channel: Channel{Id: kuzuha, Org: "Nijisanji"}}, ReasonChannel},
+		{"excluded channel", Stream{TopicId: "singing", Channel: Channel{Id: marine, Org: "Hololive"}}, ""},
+		{"other topic", Stream{TopicId: "minecraft", Channel: Channel{Id: pekora, Org: "Hololive"}}, ""},
+		{"no topic", Stream{Channel: Channel{Id: pekora, Org: "Hololive"}}, ""},
+		{"excluded title", Stream{Title: "UNARCHIVED karaoke", TopicId: "singing", Channel: Channel{Id: pekora, Org: "Hololive"}}, ""},
+		{"other org", Stream{TopicId: "singing", Channel: Channel{Id: "UCother", Org: "VShojo"}}, ""},
+		{"mentions are disabled", Stream{TopicId: "singing", Channel: Channel{Id: "UCother", Org: "VShojo"}, Mentions: []Channel{{Id: pekora, Org: "Hololive"}}}, ""},
+	} {
+		reason, ok := rule.Match(&test.stream)
+
+		assert.Equal(t, test.reason, reason, test.name)
+		assert.Equal(t, len(test.reason) > 0, ok, test.name)
+	}
+}
+
+func TestMatchMentions(t *testing.T) {
+	rule := &Rule{
+		IncludeOrgs:     []string{"Hololive"},
+		IncludeChannels: []string{pekora},
+		ExcludeOrgs:     []string{"Nijisanji"},
+		TitleInclude:    []string{"collab", "コラボ"},
+		IncludeMentions: true,
+	}
+
+	for _, test := range []struct {
+		name   string
+		stream Stream
+		reason string
+	}{
+		{"mentioned", Stream{Title: "【コラボ】", Channel: Channel{Id: "UCother", Org: "VShojo"}, Mentions: []Channel{{Id: marine}, {Id: pekora}}}, ReasonMention},
+		{"org mentioned", Stream{Title: "collab", Channel: Channel{Id: "UCother", Org: "VShojo"}, Mentions: []Channel{{Id: marine, Org: "Hololive"}}}, ""},
+		{"not mentioned", Stream{Title: "collab", Channel: Channel{Id: "UCother", Org: "VShojo"}, Mentions: []Channel{{Id: marine}}}, ""},
+		{"host org excluded", Stream{Title: "collab", Channel: Channel{Id: kuzuha, Org: "Nijisanji"}, Mentions: []Channel{{Id: pekora}}}, ""},
+		{"title does not match", Stream{Title: "minecraft", Channel: Channel{Id: "UCother"}, Mentions: []Channel{{Id: pekora}}}, ""},
+		{"own stream", Stream{Title: "Collab", Channel: Channel{Id: pekora, Org: "Hololive"}}, ReasonChannel},
+	} {
+		reason, ok := rule.Match(&test.stream)
+
+		assert.Equal(t, test.reason, reason, test.name)
+		assert.Equal(t, len(test.reason) > 0, ok, test.name)
+	}
+}
@@ -1,16 +1,26 @@
 package s3
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io"
+	"net/http"
+	"net/url"
 	"os"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 )
 
+// ErrNotFound is returned by Download if there is no object at the path
+var ErrNotFound = errors.New("object not found")
+
 type Client struct {
 	bucket   string
 	s3       *s3.S3
@@ -37,7 +47,7 @@ func New(bucket string) (*Client, error) {
 	return &Client{bucket: bucket, s3: client, uploader: uploader}, nil
 }
 
-func (client *Client) Upload(path string, reader io.Reader, contentType string) error {
+func (client *Client) Upload(ctx context.Context, path string, reader io.Reader, contentType string) error {
 	if len(contentType) <= 0 {
 		contentType = "binary/octet-stream"
 	}
@@ -49,7 +59,7 @@ func (client *Client) Upload(path string, reader io.Reader, contentType string)
 		contentDisposition = "attachment"
 	}
 
-	_, err := client.uploader.Upload(&s3manager.UploadInput{
+	_, err := client.uploader.UploadWithContext(ctx, &s3manager.UploadInput{
 		Body:               reader,
 		Bucket:             aws.String(client.bucket),
 		Key:                aws.String(path),
@@ -60,3 +70,222 @@ func (client *Client) Upload(path string, reader io.Reader, contentType string)
 	})
 	return err
 }
+
+// maxCopyObjectSize is the largest object S3 allows to be copied in a single CopyObject request
+const maxCopyObjectSize = 5 * 1000 * 1000 * 1000
+
+// copyPartSize is the size of each part if an object has to be copied using a multipart upload
+const copyPartSize = 1000 * 1000 * 1000
+
+// CompleteAbandonedUpload completes any multipart upload for `path` which was left behind by an interrupted upload,
+// making the parts which were already uploaded available as a regular object. Returns whether an upload was completed.
+func (client *Client) CompleteAbandonedUpload(path string) (bool, error) {
+	var uploadIds []string
+
+	err := client.s3.ListMultipartUploadsPages(&s3.ListMultipartUploadsInput{
+		Bucket: aws.String(client.bucket),
+		Prefix: aws.String(path),
+	}, func(output *s3.ListMultipartUploadsOutput, _ bool) bool {
+		for _, upload := range output.Uploads {
+			if aws.StringValue(upload.Key) == path {
+				uploadIds = append(uploadIds, aws.StringValue(upload.UploadId))
+			}
+		}
+		return true
+	})
+
+	if err != nil {
+		return false, err
+	}
+
+	completed := false
+
+	for _, uploadId := range uploadIds {
+		var parts []*s3.CompletedPart
+
+		err := client.s3.ListPartsPages(&s3.ListPartsInput{
+			Bucket:   aws.String(client.bucket),
+			Key:      aws.String(path),
+			UploadId: aws.String(uploadId),
+		}, func(output *s3.ListPartsOutput, _ bool) bool {
+			for _, part := range output.Parts {
+				parts = append(parts, &s3.CompletedPart{ETag: part.ETag, PartNumber: part.PartNumber})
+			}
+			return true
+		})
+
+		if err != nil {
+			return completed, err
+		}
+
+		// nothing was uploaded before the upload got interrupted, just get rid of it
+		if len(parts) == 0 {
+			_, err := client.s3.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+				Bucket:   aws.String(client.bucket),
+				Key:      aws.String(path),
+				UploadId: aws.String(uploadId),
+			})
+
+			if err != nil {
+				return completed, err
+			}
+
+			continue
+		}
+
+		_, err = client.s3.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+			Bucket:          aws.String(client.bucket),
+			Key:             aws.String(path),
+			UploadId:        aws.String(uploadId),
+			MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+		})
+
+		if err != nil {
+			return completed, err
+		}
+
+		completed = true
+	}
+
+	return completed, nil
+}
+
+// ObjectInfo describes a stored object
+type ObjectInfo struct {
+	Size         int64
+	ContentType  string
+	LastModified time.Time
+}
+
+// Stat returns information about the object at `path`. If the object does not exist, `info` will be nil.
+func (client *Client) Stat(path string) (info *ObjectInfo, err error) {
+	output, err := client.s3.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(client.bucket),
+		Key:    aws.String(path),
+	})
+
+	if err != nil {
+		if requestErr, ok := err.(awserr.RequestFailure); ok && requestErr.StatusCode() == http.StatusNotFound {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return &ObjectInfo{
+		Size:         aws.Int64Value(output.ContentLength),
+		ContentType:  aws.StringValue(output.ContentType),
+		LastModified: aws.TimeValue(output.LastModified),
+	}, nil
+}
+
+// Download opens the object at `path` for reading
+func (client *Client) Download(path string) (io.ReadCloser, error) {
+	output, err := client.s3.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(client.bucket),
+		Key:    aws.String(path),
+	})
+
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == s3.ErrCodeNoSuchKey {
+			return nil, ErrNotFound
+		}
+
+		return nil, err
+	}
+
+	return output.Body, nil
+}
+
+// Presign returns a url at which the object at `path` can be downloaded without credentials until `expire` has passed
+func (client *Client) Presign(path string, expire time.Duration) (string, error) {
+	request, _ := client.s3.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(client.bucket),
+		Key:    aws.String(path),
+	})
+
+	return request.Presign(expire)
+}
+
+// Copy copies the object at `source` to `destination`, replacing `destination` if it already exists
+func (client *Client) Copy(source string, destination string) error {
+	info, err := client.Stat(source)
+
+	if err != nil {
+		return err
+	}
+
+	if info == nil {
+		return fmt.Errorf("cannot copy %s as it does not exist", source)
+	}
+
+	size := info.Size
+
+	copySource := aws.String(url.PathEscape(client.bucket + "/" + source))
+
+	if size <= maxCopyObjectSize {
+		_, err := client.s3.CopyObject(&s3.CopyObjectInput{
+			Bucket:     aws.String(client.bucket),
+			Key:        aws.String(destination),
+			CopySource: copySource,
+		})
+		return err
+	}
+
+	// objects larger than 5 GB have to be copied in parts
+	upload, err := client.s3.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+		Bucket: aws.String(client.bucket),
+		Key:    aws.String(destination),
+	})
+
+	if err != nil {
+		return err
+	}
+
+	var parts []*s3.CompletedPart
+
+	for offset, partNumber := int64(0), int64(1); offset < size; offset, partNumber = offset+copyPartSize, partNumber+1 {
+		end := offset + copyPartSize - 1
+
+		if end >= size {
+			end = size - 1
+		}
+
+		output, err := client.s3.UploadPartCopy(&s3.UploadPartCopyInput{
+			Bucket:          aws.String(client.bucket),
+			Key:             aws.String(destination),
+			UploadId:        upload.UploadId,
+			PartNumber:      aws.Int64(partNumber),
+			CopySource:      copySource,
+			CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", offset, end)),
+		})
+
+		if err != nil {
+			_, _ = client.s3.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+				Bucket:   aws.String(client.bucket),
+				Key:      aws.String(destination),
+				UploadId: upload.UploadId,
+			})
+			return err
+		}
+
+		parts = append(parts, &s3.CompletedPart{ETag: output.CopyPartResult.ETag, PartNumber: aws.Int64(partNumber)})
+	}
+
+	_, err = client.s3.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(client.bucket),
+		Key:             aws.String(destination),
+		UploadId:        upload.UploadId,
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	})
+	return err
+}
+
+// Delete deletes the object at `path`
+func (client *Client) Delete(path string) error {
+	_, err := client.s3.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(client.bucket),
+		Key:    aws.String(path),
+	})
+	return err
+}
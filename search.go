@@ -3,7 +3,6 @@ package main
 import (
 	"database/sql"
 	"encoding/json"
-	"github.com/lib/pq"
 	"github.com/meilisearch/meilisearch-go"
 	log "github.com/sirupsen/logrus"
 	"net/http"
@@ -82,6 +81,7 @@ func (app *Application) SetupSearch() {
 		"channelId",
 		"fileSizeBytes",
 		"length",
+		"availability",
 	})
 
 	sortableTaskInfo, _ := app.search.UpdateSortableAttributes(&[]string{
@@ -176,18 +176,7 @@ func AllVideos(db *sql.DB) ([]Video, error) {
 	for rows.Next() {
 		var video Video
 
-		if err := rows.Scan(
-			&video.Id,
-			pq.Array(&video.Submitters),
-			&video.Start,
-			&video.Finished,
-			&video.Title,
-			&video.ChannelName,
-			&video.ChannelId,
-			&video.Thumbnail,
-			&video.FileSize,
-			&video.Length,
-			&video.Downloads); err != nil {
+		if err := scanVideo(rows, &video); err != nil {
 			log.WithFields(log.Fields{"error": err}).Warn("failed to scan row into Video")
 			continue
 		}
@@ -3,7 +3,9 @@ package main
 import (
 	"database/sql"
 	"github.com/getsentry/sentry-go"
+	"github.com/lib/pq"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/exp/slices"
 	"net/http"
 	"time"
 )
@@ -166,6 +168,37 @@ func (app *Application) ResolveUserFromRequest(r *http.Request) (*User, error) {
 	return FindSessionAssociatedUser(session.UserId, session.Provider, session.Hash, app.db)
 }
 
+// resolveSubmitter returns the logged-in user if they submitted `videoId`. Otherwise an error is written to `w`, using
+// `forbidden` as message if the user is not a submitter.
+func (app *Application) resolveSubmitter(w http.ResponseWriter, r *http.Request, videoId string, forbidden string) (*User, bool) {
+	user, err := app.ResolveUserFromRequest(r)
+
+	if user == nil || err != nil {
+		http.Error(w, "please login first", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	var submitters []string
+
+	if err := app.db.QueryRow("select submitters from videos where id = $1", videoId).Scan(pq.Array(&submitters)); err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "video not found", http.StatusNotFound)
+			return nil, false
+		}
+
+		sentry.CaptureException(err)
+		http.Error(w, "failed to query video", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if !slices.Contains(submitters, user.Provider+"/"+user.Id) {
+		http.Error(w, forbidden, http.StatusForbidden)
+		return nil, false
+	}
+
+	return user, true
+}
+
 func (app *Application) ResolveSessionFromRequest(r *http.Request) (*Session, error) {
 	cookie, err := r.Cookie("pomu")
 
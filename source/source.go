@@ -0,0 +1,165 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"pomu/hls"
+	"strings"
+	"time"
+)
+
+// ErrUnsupportedUrl indicates that no provider knows how to archive the url
+var ErrUnsupportedUrl = errors.New("url is not supported by any provider")
+
+// ErrNotStarted indicates that the livestream has not started
+var ErrNotStarted = errors.New("livestream has not started")
+
+// ErrNotFound indicates that the provider does not know the video (anymore)
+var ErrNotFound = errors.New("video not found")
+
+// State is the live state of a livestream
+type State string
+
+const (
+	// StateNone is a regular video or a channel which is not live
+	StateNone     State = "none"
+	StateUpcoming State = "upcoming"
+	StateLive     State = "live"
+	StateEnded    State = "ended"
+)
+
+// Metadata is what a provider knows about a livestream
+type Metadata struct {
+	Id          string
+	Title       string
+	Description string
+	ChannelId   string
+	ChannelName string
+	// Category is the game or topic the livestream is listed under, empty if the provider has no such thing
+	Category string
+	// Thumbnail is the url of the highest resolution thumbnail available
+	Thumbnail string
+	State     State
+	// ScheduledStart, ActualStart and ActualEnd are zero if they are not known (yet)
+	ScheduledStart time.Time
+	ActualStart    time.Time
+	ActualEnd      time.Time
+}
+
+// Provider is a platform pomu can archive livestreams from
+type Provider interface {
+	// Name is the namespace of the ids of this provider
+	Name() string
+	// Matches returns whether `u` belongs to this provider
+	Matches(u *url.URL) bool
+	// ParseId returns the id of the livestream at `u`
+	ParseId(u *url.URL) (string, error)
+	// Url returns the url of the livestream `id`
+	Url(id string) string
+	// Metadata gets the current metadata of the livestream `id`
+	Metadata(ctx context.Context, id string) (*Metadata, error)
+	// Playlist returns the hls playlist of the livestream at `url` in the yt-dlp format `format`. With `master` the
+	// master playlist is returned instead, leaving the variant selection to the hls client.
+	Playlist(url string, format string, master bool) hls.RemotePlaylist
+}
+
+// youtubeIdLength is the length of every YouTube video id. Ids of other providers are namespaced and always longer, so
+// they can neither collide with nor be mistaken for YouTube ids, which may contain underscores as well.
+const youtubeIdLength = 11
+
+// providers contains every provider in the order they are matched against urls. YouTube is always available and
+// the fallback for ids without a namespace, as YouTube ids are stored without one.
+var providers = []Provider{&YouTube{}}
+
+// Register makes `provider` available, it must be called before pomu starts serving
+func Register(provider Provider) {
+	providers = append(providers, provider)
+}
+
+// Providers returns every available provider
+func Providers() []Provider {
+	return providers
+}
+
+// Namespace prefixes `id` with the name of `provider` so that ids of different providers cannot collide. Ids are
+// used as search engine document ids and storage keys, which is why the separator is an underscore.
+func Namespace(provider Provider, id string) string {
+	return provider.Name() + "_" + id
+}
+
+// namespaced returns the name of the provider `id` is namespaced with, or false for YouTube ids
+func namespaced(id string) (string, bool) {
+	if len(id) <= youtubeIdLength {
+		return "", false
+	}
+
+	namespace, _, found := strings.Cut(id, "_")
+	return namespace, found
+}
+
+// IsYouTube returns whether the livestream `id` is from YouTube. Parts of pomu such as the chat recorder or the
+// availability check only work with YouTube.
+func IsYouTube(id string) bool {
+	_, isNamespaced := namespaced(id)
+	return !isNamespaced
+}
+
+// ForName returns the provider called `name`
+func ForName(name string) (Provider, error) {
+	for _, provider := range providers {
+		if provider.Name() == name {
+			return provider, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown provider %s", name)
+}
+
+// ForUrl returns the provider `u` belongs to
+func ForUrl(u *url.URL) (Provider, error) {
+	for _, provider := range providers {
+		if provider.Matches(u) {
+			return provider, nil
+		}
+	}
+
+	return nil, ErrUnsupportedUrl
+}
+
+// ForId returns the provider which the livestream `id` has been archived from
+func ForId(id string) (Provider, error) {
+	namespace, isNamespaced := namespaced(id)
+
+	if !isNamespaced {
+		return providers[0], nil
+	}
+
+	return ForName(namespace)
+}
+
+// ParseId returns the id of the livestream at `rawUrl`
+func ParseId(rawUrl string) (string, error) {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return "", err
+	}
+
+	provider, err := ForUrl(u)
+	if err != nil {
+		return "", err
+	}
+
+	return provider.ParseId(u)
+}
+
+// GetMetadata gets the current metadata of the livestream `id` from the provider it belongs to
+func GetMetadata(ctx context.Context, id string) (*Metadata, error) {
+	provider, err := ForId(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return provider.Metadata(ctx, id)
+}
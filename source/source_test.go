@@ -0,0 +1,107 @@
+package source
+
+import (
+	"context"
+	"net/url"
+	"pomu/hls"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/youtube/v3"
+)
+
+type fakeProvider struct{}
+
+func (f *fakeProvider) Name() string {
+	return "fake"
+}
+
+func (f *fakeProvider) Matches(u *url.URL) bool {
+	return u.Host == "fake.tv"
+}
+
+func (f *fakeProvider) ParseId(u *url.URL) (string, error) {
+	return Namespace(f, u.Path[1:]), nil
+}
+
+func (f *fakeProvider) Url(id string) string {
+	return "https://fake.tv/" + id
+}
+
+func (f *fakeProvider) Metadata(_ context.Context, id string) (*Metadata, error) {
+	return &Metadata{Id: id}, nil
+}
+
+func (f *fakeProvider) Playlist(url string, format string, master bool) hls.RemotePlaylist {
+	return nil
+}
+
+func TestRegistry(t *testing.T) {
+	Register(&fakeProvider{})
+	defer func() { providers = providers[:1] }()
+
+	id, err := ParseId("https://fake.tv/someone")
+	assert.NoError(t, err)
+	assert.Equal(t, "fake_someone", id)
+
+	id, err = ParseId("https://youtu.be/m7Mzgmpr-Qc")
+	assert.NoError(t, err)
+	assert.Equal(t, "m7Mzgmpr-Qc", id)
+
+	_, err = ParseId("https://dev.pomu.app")
+	assert.ErrorIs(t, err, ErrUnsupportedUrl)
+
+	provider, err := ForId("fake_someone")
+	assert.NoError(t, err)
+	assert.Equal(t, "fake", provider.Name())
+
+	provider, err = ForId("m7Mzgmpr-Qc")
+	assert.NoError(t, err)
+	assert.Equal(t, "youtube", provider.Name())
+
+	_, err = ForId("unknown_someone")
+	assert.Error(t, err)
+}
+
+func TestYouTubeMetadata(t *testing.T) {
+	metadata, err := YouTubeMetadata(&youtube.Video{
+		Id: "m7Mzgmpr-Qc",
+		Snippet: &youtube.VideoSnippet{
+			Title:                "title",
+			ChannelId:            "UC",
+			ChannelTitle:         "channel",
+			LiveBroadcastContent: "upcoming",
+			Thumbnails:           &youtube.ThumbnailDetails{High: &youtube.Thumbnail{Url: "high"}, Default: &youtube.Thumbnail{Url: "default"}},
+		},
+		LiveStreamingDetails: &youtube.VideoLiveStreamingDetails{ScheduledStartTime: "2022-09-01T12:00:00Z"},
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, StateUpcoming, metadata.State)
+	assert.Equal(t, "high", metadata.Thumbnail)
+	assert.Equal(t, time.Date(2022, 9, 1, 12, 0, 0, 0, time.UTC), metadata.ScheduledStart)
+	assert.True(t, metadata.ActualStart.IsZero())
+
+	metadata, err = YouTubeMetadata(&youtube.Video{
+		Snippet: &youtube.VideoSnippet{LiveBroadcastContent: "none"},
+		LiveStreamingDetails: &youtube.VideoLiveStreamingDetails{
+			ActualStartTime: "2022-09-01T12:00:00Z",
+			ActualEndTime:   "2022-09-01T14:00:00Z",
+		},
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, StateEnded, metadata.State)
+	assert.Equal(t, 2*time.Hour, metadata.ActualEnd.Sub(metadata.ActualStart))
+
+	metadata, err = YouTubeMetadata(&youtube.Video{Snippet: &youtube.VideoSnippet{LiveBroadcastContent: "none"}})
+
+	assert.NoError(t, err)
+	assert.Equal(t, StateNone, metadata.State)
+}
+
+func TestIsNotStarted(t *testing.T) {
+	assert.True(t, IsNotStarted("ERROR: [youtube] m7Mzgmpr-Qc: This live event will begin in 3 hours."))
+	assert.False(t, IsNotStarted("ERROR: [youtube] m7Mzgmpr-Qc: Video unavailable"))
+}
@@ -0,0 +1,293 @@
+package source
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"pomu/hls"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrChannelOffline indicates that a channel has no livestream running
+var ErrChannelOffline = errors.New("channel is not live")
+
+const twitchApiUrl = "https://api.twitch.tv/helix"
+const twitchAuthUrl = "https://id.twitch.tv/oauth2/token"
+
+// twitchThumbnailSize is what the {width}x{height} placeholder of stream thumbnails is replaced with
+const twitchThumbnailSize = "1280x720"
+
+var twitchLoginRegex = regexp.MustCompile(`^[a-z0-9_]{1,25}$`)
+
+// twitchReservedPaths are paths on twitch.tv which are not channels
+var twitchReservedPaths = []string{"directory", "downloads", "jobs", "p", "search", "settings", "turbo", "videos"}
+
+// Twitch archives livestreams using the Twitch Helix API and yt-dlp.
+// Its ids are namespaced as twitch_<channel login>_<stream id>, as a channel is only one url for all its livestreams.
+// Twitch does not know about streams ahead of time, only channels which are currently live can be submitted.
+type Twitch struct {
+	ClientId     string
+	ClientSecret string
+
+	mutex       sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+func (t *Twitch) Name() string {
+	return "twitch"
+}
+
+func (t *Twitch) Matches(u *url.URL) bool {
+	host := strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(u.Host), "www."), "m.")
+
+	return host == "twitch.tv"
+}
+
+// ParseChannel returns the login of the channel at `u`
+func (t *Twitch) ParseChannel(u *url.URL) (string, error) {
+	login, _, _ := strings.Cut(strings.TrimPrefix(u.Path, "/"), "/")
+	login = strings.ToLower(login)
+
+	if !twitchLoginRegex.MatchString(login) {
+		return "", fmt.Errorf("%s is not a twitch channel url", u)
+	}
+
+	for _, reserved := range twitchReservedPaths {
+		if login == reserved {
+			return "", fmt.Errorf("%s is not a twitch channel url", u)
+		}
+	}
+
+	return login, nil
+}
+
+// ParseId returns the id of the livestream the channel at `u` is currently running. Urls returned by Url already
+// contain the stream and are parsed without asking Twitch.
+func (t *Twitch) ParseId(u *url.URL) (string, error) {
+	login, err := t.ParseChannel(u)
+	if err != nil {
+		return "", err
+	}
+
+	if stream := u.Query().Get("stream"); len(stream) > 0 {
+		return Namespace(t, login+"_"+stream), nil
+	}
+
+	live, err := t.Live(context.Background(), login)
+	if err != nil {
+		return "", err
+	}
+
+	if live == nil {
+		return "", fmt.Errorf("%s: %w", login, ErrChannelOffline)
+	}
+
+	return live.Id, nil
+}
+
+// splitTwitchId returns the channel login and stream id of `id`
+func splitTwitchId(id string) (string, string, error) {
+	// logins may contain underscores, stream ids do not
+	separator := strings.LastIndex(id, "_")
+
+	if !strings.HasPrefix(id, "twitch_") || separator <= len("twitch_") || separator == len(id)-1 {
+		return "", "", fmt.Errorf("%s is not a twitch id", id)
+	}
+
+	return id[len("twitch_"):separator], id[separator+1:], nil
+}
+
+func (t *Twitch) Url(id string) string {
+	login, stream, err := splitTwitchId(id)
+	if err != nil {
+		return ""
+	}
+
+	return fmt.Sprintf("https://www.twitch.tv/%s?stream=%s", login, stream)
+}
+
+// Metadata returns the metadata of the livestream `id`. Twitch only knows about the livestream while it is running,
+// afterwards it is reported as ended without any further details.
+func (t *Twitch) Metadata(ctx context.Context, id string) (*Metadata, error) {
+	login, _, err := splitTwitchId(id)
+	if err != nil {
+		return nil, err
+	}
+
+	live, err := t.Live(ctx, login)
+	if err != nil {
+		return nil, err
+	}
+
+	if live == nil || live.Id != id {
+		return &Metadata{Id: id, ChannelId: login, ChannelName: login, State: StateEnded}, nil
+	}
+
+	return live, nil
+}
+
+// Playlist records the channel, which is what yt-dlp understands. Format is either empty for the best quality or the
+// maximum height to record as listed by yt-dlp, as Twitch formats are named after their quality and not numbered.
+func (t *Twitch) Playlist(rawUrl string, format string, master bool) hls.RemotePlaylist {
+	channelUrl := rawUrl
+
+	if u, err := url.Parse(rawUrl); err == nil {
+		u.RawQuery = ""
+		channelUrl = u.String()
+	}
+
+	return &YtdlpPlaylist{Url: channelUrl, Format: twitchFormat(format), Master: master}
+}
+
+// twitchFormat converts a quality code into a yt-dlp format selector
+func twitchFormat(format string) string {
+	if height, err := strconv.Atoi(format); err == nil && height > 0 {
+		return fmt.Sprintf("best[height<=%d]", height)
+	}
+
+	return "best"
+}
+
+type twitchStream struct {
+	Id           string `json:"id"`
+	UserLogin    string `json:"user_login"`
+	UserName     string `json:"user_name"`
+	GameName     string `json:"game_name"`
+	Type         string `json:"type"`
+	Title        string `json:"title"`
+	StartedAt    string `json:"started_at"`
+	ThumbnailUrl string `json:"thumbnail_url"`
+}
+
+// Live returns the livestream the channel `login` is currently running, or nil if it is offline
+func (t *Twitch) Live(ctx context.Context, login string) (*Metadata, error) {
+	var response struct {
+		Data []twitchStream `json:"data"`
+	}
+
+	if err := t.get(ctx, "/streams?user_login="+url.QueryEscape(login), &response); err != nil {
+		return nil, err
+	}
+
+	for _, stream := range response.Data {
+		if stream.Type != "live" {
+			continue
+		}
+
+		return twitchMetadata(stream)
+	}
+
+	return nil, nil
+}
+
+// twitchMetadata converts a stream returned by the Helix API
+func twitchMetadata(stream twitchStream) (*Metadata, error) {
+	started, err := time.Parse(time.RFC3339, stream.StartedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	login := strings.ToLower(stream.UserLogin)
+	thumbnail := strings.NewReplacer("{width}x{height}", twitchThumbnailSize).Replace(stream.ThumbnailUrl)
+
+	return &Metadata{
+		Id:          "twitch_" + login + "_" + stream.Id,
+		Title:       stream.Title,
+		ChannelId:   login,
+		ChannelName: stream.UserName,
+		Category:    stream.GameName,
+		Thumbnail:   thumbnail,
+		State:       StateLive,
+		ActualStart: started,
+	}, nil
+}
+
+// get requests `path` from the Helix API and decodes the response into `v`
+func (t *Twitch) get(ctx context.Context, path string, v any) error {
+	token, err := t.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, "GET", twitchApiUrl+path, nil)
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Client-Id", t.ClientId)
+	request.Header.Set("Authorization", "Bearer "+token)
+	request.Header.Set("User-Agent", "pomu.app")
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusUnauthorized {
+		// the token got revoked, get a new one next time
+		t.mutex.Lock()
+		t.token = ""
+		t.mutex.Unlock()
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("twitch api returned %s", response.Status)
+	}
+
+	return json.NewDecoder(response.Body).Decode(v)
+}
+
+// accessToken returns an app access token, requesting a new one if there is none or it is about to expire
+func (t *Twitch) accessToken(ctx context.Context) (string, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if len(t.token) > 0 && time.Until(t.tokenExpiry) > time.Minute {
+		return t.token, nil
+	}
+
+	query := url.Values{}
+	query.Set("client_id", t.ClientId)
+	query.Set("client_secret", t.ClientSecret)
+	query.Set("grant_type", "client_credentials")
+
+	request, err := http.NewRequestWithContext(ctx, "POST", twitchAuthUrl+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return "", err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to get twitch access token: %s", response.Status)
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+
+	if err := json.NewDecoder(response.Body).Decode(&token); err != nil {
+		return "", err
+	}
+
+	t.token = token.AccessToken
+	t.tokenExpiry = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+
+	return t.token, nil
+}
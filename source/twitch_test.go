@@ -0,0 +1,61 @@
+package source
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTwitchIds(t *testing.T) {
+	twitch := &Twitch{}
+
+	u, _ := url.Parse("https://www.twitch.tv/Some_Channel?stream=40123456789")
+	assert.True(t, twitch.Matches(u))
+
+	id, err := twitch.ParseId(u)
+	assert.NoError(t, err)
+	assert.Equal(t, "twitch_some_channel_40123456789", id)
+	assert.Equal(t, "https://www.twitch.tv/some_channel?stream=40123456789", twitch.Url(id))
+	assert.False(t, IsYouTube(id))
+
+	login, stream, err := splitTwitchId(id)
+	assert.NoError(t, err)
+	assert.Equal(t, "some_channel", login)
+	assert.Equal(t, "40123456789", stream)
+
+	_, _, err = splitTwitchId("twitch_some_channel_")
+	assert.Error(t, err)
+
+	u, _ = url.Parse("https://twitch.tv/directory/game/Minecraft")
+	_, err = twitch.ParseId(u)
+	assert.Error(t, err)
+
+	u, _ = url.Parse("https://youtu.be/m7Mzgmpr-Qc")
+	assert.False(t, twitch.Matches(u))
+}
+
+func TestTwitchMetadata(t *testing.T) {
+	metadata, err := twitchMetadata(twitchStream{
+		Id:           "40123456789",
+		UserLogin:    "Some_Channel",
+		UserName:     "Some Channel",
+		GameName:     "Just Chatting",
+		Type:         "live",
+		Title:        "title",
+		StartedAt:    "2022-09-01T12:00:00Z",
+		ThumbnailUrl: "https://static-cdn.jtvnw.net/previews-ttv/live_user_some_channel-{width}x{height}.jpg",
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "twitch_some_channel_40123456789", metadata.Id)
+	assert.Equal(t, StateLive, metadata.State)
+	assert.Equal(t, "Just Chatting", metadata.Category)
+	assert.Equal(t, "https://static-cdn.jtvnw.net/previews-ttv/live_user_some_channel-1280x720.jpg", metadata.Thumbnail)
+}
+
+func TestTwitchFormat(t *testing.T) {
+	assert.Equal(t, "best", twitchFormat("0"))
+	assert.Equal(t, "best", twitchFormat("-1"))
+	assert.Equal(t, "best[height<=720]", twitchFormat("720"))
+}
@@ -0,0 +1,166 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"pomu/hls"
+	"strings"
+	"time"
+
+	"google.golang.org/api/option"
+	"google.golang.org/api/youtube/v3"
+)
+
+// YouTube archives livestreams using the YouTube Data API and yt-dlp. Its ids are the plain YouTube video ids.
+type YouTube struct{}
+
+func (y *YouTube) Name() string {
+	return "youtube"
+}
+
+func (y *YouTube) Matches(u *url.URL) bool {
+	loweredHost := strings.ToLower(u.Host)
+
+	return strings.Contains(loweredHost, "youtube.com") || strings.Contains(loweredHost, "youtu.be")
+}
+
+func (y *YouTube) ParseId(u *url.URL) (string, error) {
+	removeWww := strings.TrimPrefix(u.Host, "www.")
+	removeM := strings.TrimPrefix(removeWww, "m.")
+
+	id := ""
+
+	switch removeM {
+	case "youtu.be":
+		// https://youtu.be/m7Mzgmpr-Qc
+		id = strings.TrimPrefix(u.Path, "/")
+	case "youtube.com":
+		if u.Path == "/watch" {
+			// https://youtube.com/watch?v=m7Mzgmpr-Qc
+			id = u.Query().Get("v")
+		} else if strings.HasPrefix(u.Path, "/live") {
+			// https://youtube.com/live/m7Mzgmpr-Qc
+			parts := strings.Split(u.Path[1:], "/")
+			id = parts[len(parts)-1]
+		}
+	}
+
+	if len(id) == 0 {
+		return "", fmt.Errorf("failed to parse video url %s into video id", u)
+	}
+
+	return id, nil
+}
+
+func (y *YouTube) Url(id string) string {
+	return fmt.Sprintf("https://youtu.be/%s", id)
+}
+
+func (y *YouTube) Metadata(ctx context.Context, id string) (*Metadata, error) {
+	service, err := youtube.NewService(ctx, option.WithAPIKey(os.Getenv("GOOGLE_API_KEY")))
+
+	if err != nil {
+		return nil, err
+	}
+
+	list, err := service.Videos.List([]string{"contentDetails", "liveStreamingDetails", "snippet"}).Id(id).Do()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if length := len(list.Items); length != 1 {
+		return nil, fmt.Errorf("%w: didn't get items, length was %d", ErrNotFound, length)
+	}
+
+	return YouTubeMetadata(list.Items[0])
+}
+
+func (y *YouTube) Playlist(url string, format string, master bool) hls.RemotePlaylist {
+	return &YtdlpPlaylist{Url: url, Format: format, Master: master}
+}
+
+// YouTubeMetadata converts a video returned by the YouTube Data API
+func YouTubeMetadata(video *youtube.Video) (*Metadata, error) {
+	if video.Snippet == nil {
+		return nil, errors.New("video has no snippet")
+	}
+
+	metadata := &Metadata{
+		Id:          video.Id,
+		Title:       video.Snippet.Title,
+		Description: video.Snippet.Description,
+		ChannelId:   video.Snippet.ChannelId,
+		ChannelName: video.Snippet.ChannelTitle,
+		Thumbnail:   YouTubeThumbnail(video.Snippet.Thumbnails),
+		State:       StateNone,
+	}
+
+	details := video.LiveStreamingDetails
+
+	if details == nil {
+		return metadata, nil
+	}
+
+	for _, timestamp := range []struct {
+		value  string
+		target *time.Time
+	}{
+		{details.ScheduledStartTime, &metadata.ScheduledStart},
+		{details.ActualStartTime, &metadata.ActualStart},
+		{details.ActualEndTime, &metadata.ActualEnd},
+	} {
+		if len(timestamp.value) == 0 {
+			continue
+		}
+
+		parsed, err := time.Parse(time.RFC3339, timestamp.value)
+		if err != nil {
+			return nil, err
+		}
+
+		*timestamp.target = parsed
+	}
+
+	switch video.Snippet.LiveBroadcastContent {
+	case "live":
+		metadata.State = StateLive
+	case "upcoming":
+		metadata.State = StateUpcoming
+	case "completed":
+		metadata.State = StateEnded
+	default:
+		// once the livestream is over YouTube reports it as a regular video
+		if !metadata.ActualEnd.IsZero() {
+			metadata.State = StateEnded
+		}
+	}
+
+	return metadata, nil
+}
+
+// YouTubeThumbnail returns the highest resolution thumbnail url which is available
+func YouTubeThumbnail(details *youtube.ThumbnailDetails) string {
+	if details == nil {
+		return ""
+	}
+
+	qualities := []*youtube.Thumbnail{
+		details.Maxres,
+		details.High,
+		details.Medium,
+		details.Standard,
+		details.Default,
+	}
+
+	for _, thumbnail := range qualities {
+		if thumbnail != nil {
+			return thumbnail.Url
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,93 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"pomu/hls"
+	"strings"
+
+	"github.com/getsentry/sentry-go"
+	log "github.com/sirupsen/logrus"
+)
+
+// notStartedMessages are printed by yt-dlp if the livestream exists but has not started yet
+var notStartedMessages = []string{
+	"This live event will begin in",
+	"Premieres in",
+	"Premiere will begin",
+}
+
+// IsNotStarted returns whether the yt-dlp `output` says that the livestream has not started yet
+func IsNotStarted(output string) bool {
+	for _, message := range notStartedMessages {
+		if strings.Contains(output, message) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// YtdlpPlaylist gets the playlist url of a livestream using yt-dlp
+type YtdlpPlaylist struct {
+	Url string
+	// Format is the yt-dlp format code to record
+	Format string
+	// Master requests the master playlist instead of the media playlist of Format
+	Master bool
+}
+
+func (p *YtdlpPlaylist) Get() (string, error) {
+	log.Println("Getting playlist url for", p.Url)
+
+	span := sentry.StartSpan(
+		context.Background(),
+		"youtube-dl get playlist",
+		sentry.TransactionName(
+			fmt.Sprintf("youtube-dl get playlist %s", p.Url)))
+	defer span.Finish()
+
+	output := new(strings.Builder)
+
+	urlFlags := []string{"-g"}
+
+	if p.Master {
+		urlFlags = []string{"--print", "manifest_url"}
+	}
+
+	args := append([]string{"--force-ipv4", "-f", p.Format}, urlFlags...)
+	cmd := exec.Command(os.Getenv("YT_DLP"), append(args, p.Url)...)
+	cmd.Stdout = output
+	cmd.Stderr = output
+
+	err := cmd.Run()
+
+	// NOTE(emily): If the livestream has not started yet, ytdl will return 1
+	// We want to check first whether the live event WILL begin, and return the correct
+	// error
+	if IsNotStarted(output.String()) {
+		return "", ErrNotStarted
+	}
+
+	if err != nil {
+		sentry.AddBreadcrumb(&sentry.Breadcrumb{Level: sentry.LevelDebug, Message: fmt.Sprintf("ffmpeg output was %s", output)})
+		sentry.CaptureException(err)
+		log.Printf("cannot run youtube-dl: %s (output was %s)\n", err, output)
+		return "", err
+	}
+
+	span.Finish()
+	stringOutput := strings.TrimSpace(output.String())
+
+	if !strings.HasSuffix(stringOutput, ".m3u8") {
+		log.Printf("Expected m3u8 output, received %s\n", stringOutput)
+		return "", errors.New("expected m3u8")
+	}
+
+	return stringOutput, nil
+}
+
+var _ hls.RemotePlaylist = (*YtdlpPlaylist)(nil)
@@ -20,6 +20,59 @@ export interface VideoInfo {
     downloadUrl: string,
     fileSizeBytes: string,
     length: string,
+    parts: number,
+    partUrls?: string[],
+    media?: VideoMedia,
+    suspect: boolean,
+    faststart: boolean,
+    hls: boolean,
+    subtitles?: "chat" | "captions" | null,
+    description?: string,
+    metadataRefreshedAt?: string,
+    availability?: "public" | "unlisted" | "private" | "deleted" | "members-only" | null,
+    availabilityChangedAt?: string,
+    availabilityCheckedAt?: string,
+    category?: string,
+}
+
+export interface VideoMedia {
+    videoCodec?: string,
+    audioCodec?: string,
+    width?: number,
+    height?: number,
+    fps?: number,
+    bitrate?: number,
+    audioChannels?: number,
+    length?: number,
+    verifiedAt?: string,
+}
+
+export interface RecordingProgress {
+    videoId: string,
+    part: number,
+    startedAt: string,
+    segmentsFetched: number,
+    segmentsFailed: number,
+    bytesUploaded: number,
+    ffmpegTime: number,
+    ffmpegBitrate: number,
+    ffmpegSpeed: number,
+    ffmpegDuplicateFrames: number,
+    ffmpegDroppedFrames: number,
+    lastPlaylistRefresh?: string,
+    stopping: boolean,
+}
+
+// twitchId matches the ids of twitch livestreams, twitch_<channel>_<stream id>
+const twitchId = /^twitch_(.+)_(\d+)$/;
+
+export function livestreamUrl(info: VideoInfo): string {
+    let twitch = info.id.match(twitchId);
+    return twitch ? `https://twitch.tv/${twitch[1]}` : `https://youtu.be/${info.id}`;
+}
+
+export function channelUrl(info: VideoInfo): string {
+    return twitchId.test(info.id) ? `https://twitch.tv/${info.channelId}` : `https://youtube.com/channel/${info.channelId}`;
 }
 
 export function humanizeFileSize(sizeBytes: number) {
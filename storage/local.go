@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"strings"
+)
+
+// LocalUrlPrefix is the path below which pomu serves files of the local backend
+const LocalUrlPrefix = "/files"
+
+// Local stores files on the local disk and is served by pomu itself
+type Local struct {
+	root    string
+	baseUrl string
+}
+
+// NewLocal creates a backend storing into the directory `root`. Files are expected to be served at `baseUrl`.
+func NewLocal(root string, baseUrl string) (*Local, error) {
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, err
+	}
+
+	return &Local{root: root, baseUrl: baseUrl}, nil
+}
+
+// resolve turns `path` into a path on disk which can never escape the root directory
+func (backend *Local) resolve(path string) string {
+	return filepath.Join(backend.root, filepath.FromSlash(pathpkg.Clean("/"+path)))
+}
+
+func (backend *Local) Upload(ctx context.Context, path string, reader io.Reader, _ string) error {
+	resolved := backend.resolve(path)
+
+	if err := os.MkdirAll(filepath.Dir(resolved), 0o755); err != nil {
+		return err
+	}
+
+	// NOTE: write directly into the final file, so that if pomu gets interrupted everything received so far is kept
+	file, err := os.Create(resolved)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(file, &contextReader{ctx, reader}); err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// contextReader stops reading once its context has been cancelled
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return r.reader.Read(p)
+}
+
+func (backend *Local) Download(path string) (io.ReadCloser, error) {
+	file, err := os.Open(backend.resolve(path))
+
+	if os.IsNotExist(err) {
+		return nil, ErrNotExist
+	}
+
+	return file, err
+}
+
+func (backend *Local) Delete(path string) error {
+	err := os.Remove(backend.resolve(path))
+
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+func (backend *Local) Stat(path string) (*FileInfo, error) {
+	info, err := os.Stat(backend.resolve(path))
+
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotExist
+		}
+
+		return nil, err
+	}
+
+	return &FileInfo{
+		Size:         info.Size(),
+		ContentType:  mime.TypeByExtension(filepath.Ext(path)),
+		LastModified: info.ModTime(),
+	}, nil
+}
+
+func (backend *Local) Copy(source string, destination string) error {
+	reader, err := backend.Download(source)
+
+	if err != nil {
+		return err
+	}
+
+	defer reader.Close()
+
+	// copy into a temporary file first so that `destination` is replaced atomically
+	temporary := destination + ".tmp"
+
+	if err := backend.Upload(context.Background(), temporary, reader, ""); err != nil {
+		return err
+	}
+
+	return os.Rename(backend.resolve(temporary), backend.resolve(destination))
+}
+
+// Path returns where the file at `path` is stored on disk
+func (backend *Local) Path(path string) string {
+	return backend.resolve(path)
+}
+
+func (backend *Local) Url(path string) (string, error) {
+	return fmt.Sprintf("%s/%s", backend.baseUrl, strings.TrimPrefix(path, "/")), nil
+}
+
+// ServeHTTP serves the stored files. It is expected to be mounted with the LocalUrlPrefix stripped.
+func (backend *Local) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// for legal reasons, we cannot embed the video directly
+	if strings.HasSuffix(r.URL.Path, ".mp4") {
+		w.Header().Set("Content-Disposition", "attachment")
+	}
+
+	http.ServeFile(w, r, backend.resolve(r.URL.Path))
+}
+
+var _ Backend = (*Local)(nil)
+var _ http.Handler = (*Local)(nil)
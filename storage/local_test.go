@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalBackend(t *testing.T) {
+	backend, err := NewLocal(t.TempDir(), "https://pomu.app/files")
+	assert.NoError(t, err)
+
+	assert.NoError(t, backend.Upload(context.Background(), "m7Mzgmpr-Qc.log", strings.NewReader("hello pomu"), "text/plain"))
+
+	info, err := backend.Stat("m7Mzgmpr-Qc.log")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len("hello pomu")), info.Size)
+
+	assert.NoError(t, Rename(backend, "m7Mzgmpr-Qc.log", "m7Mzgmpr-Qc.part1.log"))
+
+	_, err = backend.Stat("m7Mzgmpr-Qc.log")
+	assert.Equal(t, ErrNotExist, err)
+
+	reader, err := backend.Download("m7Mzgmpr-Qc.part1.log")
+	assert.NoError(t, err)
+	content, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	assert.NoError(t, reader.Close())
+	assert.Equal(t, "hello pomu", string(content))
+
+	url, err := backend.Url("m7Mzgmpr-Qc.part1.log")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://pomu.app/files/m7Mzgmpr-Qc.part1.log", url)
+
+	assert.NoError(t, backend.Delete("m7Mzgmpr-Qc.part1.log"))
+	assert.NoError(t, backend.Delete("m7Mzgmpr-Qc.part1.log"))
+}
+
+func TestLocalBackendStaysInRoot(t *testing.T) {
+	backend, err := NewLocal(t.TempDir(), "")
+	assert.NoError(t, err)
+
+	assert.Equal(t, backend.resolve("secret"), backend.resolve("../../secret"))
+}
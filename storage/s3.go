@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"pomu/s3"
+	"time"
+)
+
+// presignExpiry is how long urls to private buckets stay valid
+const presignExpiry = 6 * time.Hour
+
+// S3 stores files in a S3 compatible object store
+type S3 struct {
+	client      *s3.Client
+	downloadUrl string
+}
+
+// NewS3 creates a backend storing into `bucket`. If `downloadUrl` is empty, the bucket is assumed to be private and
+// presigned urls will be handed out instead.
+func NewS3(bucket string, downloadUrl string) (*S3, error) {
+	client, err := s3.New(bucket)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &S3{client: client, downloadUrl: downloadUrl}, nil
+}
+
+func (backend *S3) Upload(ctx context.Context, path string, reader io.Reader, contentType string) error {
+	return backend.client.Upload(ctx, path, reader, contentType)
+}
+
+func (backend *S3) Download(path string) (io.ReadCloser, error) {
+	reader, err := backend.client.Download(path)
+
+	if err == s3.ErrNotFound {
+		return nil, ErrNotExist
+	}
+
+	return reader, err
+}
+
+func (backend *S3) Delete(path string) error {
+	return backend.client.Delete(path)
+}
+
+func (backend *S3) Stat(path string) (*FileInfo, error) {
+	info, err := backend.client.Stat(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if info == nil {
+		return nil, ErrNotExist
+	}
+
+	return &FileInfo{
+		Size:         info.Size,
+		ContentType:  info.ContentType,
+		LastModified: info.LastModified,
+	}, nil
+}
+
+func (backend *S3) Copy(source string, destination string) error {
+	return backend.client.Copy(source, destination)
+}
+
+func (backend *S3) Url(path string) (string, error) {
+	if len(backend.downloadUrl) > 0 {
+		return fmt.Sprintf("%s/%s", backend.downloadUrl, path), nil
+	}
+
+	return backend.client.Presign(path, presignExpiry)
+}
+
+func (backend *S3) CompleteAbandonedUpload(path string) (bool, error) {
+	return backend.client.CompleteAbandonedUpload(path)
+}
+
+var _ Backend = (*S3)(nil)
+var _ Recoverer = (*S3)(nil)
@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrNotExist is returned if the requested file does not exist
+var ErrNotExist = errors.New("file does not exist")
+
+// FileInfo describes a stored file
+type FileInfo struct {
+	Size         int64
+	ContentType  string
+	LastModified time.Time
+}
+
+// Backend stores archived videos and everything that belongs to them
+type Backend interface {
+	// Upload stores everything read from `reader` at `path`, replacing an existing file.
+	// Cancelling `ctx` aborts the upload.
+	Upload(ctx context.Context, path string, reader io.Reader, contentType string) error
+	// Download opens the file at `path` for reading
+	Download(path string) (io.ReadCloser, error)
+	// Delete deletes the file at `path`
+	Delete(path string) error
+	// Stat returns information about the file at `path` or ErrNotExist
+	Stat(path string) (*FileInfo, error)
+	// Copy copies the file at `source` to `destination`, replacing `destination` if it already exists
+	Copy(source string, destination string) error
+	// Url returns a url at which clients can download the file at `path`
+	Url(path string) (string, error)
+}
+
+// Recoverer is implemented by backends which can leave partially uploaded files behind if pomu gets interrupted
+type Recoverer interface {
+	// CompleteAbandonedUpload makes a partially uploaded file at `path` available as a regular file.
+	// Returns whether there was such a file.
+	CompleteAbandonedUpload(path string) (bool, error)
+}
+
+// New creates the backend configured through the `STORAGE_BACKEND` environment variable
+func New() (Backend, error) {
+	switch strings.ToLower(os.Getenv("STORAGE_BACKEND")) {
+	case "", "s3":
+		return NewS3(os.Getenv("S3_BUCKET"), os.Getenv("S3_DOWNLOAD_URL"))
+	case "local":
+		root := os.Getenv("STORAGE_LOCAL_PATH")
+
+		if len(root) <= 0 {
+			root = "./data"
+		}
+
+		return NewLocal(root, os.Getenv("BASE_URL")+LocalUrlPrefix)
+	default:
+		return nil, fmt.Errorf("unknown storage backend \"%s\"", os.Getenv("STORAGE_BACKEND"))
+	}
+}
+
+// Rename moves `source` to `destination` if `source` exists
+func Rename(backend Backend, source string, destination string) error {
+	if _, err := backend.Stat(source); err != nil {
+		if err == ErrNotExist {
+			return nil
+		}
+
+		return err
+	}
+
+	if err := backend.Copy(source, destination); err != nil {
+		return err
+	}
+
+	return backend.Delete(source)
+}
@@ -3,8 +3,11 @@ package main
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"pomu/qualities"
+	"pomu/source"
 	"strings"
 	"time"
 
@@ -13,7 +16,6 @@ import (
 	"github.com/getsentry/sentry-go"
 	"github.com/lib/pq"
 	"golang.org/x/exp/slices"
-	"google.golang.org/api/youtube/v3"
 )
 
 type Video struct {
@@ -29,15 +31,99 @@ type Video struct {
 	FileSize    string    `json:"fileSizeBytes,omitempty"`
 	Length      string    `json:"length,omitempty"`
 	Downloads   int32     `json:"-"`
+	Parts       int32     `json:"parts"`
+	PartUrls    []string  `json:"partUrls,omitempty"` // Not actually part of the query
+	// Media is only set once the archive has been verified
+	Media *VideoMedia `json:"media,omitempty"`
+	// Suspect is set if the length of the archive differs badly from the length of the livestream
+	Suspect bool `json:"suspect"`
+	// Faststart is set once the archive has been remuxed into a regular mp4
+	Faststart bool `json:"faststart"`
+	// Hls is set once the archive can be streamed from /api/video/{id}/playlist.m3u8
+	Hls bool `json:"hls"`
+	// Subtitles is where the subtitles of the archive come from, either SubtitlesChat or SubtitlesCaptions
+	Subtitles *string `json:"subtitles"`
+	// Description is the description of the livestream, only known once metadata has been refreshed
+	Description *string `json:"description,omitempty"`
+	// MetadataRefreshedAt is when title, thumbnail and description have last been refreshed
+	MetadataRefreshedAt *time.Time `json:"metadataRefreshedAt,omitempty"`
+	// Availability is whether the livestream is still available on YouTube, see package availability.
+	// Only set once it has been checked.
+	Availability *string `json:"availability"`
+	// AvailabilityChangedAt is when pomu noticed that the availability changed
+	AvailabilityChangedAt *time.Time `json:"availabilityChangedAt,omitempty"`
+	AvailabilityCheckedAt *time.Time `json:"availabilityCheckedAt,omitempty"`
+	// Category is the game or topic the livestream was listed under, only set for sources which have categories
+	Category *string `json:"category,omitempty"`
+}
+
+// VideoMedia describes the stored archive as reported by ffprobe
+type VideoMedia struct {
+	VideoCodec    *string  `json:"videoCodec,omitempty"`
+	AudioCodec    *string  `json:"audioCodec,omitempty"`
+	Width         *int32   `json:"width,omitempty"`
+	Height        *int32   `json:"height,omitempty"`
+	Fps           *float64 `json:"fps,omitempty"`
+	Bitrate       *int64   `json:"bitrate,omitempty"`
+	AudioChannels *int32   `json:"audioChannels,omitempty"`
+	// Length is the length of the archive in seconds
+	Length     *float64   `json:"length,omitempty"`
+	VerifiedAt *time.Time `json:"verifiedAt,omitempty"`
+}
+
+// scanVideo scans a `select * from videos` row into `video`
+func scanVideo(row interface{ Scan(...any) error }, video *Video) error {
+	var media VideoMedia
+
+	err := row.Scan(
+		&video.Id,
+		pq.Array(&video.Submitters),
+		&video.Start,
+		&video.Finished,
+		&video.Title,
+		&video.ChannelName,
+		&video.ChannelId,
+		&video.Thumbnail,
+		&video.FileSize,
+		&video.Length,
+		&video.Downloads,
+		&video.Parts,
+		&media.VideoCodec,
+		&media.AudioCodec,
+		&media.Width,
+		&media.Height,
+		&media.Fps,
+		&media.Bitrate,
+		&media.AudioChannels,
+		&media.Length,
+		&video.Suspect,
+		&media.VerifiedAt,
+		&video.Faststart,
+		&video.Hls,
+		&video.Subtitles,
+		&video.Description,
+		&video.MetadataRefreshedAt,
+		&video.Availability,
+		&video.AvailabilityChangedAt,
+		&video.AvailabilityCheckedAt,
+		&video.Category)
+
+	if err == nil && media.VerifiedAt != nil {
+		video.Media = &media
+	}
+
+	return err
 }
 
 type VideoRequest struct {
 	VideoUrl string `json:"videoUrl"`
 	Quality  int32  `json:"quality"`
+	// Concurrency is the amount of segments fetched at the same time, 0 uses the instance default
+	Concurrency int32 `json:"concurrency,omitempty"`
 }
 
 func (r *VideoRequest) Id() (string, error) {
-	return qualities.ParseVideoID(r.VideoUrl), nil
+	return source.ParseId(r.VideoUrl)
 }
 
 func (app *Application) SubmitVideo(w http.ResponseWriter, r *http.Request) {
@@ -55,7 +141,25 @@ func (app *Application) SubmitVideo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	videoId := qualities.ParseVideoID(request.VideoUrl)
+	if limit := maxSegmentConcurrency(); request.Concurrency < 0 || int(request.Concurrency) > limit {
+		http.Error(w, fmt.Sprintf("concurrency has to be between 0 and %d", limit), http.StatusBadRequest)
+		return
+	}
+
+	videoId, err := request.Id()
+
+	if errors.Is(err, source.ErrChannelOffline) {
+		http.Error(w, "channel is not live, only running livestreams can be archived from twitch", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, "unsupported video url", http.StatusBadRequest)
+		return
+	}
+
+	// the url might only point to a channel, from now on it has to point to this livestream
+	if provider, err := source.ForId(videoId); err == nil {
+		request.VideoUrl = provider.Url(videoId)
+	}
 
 	videoMetadata, err := GetVideoMetadata(videoId)
 
@@ -76,7 +180,7 @@ func (app *Application) SubmitVideo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	valid, err := CheckChannelAgainstHolodex(videoMetadata.Snippet.ChannelId)
+	valid, err := CheckChannelAllowed(sourceOf(videoId), videoMetadata.ChannelId)
 
 	if err != nil {
 		sentry.CaptureException(err)
@@ -85,7 +189,7 @@ func (app *Application) SubmitVideo(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if !valid {
-		http.Error(w, "only livestreams by holodex listed vtubers are allowed", http.StatusBadRequest)
+		http.Error(w, "only livestreams by holodex listed vtubers (or watched twitch channels) are allowed", http.StatusBadRequest)
 		return
 	}
 
@@ -108,18 +212,7 @@ func (app *Application) SubmitVideo(w http.ResponseWriter, r *http.Request) {
 	defer tx.Rollback()
 
 	var video Video
-	err = tx.QueryRow("select * from videos where id = $1 limit 1", videoId).Scan(
-		&video.Id,
-		pq.Array(&video.Submitters),
-		&video.Start,
-		&video.Finished,
-		&video.Title,
-		&video.ChannelName,
-		&video.ChannelId,
-		&video.Thumbnail,
-		&video.FileSize,
-		&video.Length,
-		&video.Downloads)
+	err = scanVideo(tx.QueryRow("select * from videos where id = $1 limit 1", videoId), &video)
 
 	var reschedule bool
 
@@ -130,14 +223,14 @@ func (app *Application) SubmitVideo(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		thumbnailUrl, err := SaveThumbnail(videoId, FindSuitableThumbnail(videoMetadata.Snippet.Thumbnails))
+		thumbnailUrl, err := app.SaveThumbnail(videoId, videoMetadata.Thumbnail)
 
 		if err != nil {
 			http.Error(w, "Failed to save thumbnail for video "+videoId, http.StatusInternalServerError)
 			return
 		}
 
-		statement, err := tx.Prepare("insert into videos (id, submitters, start, title, channel_name, channel_id, thumbnail) values ($1, $2, $3, $4, $5, $6, $7) returning *")
+		statement, err := tx.Prepare("insert into videos (id, submitters, start, title, channel_name, channel_id, thumbnail, category) values ($1, $2, $3, $4, $5, $6, $7, $8) returning *")
 
 		if err != nil {
 			sentry.CaptureException(err)
@@ -148,10 +241,11 @@ func (app *Application) SubmitVideo(w http.ResponseWriter, r *http.Request) {
 		row := statement.QueryRow(videoId,
 			pq.Array([]string{user.Provider + "/" + user.Id}),
 			startTime,
-			videoMetadata.Snippet.Title,
-			videoMetadata.Snippet.ChannelTitle,
-			videoMetadata.Snippet.ChannelId,
-			thumbnailUrl)
+			videoMetadata.Title,
+			videoMetadata.ChannelName,
+			videoMetadata.ChannelId,
+			thumbnailUrl,
+			nullIfEmpty(videoMetadata.Category))
 
 		if err := row.Err(); err != nil {
 			sentry.CaptureException(err)
@@ -159,17 +253,7 @@ func (app *Application) SubmitVideo(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		if err = row.Scan(&video.Id,
-			pq.Array(&video.Submitters),
-			&video.Start,
-			&video.Finished,
-			&video.Title,
-			&video.ChannelName,
-			&video.ChannelId,
-			&video.Thumbnail,
-			&video.FileSize,
-			&video.Length,
-			&video.Downloads); err != nil {
+		if err = scanVideo(row, &video); err != nil {
 			sentry.CaptureException(err)
 			http.Error(w, "failed to create new video", http.StatusInternalServerError)
 			return
@@ -186,18 +270,7 @@ func (app *Application) SubmitVideo(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 
-			if err := statement.QueryRow(user.Provider+"/"+user.Id, startTime, video.Id).
-				Scan(&video.Id,
-					pq.Array(&video.Submitters),
-					&video.Start,
-					&video.Finished,
-					&video.Title,
-					&video.ChannelName,
-					&video.ChannelId,
-					&video.Thumbnail,
-					&video.FileSize,
-					&video.Length,
-					&video.Downloads); err != nil {
+			if err := scanVideo(statement.QueryRow(user.Provider+"/"+user.Id, startTime, video.Id), &video); err != nil {
 				sentry.CaptureException(err)
 				log.Println(err)
 				http.Error(w, "failed to update existing video", http.StatusInternalServerError)
@@ -231,20 +304,39 @@ func (app *Application) SubmitVideo(w http.ResponseWriter, r *http.Request) {
 	SerializeJson(w, video)
 }
 
-func GetVideoStartTime(videoMetadata *youtube.Video) (startTime time.Time, err error) {
+func GetVideoStartTime(videoMetadata *source.Metadata) (startTime time.Time, err error) {
 	if IsLivestreamStarted(videoMetadata) {
-		startTime, err = time.Parse(time.RFC3339, videoMetadata.LiveStreamingDetails.ActualStartTime)
+		startTime = videoMetadata.ActualStart
 	} else {
-		startTime, err = time.Parse(time.RFC3339, videoMetadata.LiveStreamingDetails.ScheduledStartTime)
+		startTime = videoMetadata.ScheduledStart
+	}
+
+	if startTime.IsZero() {
+		err = fmt.Errorf("livestream %s has no start time", videoMetadata.Id)
 	}
 	return
 }
 
 func (app *Application) scheduleVideo(
-	videoMetadata *youtube.Video,
+	videoMetadata *source.Metadata,
 	videoId string,
 	request VideoRequest) error {
 
+	scheduledAt := time.Now()
+
+	if !IsLivestreamStarted(videoMetadata) {
+		startTime, err := GetVideoStartTime(videoMetadata)
+		if err != nil {
+			return err
+		}
+		scheduledAt = startTime
+	}
+
+	// persist the job first so that it survives a restart even if gocron never gets to run it
+	if err := UpsertRecordingJob(app.db, videoId, request, scheduledAt); err != nil {
+		return err
+	}
+
 	if IsLivestreamStarted(videoMetadata) {
 		if _, err := Scheduler.SingletonMode().Every("10s").LimitRunsTo(1).Tag(videoId).StartImmediately().Do(StartRecording, app, request); err != nil {
 			sentry.CaptureException(err)
@@ -253,16 +345,12 @@ func (app *Application) scheduleVideo(
 
 		log.Printf("Livestream already started, starting recording immediatly")
 	} else {
-		startTime, err := GetVideoStartTime(videoMetadata)
-		if err != nil {
-			return err
-		}
-		if _, err := Scheduler.SingletonMode().Every("10s").LimitRunsTo(1).StartAt(startTime).Tag(videoId).Do(StartRecording, app, request); err != nil {
+		if _, err := Scheduler.SingletonMode().Every("10s").LimitRunsTo(1).StartAt(scheduledAt).Tag(videoId).Do(StartRecording, app, request); err != nil {
 			sentry.CaptureException(err)
 			return err
 		}
 
-		log.Printf("Livestream recording scheduled for %s", startTime.Format(time.RFC3339))
+		log.Printf("Livestream recording scheduled for %s", scheduledAt.Format(time.RFC3339))
 	}
 
 	return nil
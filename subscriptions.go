@@ -0,0 +1,590 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/getsentry/sentry-go"
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"net/url"
+	"os"
+	"pomu/feed"
+	"pomu/source"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// defaultSubscriptionLookahead is how far ahead livestreams of subscribed channels are queued unless
+// SUBSCRIPTIONS_LOOKAHEAD is set. Keeps free chat rooms, which are scheduled months ahead, out of the queue.
+const defaultSubscriptionLookahead = 7 * 24 * time.Hour
+
+// skippedVideoRetention is how long livestreams skipped by the title filters are remembered. Channel feeds only list
+// the latest videos, so older ids do not come up again.
+const skippedVideoRetention = 30 * 24 * time.Hour
+
+var youtubeChannelIdRegex = regexp.MustCompile(`^UC[0-9A-Za-z_-]{22}$`)
+
+// ChannelSubscription makes pomu archive every livestream of a channel
+type ChannelSubscription struct {
+	Id          int64  `json:"id"`
+	Source      string `json:"source"`
+	ChannelId   string `json:"channelId"`
+	ChannelName string `json:"channelName"`
+	// Quality is the quality livestreams are recorded in, 0 picks the best one
+	Quality int32 `json:"quality"`
+	// TitleInclude and TitleExclude are case-insensitive regular expressions the title has to match, or must not
+	// match respectively
+	TitleInclude *string    `json:"titleInclude"`
+	TitleExclude *string    `json:"titleExclude"`
+	CreatedBy    string     `json:"createdBy"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	LastPolledAt *time.Time `json:"lastPolledAt"`
+	// WebSubExpiresAt is when the hub stops pushing new videos of the channel, nil if not subscribed through WebSub
+	WebSubExpiresAt *time.Time `json:"websubExpiresAt"`
+
+	// titleInclude and titleExclude are compiled once the subscription is scanned, nil if there is no pattern
+	titleInclude *regexp.Regexp
+	titleExclude *regexp.Regexp
+}
+
+type subscriptionRequest struct {
+	// Source is the name of the source the channel is on, defaults to youtube
+	Source       string  `json:"source"`
+	ChannelId    string  `json:"channelId"`
+	Quality      int32   `json:"quality"`
+	TitleInclude *string `json:"titleInclude"`
+	TitleExclude *string `json:"titleExclude"`
+}
+
+// scanSubscription scans `row` into `subscription` and compiles its title patterns
+func scanSubscription(row interface{ Scan(...any) error }, subscription *ChannelSubscription) error {
+	err := row.Scan(
+		&subscription.Id,
+		&subscription.Source,
+		&subscription.ChannelId,
+		&subscription.ChannelName,
+		&subscription.Quality,
+		&subscription.TitleInclude,
+		&subscription.TitleExclude,
+		&subscription.CreatedBy,
+		&subscription.CreatedAt,
+		&subscription.LastPolledAt,
+		&subscription.WebSubExpiresAt)
+
+	if err != nil {
+		return err
+	}
+
+	// patterns are validated before they are stored, so this only fails if they were changed in the database
+	if subscription.titleInclude, err = compileTitlePattern(subscription.TitleInclude); err != nil {
+		return fmt.Errorf("invalid title include pattern of subscription %d: %w", subscription.Id, err)
+	}
+
+	if subscription.titleExclude, err = compileTitlePattern(subscription.TitleExclude); err != nil {
+		return fmt.Errorf("invalid title exclude pattern of subscription %d: %w", subscription.Id, err)
+	}
+
+	return nil
+}
+
+// compileTitlePattern compiles a title include or exclude pattern, returns nil if there is no pattern
+func compileTitlePattern(pattern *string) (*regexp.Regexp, error) {
+	if pattern == nil || len(*pattern) == 0 {
+		return nil, nil
+	}
+
+	return regexp.Compile("(?i)" + *pattern)
+}
+
+// matchesTitle returns whether livestreams titled `title` are archived
+func (subscription *ChannelSubscription) matchesTitle(title string) bool {
+	if subscription.titleInclude != nil && !subscription.titleInclude.MatchString(title) {
+		return false
+	}
+
+	return subscription.titleExclude == nil || !subscription.titleExclude.MatchString(title)
+}
+
+// isQueueable returns whether `metadata` is a livestream which is running or starts within `lookahead`
+func isQueueable(metadata *source.Metadata, lookahead time.Duration) bool {
+	if !IsLivestream(metadata) || IsLivestreamEnded(metadata) {
+		return false
+	}
+
+	if IsLivestreamStarted(metadata) {
+		return true
+	}
+
+	return !metadata.ScheduledStart.IsZero() && time.Until(metadata.ScheduledStart) <= lookahead
+}
+
+// unknownVideoIds returns every id of `videoIds` which is not in the videos table yet and has not been skipped by the
+// title filters of `subscription`
+func (app *Application) unknownVideoIds(subscription *ChannelSubscription, videoIds []string) ([]string, error) {
+	rows, err := app.db.Query(
+		"select id from videos where id = any($1) union select video_id from subscription_skipped_videos where subscription_id = $2 and video_id = any($1)",
+		pq.Array(videoIds), subscription.Id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	known := make(map[string]bool)
+
+	for rows.Next() {
+		var videoId string
+
+		if err := rows.Scan(&videoId); err != nil {
+			return nil, err
+		}
+
+		known[videoId] = true
+	}
+
+	var unknown []string
+
+	for _, videoId := range videoIds {
+		if !known[videoId] {
+			unknown = append(unknown, videoId)
+		}
+	}
+
+	return unknown, rows.Err()
+}
+
+// youtubeChannelLivestreams returns the new livestreams of a YouTube channel. Candidates come from the channel feed
+// and, if there is an api key, from holodex which also knows about livestreams which are scheduled further ahead.
+func (app *Application) youtubeChannelLivestreams(subscription *ChannelSubscription, lookahead time.Duration) ([]*source.Metadata, error) {
+	channelId := subscription.ChannelId
+	var candidates []string
+	seen := make(map[string]bool)
+
+	channelFeed, err := feed.Fetch(context.Background(), channelId)
+
+	if err != nil {
+		log.WithFields(log.Fields{"channel_id": channelId, "error": err}).Warn("failed to fetch channel feed")
+	} else {
+		for _, entry := range channelFeed.Entries {
+			if !seen[entry.VideoId] {
+				seen[entry.VideoId] = true
+				candidates = append(candidates, entry.VideoId)
+			}
+		}
+	}
+
+	if len(os.Getenv("HOLODEX_API_KEY")) > 0 {
+		streams, holodexErr := queryChannelStreams(channelId, lookahead)
+
+		if holodexErr != nil {
+			log.WithFields(log.Fields{"channel_id": channelId, "error": holodexErr}).Warn("failed to query holodex for channel streams")
+		} else {
+			for _, stream := range streams {
+				if len(stream.Id) > 0 && !seen[stream.Id] {
+					seen[stream.Id] = true
+					candidates = append(candidates, stream.Id)
+				}
+			}
+		}
+	}
+
+	if len(candidates) == 0 {
+		return nil, err
+	}
+
+	unknown, err := app.unknownVideoIds(subscription, candidates)
+	if err != nil {
+		return nil, err
+	}
+
+	var livestreams []*source.Metadata
+
+	for start := 0; start < len(unknown); start += metadataBatchSize {
+		end := start + metadataBatchSize
+
+		if end > len(unknown) {
+			end = len(unknown)
+		}
+
+		videos, err := GetVideosMetadata(unknown[start:end])
+		if err != nil {
+			return nil, err
+		}
+
+		for _, video := range videos {
+			metadata, err := source.YouTubeMetadata(video)
+
+			if err == nil && isQueueable(metadata, lookahead) {
+				livestreams = append(livestreams, metadata)
+			}
+		}
+	}
+
+	return livestreams, nil
+}
+
+// subscriptionLivestreams returns the livestreams of a subscribed channel which have not been queued yet
+func (app *Application) subscriptionLivestreams(subscription *ChannelSubscription, lookahead time.Duration) ([]*source.Metadata, error) {
+	provider, err := source.ForName(subscription.Source)
+	if err != nil {
+		return nil, err
+	}
+
+	switch provider := provider.(type) {
+	case *source.YouTube:
+		return app.youtubeChannelLivestreams(subscription, lookahead)
+	case *source.Twitch:
+		live, err := provider.Live(context.Background(), subscription.ChannelId)
+
+		if err != nil || live == nil {
+			return nil, err
+		}
+
+		unknown, err := app.unknownVideoIds(subscription, []string{live.Id})
+
+		if err != nil || len(unknown) == 0 {
+			return nil, err
+		}
+
+		return []*source.Metadata{live}, nil
+	}
+
+	return nil, fmt.Errorf("channels of %s cannot be subscribed to", subscription.Source)
+}
+
+// subscriptionLookahead returns how far ahead livestreams of subscribed channels are queued
+func subscriptionLookahead() time.Duration {
+	if configured, err := time.ParseDuration(os.Getenv("SUBSCRIPTIONS_LOOKAHEAD")); err == nil && configured > 0 {
+		return configured
+	}
+
+	return defaultSubscriptionLookahead
+}
+
+// queueSubscriptionLivestream queues `livestream` of a subscribed channel if its title matches the subscription.
+// Livestreams which do not match are remembered, so that they are not looked up again.
+func (app *Application) queueSubscriptionLivestream(subscription *ChannelSubscription, livestream *source.Metadata) {
+	provider, err := source.ForName(subscription.Source)
+	if err != nil {
+		return
+	}
+
+	if !subscription.matchesTitle(livestream.Title) {
+		log.WithFields(log.Fields{"video_id": livestream.Id, "title": livestream.Title}).Info("skipping livestream of subscribed channel as its title does not match")
+
+		if _, err := app.db.Exec(
+			"insert into subscription_skipped_videos (subscription_id, video_id) values ($1, $2) on conflict do nothing",
+			subscription.Id, livestream.Id); err != nil {
+			sentry.CaptureException(err)
+		}
+
+		return
+	}
+
+	video, err := app.queueLivestream(livestream.Id, subscription.CreatedBy, VideoRequest{
+		VideoUrl: provider.Url(livestream.Id),
+		Quality:  subscription.Quality,
+	})
+
+	if err != nil {
+		log.WithFields(log.Fields{"video_id": livestream.Id, "error": err}).Error("failed to schedule livestream of subscribed channel")
+		return
+	}
+
+	if video != nil {
+		log.WithFields(log.Fields{
+			"video_id":   video.Id,
+			"title":      video.Title,
+			"channel_id": subscription.ChannelId,
+			"start":      video.Start.Format(time.RFC1123),
+		}).Info("automatically scheduled livestream of subscribed channel")
+	}
+}
+
+// PollSubscriptions queues every new livestream of every subscribed channel
+func PollSubscriptions(app *Application) {
+	lookahead := subscriptionLookahead()
+	subscriptions, err := app.querySubscriptions()
+
+	if err != nil {
+		sentry.CaptureException(err)
+		log.WithFields(log.Fields{"error": err}).Error("failed to query channel subscriptions")
+		return
+	}
+
+	if _, err := app.db.Exec("delete from subscription_skipped_videos where skipped_at < $1", time.Now().Add(-skippedVideoRetention)); err != nil {
+		sentry.CaptureException(err)
+	}
+
+	for _, subscription := range subscriptions {
+		livestreams, err := app.subscriptionLivestreams(&subscription, lookahead)
+
+		if err != nil {
+			log.WithFields(log.Fields{
+				"source":     subscription.Source,
+				"channel_id": subscription.ChannelId,
+				"error":      err,
+			}).Warn("failed to poll subscribed channel")
+			continue
+		}
+
+		for _, livestream := range livestreams {
+			app.queueSubscriptionLivestream(&subscription, livestream)
+		}
+
+		if _, err := app.db.Exec("update channel_subscriptions set last_polled_at = now() where id = $1", subscription.Id); err != nil {
+			sentry.CaptureException(err)
+		}
+	}
+}
+
+func (app *Application) querySubscriptions() ([]ChannelSubscription, error) {
+	rows, err := app.db.Query("select * from channel_subscriptions order by id")
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	subscriptions := []ChannelSubscription{}
+
+	for rows.Next() {
+		var subscription ChannelSubscription
+
+		if err := scanSubscription(rows, &subscription); err != nil {
+			return nil, err
+		}
+
+		subscriptions = append(subscriptions, subscription)
+	}
+
+	return subscriptions, rows.Err()
+}
+
+// resolveSubscriptionChannel checks that the channel exists and returns its id as it is stored and its name
+func resolveSubscriptionChannel(sourceName string, channelId string) (string, string, error) {
+	provider, err := source.ForName(sourceName)
+	if err != nil {
+		return "", "", err
+	}
+
+	switch provider := provider.(type) {
+	case *source.YouTube:
+		if !youtubeChannelIdRegex.MatchString(channelId) {
+			return "", "", fmt.Errorf("%s is not a youtube channel id", channelId)
+		}
+
+		channelFeed, err := feed.Fetch(context.Background(), channelId)
+		if err != nil {
+			return "", "", err
+		}
+
+		return channelId, channelFeed.Title, nil
+	case *source.Twitch:
+		login, err := provider.ParseChannel(&url.URL{Scheme: "https", Host: "www.twitch.tv", Path: "/" + channelId})
+		if err != nil {
+			return "", "", err
+		}
+
+		return login, login, nil
+	}
+
+	return "", "", fmt.Errorf("channels of %s cannot be subscribed to", sourceName)
+}
+
+// resolveSubscriptionOwner returns the subscription in the url if the logged-in user created it. Otherwise an error
+// is written to `w`.
+func (app *Application) resolveSubscriptionOwner(w http.ResponseWriter, r *http.Request) (*ChannelSubscription, bool) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+
+	if err != nil {
+		http.Error(w, "invalid subscription id", http.StatusBadRequest)
+		return nil, false
+	}
+
+	user, err := app.ResolveUserFromRequest(r)
+
+	if user == nil || err != nil {
+		http.Error(w, "please login first", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	var subscription ChannelSubscription
+
+	if err := scanSubscription(app.db.QueryRow("select * from channel_subscriptions where id = $1", id), &subscription); err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "subscription not found", http.StatusNotFound)
+			return nil, false
+		}
+
+		sentry.CaptureException(err)
+		http.Error(w, "failed to query subscription", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if subscription.CreatedBy != user.Provider+"/"+user.Id {
+		http.Error(w, "only the user who subscribed can change the subscription", http.StatusForbidden)
+		return nil, false
+	}
+
+	return &subscription, true
+}
+
+// decodeSubscriptionRequest decodes and validates the request body, writing an error to `w` if it is invalid
+func decodeSubscriptionRequest(w http.ResponseWriter, r *http.Request) (*subscriptionRequest, bool) {
+	var request subscriptionRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "failed to decode json request body", http.StatusBadRequest)
+		return nil, false
+	}
+
+	for _, pattern := range []*string{request.TitleInclude, request.TitleExclude} {
+		if _, err := compileTitlePattern(pattern); err != nil {
+			http.Error(w, fmt.Sprintf("invalid title pattern: %s", err), http.StatusBadRequest)
+			return nil, false
+		}
+	}
+
+	if request.TitleInclude != nil {
+		request.TitleInclude = nullIfEmpty(*request.TitleInclude)
+	}
+
+	if request.TitleExclude != nil {
+		request.TitleExclude = nullIfEmpty(*request.TitleExclude)
+	}
+
+	return &request, true
+}
+
+func (app *Application) GetSubscriptions(w http.ResponseWriter, _ *http.Request) {
+	subscriptions, err := app.querySubscriptions()
+
+	if err != nil {
+		sentry.CaptureException(err)
+		http.Error(w, "failed to query subscriptions", http.StatusInternalServerError)
+		return
+	}
+
+	SerializeJson(w, subscriptions)
+}
+
+func (app *Application) CreateSubscription(w http.ResponseWriter, r *http.Request) {
+	user, err := app.ResolveUserFromRequest(r)
+
+	if user == nil || err != nil {
+		http.Error(w, "please login first", http.StatusUnauthorized)
+		return
+	}
+
+	request, ok := decodeSubscriptionRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if len(request.Source) == 0 {
+		request.Source = "youtube"
+	}
+
+	channelId, channelName, err := resolveSubscriptionChannel(request.Source, request.ChannelId)
+
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to find channel: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	valid, err := CheckChannelAllowed(request.Source, channelId)
+
+	if err != nil {
+		sentry.CaptureException(err)
+		http.Error(w, "failed to check channel against holodex", http.StatusInternalServerError)
+		return
+	}
+
+	if !valid {
+		http.Error(w, "only holodex listed vtubers (or watched twitch channels) can be subscribed to", http.StatusBadRequest)
+		return
+	}
+
+	var subscription ChannelSubscription
+
+	row := app.db.QueryRow(
+		"insert into channel_subscriptions (source, channel_id, channel_name, quality, title_include, title_exclude, created_by) values ($1, $2, $3, $4, $5, $6, $7) on conflict (source, channel_id) do nothing returning *",
+		request.Source, channelId, channelName, request.Quality, request.TitleInclude, request.TitleExclude, user.Provider+"/"+user.Id)
+
+	if err := scanSubscription(row, &subscription); err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "channel is already subscribed to", http.StatusConflict)
+			return
+		}
+
+		sentry.CaptureException(err)
+		http.Error(w, "failed to create subscription", http.StatusInternalServerError)
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"source":     subscription.Source,
+		"channel_id": subscription.ChannelId,
+		"created_by": subscription.CreatedBy,
+	}).Info("subscribed to channel")
+
+	go app.subscribeWebSub(&subscription)
+
+	SerializeJson(w, subscription)
+}
+
+func (app *Application) UpdateSubscription(w http.ResponseWriter, r *http.Request) {
+	subscription, ok := app.resolveSubscriptionOwner(w, r)
+	if !ok {
+		return
+	}
+
+	request, ok := decodeSubscriptionRequest(w, r)
+	if !ok {
+		return
+	}
+
+	row := app.db.QueryRow(
+		"update channel_subscriptions set quality = $1, title_include = $2, title_exclude = $3 where id = $4 returning *",
+		request.Quality, request.TitleInclude, request.TitleExclude, subscription.Id)
+
+	if err := scanSubscription(row, subscription); err != nil {
+		sentry.CaptureException(err)
+		http.Error(w, "failed to update subscription", http.StatusInternalServerError)
+		return
+	}
+
+	// livestreams skipped by the previous title filters might match the new ones
+	if _, err := app.db.Exec("delete from subscription_skipped_videos where subscription_id = $1", subscription.Id); err != nil {
+		sentry.CaptureException(err)
+	}
+
+	SerializeJson(w, subscription)
+}
+
+func (app *Application) DeleteSubscription(w http.ResponseWriter, r *http.Request) {
+	subscription, ok := app.resolveSubscriptionOwner(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := app.db.Exec("delete from channel_subscriptions where id = $1", subscription.Id); err != nil {
+		sentry.CaptureException(err)
+		http.Error(w, "failed to delete subscription", http.StatusInternalServerError)
+		return
+	}
+
+	log.WithFields(log.Fields{"source": subscription.Source, "channel_id": subscription.ChannelId}).Info("unsubscribed from channel")
+
+	go app.unsubscribeWebSub(subscription)
+
+	w.WriteHeader(http.StatusNoContent)
+}
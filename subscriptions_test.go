@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// subscriptionRow scans the title patterns of a subscription, every other column is left empty
+type subscriptionRow struct {
+	titleInclude *string
+	titleExclude *string
+}
+
+func (row subscriptionRow) Scan(dest ...any) error {
+	if len(dest) != 11 {
+		return errors.New("unexpected amount of columns")
+	}
+
+	*dest[5].(**string) = row.titleInclude
+	*dest[6].(**string) = row.titleExclude
+
+	return nil
+}
+
+func TestSubscriptionMatchesTitle(t *testing.T) {
+	include, exclude, invalid := "歌枠|karaoke", "unarchived", "("
+
+	var subscription ChannelSubscription
+
+	if assert.NoError(t, scanSubscription(subscriptionRow{&include, &exclude}, &subscription)) {
+		assert.True(t, subscription.matchesTitle("【歌枠】 singing"))
+		assert.True(t, subscription.matchesTitle("KARAOKE night"))
+		assert.False(t, subscription.matchesTitle("Karaoke (unarchived)"))
+		assert.False(t, subscription.matchesTitle("minecraft"))
+	}
+
+	var everything ChannelSubscription
+
+	if assert.NoError(t, scanSubscription(subscriptionRow{}, &everything)) {
+		assert.True(t, everything.matchesTitle("minecraft"))
+	}
+
+	assert.Error(t, scanSubscription(subscriptionRow{titleExclude: &invalid}, &ChannelSubscription{}))
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/getsentry/sentry-go"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"os"
+	"pomu/chat"
+	"pomu/storage"
+	"pomu/subtitles"
+	"pomu/video"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// SubtitlesChat are generated from the recorded live chat
+	SubtitlesChat = "chat"
+	// SubtitlesCaptions have been uploaded by a submitter
+	SubtitlesCaptions = "captions"
+)
+
+// chatCueDuration is how long a chat message is shown in the WebVTT subtitles
+const chatCueDuration = 5 * time.Second
+
+// maxCaptionsSize is the largest captions file which can be uploaded
+const maxCaptionsSize = 10 * 1024 * 1024
+
+// generateChatSubtitles turns the recorded chat of every part into WebVTT subtitles (`<id>.vtt`) and scrolling
+// danmaku subtitles (`<id>.ass`). Uploaded captions are never overwritten.
+func (app *Application) generateChatSubtitles(videoId string) error {
+	var parts int32
+	var source *string
+
+	if err := app.db.QueryRow("select parts, subtitles from videos where id = $1", videoId).Scan(&parts, &source); err != nil {
+		sentry.CaptureException(err)
+		return err
+	}
+
+	generated := false
+
+	for part := int32(1); part <= parts; part++ {
+		reader, err := app.storage.Download(VideoPartKey(videoId, part, parts, "chat.jsonl"))
+		if err != nil {
+			log.WithFields(log.Fields{"video_id": videoId, "part": part, "error": err}).Info("no chat recorded, skipping subtitles")
+			continue
+		}
+
+		messages, err := chat.ReadMessages(reader)
+		_ = reader.Close()
+
+		if err != nil {
+			return fmt.Errorf("failed to read chat of part %d: %w", part, err)
+		}
+
+		if len(messages) == 0 {
+			continue
+		}
+
+		ctx := context.Background()
+
+		if source == nil || *source == SubtitlesChat {
+			var vtt bytes.Buffer
+
+			if err := subtitles.WriteVtt(&vtt, subtitles.FromChat(messages, chatCueDuration)); err != nil {
+				return err
+			}
+
+			if err := app.storage.Upload(ctx, VideoPartKey(videoId, part, parts, "vtt"), &vtt, "text/vtt"); err != nil {
+				return err
+			}
+		}
+
+		var ass bytes.Buffer
+
+		if err := subtitles.WriteDanmaku(&ass, messages, subtitles.DefaultDanmakuOptions()); err != nil {
+			return err
+		}
+
+		if err := app.storage.Upload(ctx, VideoPartKey(videoId, part, parts, "ass"), &ass, "text/x-ssa"); err != nil {
+			return err
+		}
+
+		generated = true
+	}
+
+	if !generated {
+		return nil
+	}
+
+	return app.setSubtitles(videoId, SubtitlesChat, false)
+}
+
+// setSubtitles stores where the subtitles of `videoId` come from. Unless `replace` is set, existing subtitles are kept.
+func (app *Application) setSubtitles(videoId string, source string, replace bool) error {
+	tx, err := app.db.Begin()
+
+	if err != nil {
+		sentry.CaptureException(err)
+		return err
+	}
+
+	defer tx.Rollback()
+
+	query := "update videos set subtitles = coalesce(subtitles, $1) where id = $2 returning *"
+
+	if replace {
+		query = "update videos set subtitles = $1 where id = $2 returning *"
+	}
+
+	var archive Video
+
+	if err := scanVideo(tx.QueryRow(query, source, videoId), &archive); err != nil {
+		sentry.CaptureException(err)
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		sentry.CaptureException(err)
+		return err
+	}
+
+	go app.UpsertVideo(archive)
+	return nil
+}
+
+// muxSubtitles adds the WebVTT subtitles of every part to the stored mp4 as subtitle track
+func (app *Application) muxSubtitles(videoId string) error {
+	var source *string
+
+	if err := app.db.QueryRow("select subtitles from videos where id = $1", videoId).Scan(&source); err != nil {
+		sentry.CaptureException(err)
+		return err
+	}
+
+	if source == nil {
+		return nil
+	}
+
+	title := "Live chat"
+
+	if *source == SubtitlesCaptions {
+		title = "Captions"
+	}
+
+	muxed, err := app.remuxParts(videoId, func(ctx context.Context, part int32, parts int32, input string, output string) (bool, error) {
+		subtitlesKey := VideoPartKey(videoId, part, parts, "vtt")
+
+		// the part might not have any chat messages
+		if _, err := app.storage.Stat(subtitlesKey); err == storage.ErrNotExist {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+
+		subtitlesInput, err := app.storageInput(subtitlesKey)
+		if err != nil {
+			return false, err
+		}
+
+		return true, video.AddSubtitles(ctx, input, output, video.SubtitleTrack{Path: subtitlesInput, Title: title})
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if muxed > 0 {
+		log.WithFields(log.Fields{"video_id": videoId, "subtitles": *source, "parts": muxed}).Info("muxed subtitles into archive")
+	}
+
+	return nil
+}
+
+// UploadSubtitles stores WebVTT captions for a part of a finished archive. They replace subtitles generated from chat.
+func (app *Application) UploadSubtitles(w http.ResponseWriter, r *http.Request) {
+	videoId := mux.Vars(r)["id"]
+
+	if _, ok := app.resolveSubmitter(w, r, videoId, "only submitters of this video can upload captions"); !ok {
+		return
+	}
+
+	var finished bool
+	var parts int32
+
+	if err := app.db.QueryRow("select finished, parts from videos where id = $1", videoId).Scan(&finished, &parts); err != nil {
+		sentry.CaptureException(err)
+		http.Error(w, "failed to query video", http.StatusInternalServerError)
+		return
+	}
+
+	if !finished {
+		http.Error(w, "video has not finished recording yet", http.StatusConflict)
+		return
+	}
+
+	part := int32(1)
+
+	if partStr := r.URL.Query().Get("part"); len(partStr) > 0 {
+		parsedPart, err := strconv.ParseInt(partStr, 10, 32)
+
+		if err != nil || parsedPart < 1 || int32(parsedPart) > parts {
+			http.Error(w, fmt.Sprintf("part has to be between 1 and %d", parts), http.StatusBadRequest)
+			return
+		}
+
+		part = int32(parsedPart)
+	}
+
+	cues, err := subtitles.ParseVtt(http.MaxBytesReader(w, r.Body, maxCaptionsSize))
+
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid WebVTT: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	// NOTE: the captions are written again so that only plain cues end up in storage
+	var vtt bytes.Buffer
+
+	if err := subtitles.WriteVtt(&vtt, cues); err != nil {
+		http.Error(w, "failed to write captions", http.StatusInternalServerError)
+		return
+	}
+
+	if err := app.storage.Upload(r.Context(), VideoPartKey(videoId, part, parts, "vtt"), &vtt, "text/vtt"); err != nil {
+		sentry.CaptureException(err)
+		http.Error(w, "failed to upload captions", http.StatusInternalServerError)
+		return
+	}
+
+	if err := app.setSubtitles(videoId, SubtitlesCaptions, true); err != nil {
+		http.Error(w, "failed to update video", http.StatusInternalServerError)
+		return
+	}
+
+	log.WithFields(log.Fields{"video_id": videoId, "part": part, "cues": len(cues)}).Info("captions uploaded")
+
+	if strings.ToLower(os.Getenv("MUX_SUBTITLES")) == "true" {
+		go func() {
+			if err := app.muxSubtitles(videoId); err != nil {
+				sentry.CaptureException(err)
+				log.WithFields(log.Fields{"video_id": videoId, "error": err}).Error("failed to mux captions into archive")
+			}
+		}()
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,133 @@
+package subtitles
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"pomu/chat"
+	"strings"
+	"time"
+)
+
+// DanmakuOptions configure how chat messages scroll across the video
+type DanmakuOptions struct {
+	// Width and Height are the resolution the positions are relative to, players scale them to the actual video
+	Width  int
+	Height int
+	// FontSize is the height of a single line
+	FontSize int
+	// Duration is how long a message takes to scroll across the whole width
+	Duration time.Duration
+}
+
+// DefaultDanmakuOptions scroll messages across a 1080p video in 8 seconds
+func DefaultDanmakuOptions() DanmakuOptions {
+	return DanmakuOptions{
+		Width:    1920,
+		Height:   1080,
+		FontSize: 48,
+		Duration: 8 * time.Second,
+	}
+}
+
+// assTimestamp formats `duration` as h:mm:ss.cc
+func assTimestamp(duration time.Duration) string {
+	centiseconds := duration.Milliseconds() / 10
+
+	return fmt.Sprintf("%d:%02d:%02d.%02d",
+		centiseconds/360_000, centiseconds/6000%60, centiseconds/100%60, centiseconds%100)
+}
+
+// NOTE: ASS has no way of escaping override blocks, replace everything that would be interpreted
+var assEscaper = strings.NewReplacer("{", "｛", "}", "｝", "\\", "＼", "\n", " ")
+
+// textWidth estimates how wide `text` is rendered, wide characters take up the full font size
+func textWidth(text string, fontSize int) int {
+	width := 0.0
+
+	for _, character := range text {
+		if character >= 0x1100 {
+			width += 1
+		} else {
+			width += 0.6
+		}
+	}
+
+	return int(width * float64(fontSize))
+}
+
+// WriteDanmaku writes `messages` into `w` as ASS subtitles which scroll from right to left across the video.
+// Messages are spread across lanes so that they do not overlap while there is room for them.
+func WriteDanmaku(w io.Writer, messages []chat.Message, options DanmakuOptions) error {
+	writer := bufio.NewWriter(w)
+
+	_, err := fmt.Fprintf(writer, `[Script Info]
+ScriptType: v4.00+
+PlayResX: %d
+PlayResY: %d
+WrapStyle: 2
+ScaledBorderAndShadow: yes
+
+[V4+ Styles]
+Format: Name, Fontname, Fontsize, PrimaryColour, SecondaryColour, OutlineColour, BackColour, Bold, Italic, Underline, StrikeOut, ScaleX, ScaleY, Spacing, Angle, BorderStyle, Outline, Shadow, Alignment, MarginL, MarginR, MarginV, Encoding
+Style: Chat,Sans,%d,&H00FFFFFF,&H00FFFFFF,&H00000000,&H00000000,0,0,0,0,100,100,0,0,1,2,0,7,0,0,0,1
+Style: SuperChat,Sans,%d,&H0000D7FF,&H0000D7FF,&H00000000,&H00000000,1,0,0,0,100,100,0,0,1,2,0,7,0,0,0,1
+
+[Events]
+Format: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text
+`, options.Width, options.Height, options.FontSize, options.FontSize)
+
+	if err != nil {
+		return err
+	}
+
+	lineHeight := options.FontSize + options.FontSize/4
+	lanes := options.Height / lineHeight
+
+	if lanes < 1 {
+		lanes = 1
+	}
+
+	// freeAt is when the last message of a lane has fully entered the screen
+	freeAt := make([]time.Duration, lanes)
+
+	for _, message := range displayedMessages(messages) {
+		start := messageStart(message)
+		text := chatLine(message)
+		lane := 0
+
+		for i := range freeAt {
+			if freeAt[i] <= start {
+				lane = i
+				break
+			}
+
+			// every lane is taken, fall back to the one which becomes free first
+			if freeAt[i] < freeAt[lane] {
+				lane = i
+			}
+		}
+
+		width := textWidth(text, options.FontSize)
+		speed := float64(options.Width+width) / options.Duration.Seconds()
+		freeAt[lane] = start + time.Duration(float64(width)/speed*float64(time.Second))
+
+		style := "Chat"
+
+		if message.Type == chat.TypeSuperChat {
+			style = "SuperChat"
+		}
+
+		y := lane * lineHeight
+
+		_, err := fmt.Fprintf(writer, "Dialogue: 0,%s,%s,%s,,0,0,0,,{\\move(%d,%d,%d,%d)}%s\n",
+			assTimestamp(start), assTimestamp(start+options.Duration), style,
+			options.Width, y, -width, y, assEscaper.Replace(text))
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return writer.Flush()
+}
@@ -0,0 +1,218 @@
+package subtitles
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"pomu/chat"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cue is a piece of text displayed between Start and End
+type Cue struct {
+	Start time.Duration
+	End   time.Duration
+	Text  string
+}
+
+// ErrNotWebVtt is returned by ParseVtt if the input is not a WebVTT file
+var ErrNotWebVtt = errors.New("missing WEBVTT header")
+
+// chatLine formats a chat message as a single line of text
+func chatLine(message chat.Message) string {
+	text := strings.Join(strings.Fields(message.Message), " ")
+
+	if len(message.Amount) > 0 {
+		if len(text) == 0 {
+			return fmt.Sprintf("%s (%s)", message.Author, message.Amount)
+		}
+
+		return fmt.Sprintf("%s (%s): %s", message.Author, message.Amount, text)
+	}
+
+	return fmt.Sprintf("%s: %s", message.Author, text)
+}
+
+// FromChat turns every chat message into a cue shown for `display`. Messages sent before the recording started are
+// skipped.
+func FromChat(messages []chat.Message, display time.Duration) []Cue {
+	messages = displayedMessages(messages)
+	cues := make([]Cue, 0, len(messages))
+
+	for _, message := range messages {
+		start := messageStart(message)
+		cues = append(cues, Cue{Start: start, End: start + display, Text: chatLine(message)})
+	}
+
+	return cues
+}
+
+// displayedMessages returns the messages with something to display which have been sent after the recording started,
+// ordered by when they have been sent
+func displayedMessages(messages []chat.Message) []chat.Message {
+	displayed := make([]chat.Message, 0, len(messages))
+
+	for _, message := range messages {
+		if message.Offset < 0 || (len(message.Message) == 0 && len(message.Amount) == 0) {
+			continue
+		}
+
+		displayed = append(displayed, message)
+	}
+
+	sort.SliceStable(displayed, func(i, j int) bool {
+		return displayed[i].Offset < displayed[j].Offset
+	})
+
+	return displayed
+}
+
+func messageStart(message chat.Message) time.Duration {
+	return time.Duration(message.Offset * float64(time.Second))
+}
+
+// vttTimestamp formats `duration` as hh:mm:ss.ttt
+func vttTimestamp(duration time.Duration) string {
+	milliseconds := duration.Milliseconds()
+
+	return fmt.Sprintf("%02d:%02d:%02d.%03d",
+		milliseconds/3_600_000, milliseconds/60_000%60, milliseconds/1000%60, milliseconds%1000)
+}
+
+var vttEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// WriteVtt writes `cues` into `w` as WebVTT
+func WriteVtt(w io.Writer, cues []Cue) error {
+	writer := bufio.NewWriter(w)
+
+	if _, err := writer.WriteString("WEBVTT\n"); err != nil {
+		return err
+	}
+
+	for _, cue := range cues {
+		text := vttEscaper.Replace(strings.TrimSpace(cue.Text))
+
+		if _, err := fmt.Fprintf(writer, "\n%s --> %s\n%s\n", vttTimestamp(cue.Start), vttTimestamp(cue.End), text); err != nil {
+			return err
+		}
+	}
+
+	return writer.Flush()
+}
+
+// parseVttTimestamp parses either hh:mm:ss.ttt or mm:ss.ttt
+func parseVttTimestamp(timestamp string) (time.Duration, error) {
+	seconds, fraction, ok := strings.Cut(timestamp, ".")
+
+	if !ok || len(fraction) != 3 {
+		return 0, fmt.Errorf("invalid timestamp \"%s\"", timestamp)
+	}
+
+	components := strings.Split(seconds, ":")
+
+	if len(components) < 2 || len(components) > 3 {
+		return 0, fmt.Errorf("invalid timestamp \"%s\"", timestamp)
+	}
+
+	var duration time.Duration
+
+	for _, component := range append(components, fraction) {
+		if _, err := strconv.ParseUint(component, 10, 32); err != nil {
+			return 0, fmt.Errorf("invalid timestamp \"%s\"", timestamp)
+		}
+	}
+
+	for _, component := range components {
+		value, _ := strconv.ParseUint(component, 10, 32)
+		duration = duration*60 + time.Duration(value)*time.Second
+	}
+
+	milliseconds, _ := strconv.ParseUint(fraction, 10, 32)
+	return duration + time.Duration(milliseconds)*time.Millisecond, nil
+}
+
+// ParseVtt reads the cues of a WebVTT file. Comments, styles and regions are skipped, cue settings are dropped.
+func ParseVtt(r io.Reader) ([]Cue, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	if !scanner.Scan() || !strings.HasPrefix(strings.TrimPrefix(scanner.Text(), "\ufeff"), "WEBVTT") {
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+
+		return nil, ErrNotWebVtt
+	}
+
+	var cues []Cue
+	var block []string
+
+	flush := func() error {
+		defer func() { block = block[:0] }()
+
+		if len(block) == 0 || strings.HasPrefix(block[0], "NOTE") || block[0] == "STYLE" || block[0] == "REGION" {
+			return nil
+		}
+
+		// the cue identifier is optional
+		if !strings.Contains(block[0], "-->") {
+			block = block[1:]
+		}
+
+		if len(block) == 0 || !strings.Contains(block[0], "-->") {
+			return errors.New("cue without timing")
+		}
+
+		start, rest, _ := strings.Cut(block[0], "-->")
+		fields := strings.Fields(rest)
+
+		if len(fields) == 0 {
+			return fmt.Errorf("invalid cue timing \"%s\"", block[0])
+		}
+
+		startTime, err := parseVttTimestamp(strings.TrimSpace(start))
+		if err != nil {
+			return err
+		}
+
+		endTime, err := parseVttTimestamp(fields[0])
+		if err != nil {
+			return err
+		}
+
+		if endTime < startTime {
+			return fmt.Errorf("cue ends before it starts \"%s\"", block[0])
+		}
+
+		cues = append(cues, Cue{Start: startTime, End: endTime, Text: strings.Join(block[1:], "\n")})
+		return nil
+	}
+
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+
+		if len(strings.TrimSpace(line)) == 0 {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		block = append(block, line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := flush(); err != nil {
+		return nil, err
+	}
+
+	return cues, nil
+}
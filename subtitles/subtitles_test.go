@@ -0,0 +1,76 @@
+package subtitles
+
+import (
+	"bytes"
+	"pomu/chat"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVttRoundTrip(t *testing.T) {
+	cues := []Cue{
+		{Start: 1500 * time.Millisecond, End: 4 * time.Second, Text: "Pomu: <3 & hello"},
+		{Start: time.Hour + 2*time.Minute, End: time.Hour + 2*time.Minute + 5*time.Second, Text: "Rainbow: bye"},
+	}
+
+	var buffer bytes.Buffer
+	assert.NoError(t, WriteVtt(&buffer, cues))
+	assert.Contains(t, buffer.String(), "00:00:01.500 --> 00:00:04.000\nPomu: &lt;3 &amp; hello\n")
+
+	parsed, err := ParseVtt(&buffer)
+	assert.NoError(t, err)
+
+	if assert.Len(t, parsed, 2) {
+		assert.Equal(t, cues[1], parsed[1])
+		assert.Equal(t, cues[0].Start, parsed[0].Start)
+	}
+}
+
+func TestParseVtt(t *testing.T) {
+	input := "\ufeffWEBVTT - captions\r\n\r\nNOTE written by hand\r\n\r\nintro\r\n00:05.000 --> 00:07.250 align:start\r\nHello\r\nworld\r\n"
+
+	cues, err := ParseVtt(strings.NewReader(input))
+	assert.NoError(t, err)
+	assert.Equal(t, []Cue{{Start: 5 * time.Second, End: 7250 * time.Millisecond, Text: "Hello\nworld"}}, cues)
+
+	_, err = ParseVtt(strings.NewReader("1\n00:00:01,000 --> 00:00:02,000\nsrt\n"))
+	assert.ErrorIs(t, err, ErrNotWebVtt)
+
+	_, err = ParseVtt(strings.NewReader("WEBVTT\n\n00:00:02.000 --> 00:00:01.000\nbackwards\n"))
+	assert.Error(t, err)
+}
+
+func TestFromChat(t *testing.T) {
+	messages := []chat.Message{
+		{Offset: 10, Author: "Rainbow", Message: "second"},
+		{Offset: -5, Author: "Pomu", Message: "before the recording"},
+		{Offset: 2.5, Author: "Pomu", Message: "first\nline", Amount: "$5.00", Type: chat.TypeSuperChat},
+	}
+
+	cues := FromChat(messages, 5*time.Second)
+
+	assert.Equal(t, []Cue{
+		{Start: 2500 * time.Millisecond, End: 7500 * time.Millisecond, Text: "Pomu ($5.00): first line"},
+		{Start: 10 * time.Second, End: 15 * time.Second, Text: "Rainbow: second"},
+	}, cues)
+}
+
+func TestWriteDanmaku(t *testing.T) {
+	messages := []chat.Message{
+		{Offset: 1, Author: "Pomu", Message: "{\\b1}hello"},
+		{Offset: 1, Author: "Rainbow", Message: "same time"},
+	}
+
+	var buffer bytes.Buffer
+	assert.NoError(t, WriteDanmaku(&buffer, messages, DefaultDanmakuOptions()))
+
+	output := buffer.String()
+	assert.Contains(t, output, "PlayResX: 1920\n")
+	// messages sent at the same time end up in different lanes and cannot inject override tags
+	assert.Contains(t, output, "Dialogue: 0,0:00:01.00,0:00:09.00,Chat,,0,0,0,,{\\move(1920,0,")
+	assert.Contains(t, output, "Pomu: ｛＼b1｝hello\n")
+	assert.Contains(t, output, "{\\move(1920,60,")
+}
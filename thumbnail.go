@@ -1,42 +1,29 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"github.com/getsentry/sentry-go"
+	"github.com/gorilla/mux"
 	log "github.com/sirupsen/logrus"
-	"google.golang.org/api/youtube/v3"
 	"net/http"
-	"os"
-	"pomu/s3"
+	"pomu/source"
+	"pomu/storage"
 )
 
-// FindSuitableThumbnail returns the highest resolution thumbnail url which is available
-func FindSuitableThumbnail(details *youtube.ThumbnailDetails) string {
-	qualities := []*youtube.Thumbnail{
-		details.Maxres,
-		details.High,
-		details.Medium,
-		details.Standard,
-		details.Default,
-	}
-
-	for _, thumbnail := range qualities {
-		if thumbnail != nil {
-			return thumbnail.Url
-		}
-	}
-
-	return ""
+// ThumbnailKey returns the storage key of the thumbnail of `id`
+func ThumbnailKey(id string) string {
+	return fmt.Sprintf("%s.jpg", id)
 }
 
-// SaveThumbnail saves a thumbnail to S3
-func SaveThumbnail(id string, url string) (string, error) {
-	s3Client, err := s3.New(os.Getenv("S3_BUCKET"))
-
-	if err != nil {
-		log.Printf("[warn] failed to create s3 client in order to upload thumbnail: %s\n", err)
-		return url, err
-	}
+// ThumbnailUrl returns the url stored as thumbnail of `id`. It points to pomu instead of storage, as urls of private
+// buckets are presigned and expire.
+func ThumbnailUrl(id string) string {
+	return fmt.Sprintf("/api/video/%s/thumbnail", id)
+}
 
+// SaveThumbnail saves a thumbnail to storage and returns the url it is served at
+func (app *Application) SaveThumbnail(id string, url string) (string, error) {
 	response, err := http.Get(url)
 
 	if err != nil {
@@ -46,10 +33,53 @@ func SaveThumbnail(id string, url string) (string, error) {
 
 	defer response.Body.Close()
 
-	if err := s3Client.Upload(fmt.Sprintf("%s.jpg", id), response.Body, "image/jpeg"); err != nil {
-		log.Println("s3 thumbnail upload failed:", err)
+	if err := app.storage.Upload(context.Background(), ThumbnailKey(id), response.Body, "image/jpeg"); err != nil {
+		log.Println("storage thumbnail upload failed:", err)
 		return url, err
 	}
 
-	return fmt.Sprintf("%s/%s.jpg", os.Getenv("S3_DOWNLOAD_URL"), id), nil
+	return ThumbnailUrl(id), nil
+}
+
+// GetThumbnail redirects to the stored thumbnail of a video
+func (app *Application) GetThumbnail(w http.ResponseWriter, r *http.Request) {
+	videoId := mux.Vars(r)["id"]
+
+	var exists bool
+
+	if err := app.db.QueryRow("select exists(select 1 from videos where id = $1)", videoId).Scan(&exists); err != nil {
+		sentry.CaptureException(err)
+		http.Error(w, "failed to query video", http.StatusInternalServerError)
+		return
+	}
+
+	if !exists {
+		http.Error(w, "video not found", http.StatusNotFound)
+		return
+	}
+
+	if _, err := app.storage.Stat(ThumbnailKey(videoId)); err == storage.ErrNotExist {
+		// the upload failed when the video was submitted, fall back to the thumbnail of YouTube
+		if source.IsYouTube(videoId) {
+			http.Redirect(w, r, fmt.Sprintf("https://i.ytimg.com/vi/%s/hqdefault.jpg", videoId), http.StatusFound)
+			return
+		}
+
+		http.Error(w, "video has no thumbnail", http.StatusNotFound)
+		return
+	} else if err != nil {
+		sentry.CaptureException(err)
+		http.Error(w, "failed to query thumbnail", http.StatusInternalServerError)
+		return
+	}
+
+	url, err := app.storage.Url(ThumbnailKey(videoId))
+
+	if err != nil {
+		sentry.CaptureException(err)
+		http.Error(w, "failed to get thumbnail url", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, url, http.StatusFound)
 }
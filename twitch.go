@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"os"
+	"pomu/source"
+	"strings"
+
+	"github.com/getsentry/sentry-go"
+	log "github.com/sirupsen/logrus"
+)
+
+// twitchProvider returns the twitch source if TWITCH_CLIENT_ID and TWITCH_CLIENT_SECRET are set
+func twitchProvider() *source.Twitch {
+	clientId := os.Getenv("TWITCH_CLIENT_ID")
+	clientSecret := os.Getenv("TWITCH_CLIENT_SECRET")
+
+	if len(clientId) == 0 || len(clientSecret) == 0 {
+		return nil
+	}
+
+	return &source.Twitch{ClientId: clientId, ClientSecret: clientSecret}
+}
+
+// twitchChannels returns the lowercased logins in TWITCH_CHANNELS
+func twitchChannels() []string {
+	var channels []string
+
+	for _, channel := range strings.Split(os.Getenv("TWITCH_CHANNELS"), ",") {
+		if channel = strings.ToLower(strings.TrimSpace(channel)); len(channel) > 0 {
+			channels = append(channels, channel)
+		}
+	}
+
+	return channels
+}
+
+// WatchTwitchChannels queues the livestream of every channel in TWITCH_CHANNELS which went live since the last check
+func WatchTwitchChannels(app *Application, twitch *source.Twitch) {
+	for _, channel := range twitchChannels() {
+		live, err := twitch.Live(context.Background(), channel)
+
+		if err != nil {
+			sentry.CaptureException(err)
+			log.WithFields(log.Fields{"channel": channel, "error": err}).Warn("failed to check if twitch channel is live")
+			continue
+		}
+
+		if live == nil {
+			continue
+		}
+
+		video, err := app.queueLivestream(live.Id, "pomu.app", VideoRequest{
+			VideoUrl: twitch.Url(live.Id),
+			// Use 0 to auto-pick best quality
+			Quality: 0,
+		})
+
+		if err != nil {
+			log.WithFields(log.Fields{"video_id": live.Id, "error": err}).Error("failed to automatically schedule twitch livestream")
+			continue
+		}
+
+		if video != nil {
+			log.WithFields(log.Fields{
+				"video_id": video.Id,
+				"title":    video.Title,
+				"category": live.Category,
+			}).Info("automatically scheduled twitch livestream")
+		}
+	}
+}
@@ -28,3 +28,18 @@ func RandomString(length int) string {
 	rand.Read(b)
 	return fmt.Sprintf("%x", b)[:length]
 }
+
+// methodHandlers dispatches requests to the handler of their method. Paths serving multiple methods are wrapped by
+// the prometheus middleware once, as it can only register the collectors of a handler name once.
+type methodHandlers map[string]http.HandlerFunc
+
+func (handlers methodHandlers) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	handler, ok := handlers[r.Method]
+
+	if !ok {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	handler(w, r)
+}
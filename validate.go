@@ -3,9 +3,11 @@ package main
 import (
 	"fmt"
 	"github.com/getsentry/sentry-go"
+	"golang.org/x/exp/slices"
 	"net/http"
 	"os"
-	"pomu/qualities"
+	"pomu/source"
+	"strings"
 )
 
 func (app *Application) ValidateLivestream(w http.ResponseWriter, r *http.Request) {
@@ -16,9 +18,9 @@ func (app *Application) ValidateLivestream(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	id := qualities.ParseVideoID(url)
+	id, err := source.ParseId(url)
 
-	if id == url {
+	if err != nil {
 		http.Error(w, "failed to parse video id from url", http.StatusInternalServerError)
 		return
 	}
@@ -27,11 +29,11 @@ func (app *Application) ValidateLivestream(w http.ResponseWriter, r *http.Reques
 
 	if err != nil {
 		sentry.CaptureException(err)
-		http.Error(w, "failed to get video meta data from youtube api", http.StatusInternalServerError)
+		http.Error(w, "failed to get video meta data", http.StatusInternalServerError)
 		return
 	}
 
-	valid, err := CheckChannelAgainstHolodex(video.Snippet.ChannelId)
+	valid, err := CheckChannelAllowed(sourceOf(id), video.ChannelId)
 
 	if err != nil {
 		sentry.CaptureException(err)
@@ -47,6 +49,34 @@ func (app *Application) ValidateLivestream(w http.ResponseWriter, r *http.Reques
 	SerializeJson(w, response)
 }
 
+// sourceOf returns the name of the source of `id`
+func sourceOf(id string) string {
+	if source.IsYouTube(id) {
+		return "youtube"
+	}
+
+	if provider, err := source.ForId(id); err == nil {
+		return provider.Name()
+	}
+
+	return ""
+}
+
+// CheckChannelAllowed returns whether livestreams of the channel `channelId` on the source `sourceName` may be archived.
+// Holodex only lists YouTube channels, so with RESTRICT_VTUBER_SUBMISSIONS Twitch livestreams are limited to the
+// channels in TWITCH_CHANNELS.
+func CheckChannelAllowed(sourceName string, channelId string) (bool, error) {
+	if sourceName == "youtube" {
+		return CheckChannelAgainstHolodex(channelId)
+	}
+
+	if os.Getenv("RESTRICT_VTUBER_SUBMISSIONS") != "true" {
+		return true, nil
+	}
+
+	return sourceName == "twitch" && slices.Contains(twitchChannels(), strings.ToLower(channelId)), nil
+}
+
 func CheckChannelAgainstHolodex(channelId string) (bool, error) {
 	// if the submissions are not restricted to only vtubers, always return true as we don't need to check against holodex then
 	if os.Getenv("RESTRICT_VTUBER_SUBMISSIONS") != "true" {
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/getsentry/sentry-go"
+	log "github.com/sirupsen/logrus"
+	"math"
+	"pomu/storage"
+	"pomu/video"
+	"time"
+)
+
+// verifyTimeout is how long ffprobe may take for a single part
+const verifyTimeout = 10 * time.Minute
+
+// An archive is suspect if its length differs from the livestream by more than suspectRelativeDifference, but at least
+// by suspectMinimumDifference so that short livestreams are not flagged for a few missing seconds
+const suspectRelativeDifference = 0.05
+const suspectMinimumDifference = 1 * time.Minute
+
+// storageInput returns something ffprobe and ffmpeg can read the file at `key` from
+func (app *Application) storageInput(key string) (string, error) {
+	if local, ok := app.storage.(*storage.Local); ok {
+		return local.Path(key), nil
+	}
+
+	return app.storage.Url(key)
+}
+
+// livestreamLength returns the length of the livestream according to its provider, or `fallback` if it is not known
+func livestreamLength(videoId string, fallback time.Duration) time.Duration {
+	metadata, err := GetVideoMetadata(videoId)
+
+	if err != nil || metadata.ActualStart.IsZero() || metadata.ActualEnd.IsZero() {
+		return fallback
+	}
+
+	return metadata.ActualEnd.Sub(metadata.ActualStart)
+}
+
+// verifyVideo runs ffprobe against every stored part of `videoId`, stores what it found and marks the archive as
+// suspect if its length differs badly from the length of the livestream
+func (app *Application) verifyVideo(videoId string) error {
+	var parts int32
+	var recordedLength int64
+
+	if err := app.db.QueryRow("select parts, video_length from videos where id = $1", videoId).Scan(&parts, &recordedLength); err != nil {
+		sentry.CaptureException(err)
+		return err
+	}
+
+	var media *video.ProbeResult
+	var length time.Duration
+
+	for part := int32(1); part <= parts; part++ {
+		input, err := app.storageInput(VideoPartKey(videoId, part, parts, "mp4"))
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), verifyTimeout)
+		result, err := video.Probe(ctx, input)
+		cancel()
+
+		if err != nil {
+			return fmt.Errorf("failed to probe part %d: %w", part, err)
+		}
+
+		if media == nil {
+			media = result
+		}
+
+		length += result.Duration
+	}
+
+	expectedLength := livestreamLength(videoId, time.Duration(recordedLength)*time.Second)
+	tolerance := time.Duration(math.Max(float64(suspectMinimumDifference), suspectRelativeDifference*float64(expectedLength)))
+	suspect := math.Abs(float64(length-expectedLength)) > float64(tolerance)
+
+	fields := log.Fields{
+		"video_id":        videoId,
+		"length":          length,
+		"expected_length": expectedLength,
+		"video_codec":     media.VideoCodec,
+		"audio_codec":     media.AudioCodec,
+		"resolution":      fmt.Sprintf("%dx%d", media.Width, media.Height),
+	}
+
+	if suspect {
+		log.WithFields(fields).Warn("archive length differs from livestream length, marking as suspect")
+		sentry.CaptureMessage(fmt.Sprintf("archive %s is suspect: %s long instead of %s", videoId, length, expectedLength))
+	} else {
+		log.WithFields(fields).Info("archive verified")
+	}
+
+	tx, err := app.db.Begin()
+
+	if err != nil {
+		sentry.CaptureException(err)
+		return err
+	}
+
+	defer tx.Rollback()
+
+	var archive Video
+
+	row := tx.QueryRow(
+		`update videos set
+			video_codec = $1,
+			audio_codec = $2,
+			width = $3,
+			height = $4,
+			fps = $5,
+			bitrate = $6,
+			audio_channels = $7,
+			probed_length = $8,
+			suspect = $9,
+			verified_at = current_timestamp
+		where id = $10 returning *`,
+		nullIfEmpty(media.VideoCodec),
+		nullIfEmpty(media.AudioCodec),
+		media.Width,
+		media.Height,
+		media.Fps,
+		media.Bitrate,
+		media.AudioChannels,
+		length.Seconds(),
+		suspect,
+		videoId)
+
+	if err := scanVideo(row, &archive); err != nil {
+		sentry.CaptureException(err)
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		sentry.CaptureException(err)
+		return err
+	}
+
+	go app.UpsertVideo(archive)
+	return nil
+}
+
+// nullIfEmpty turns an empty string into a NULL value
+func nullIfEmpty(value string) *string {
+	if len(value) <= 0 {
+		return nil
+	}
+
+	return &value
+}
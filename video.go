@@ -8,38 +8,43 @@ import (
 	"github.com/gorilla/mux"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
-	"os/exec"
 	"pomu/hls"
 	"pomu/qualities"
-	"pomu/s3"
+	"pomu/source"
+	"pomu/storage"
 	"pomu/video"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/lib/pq"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/getsentry/sentry-go"
 )
 
-type ytdlRemotePlaylist struct {
+// requestPlaylist resolves the playlist of a VideoRequest through the provider the livestream belongs to
+type requestPlaylist struct {
 	request VideoRequest
+	// master requests the master playlist instead of the media playlist of the requested quality,
+	// leaving the variant selection to the hls client
+	master bool
 }
 
 // ErrorLivestreamNotStarted indicates that the livestream has not started
-var ErrorLivestreamNotStarted = errors.New("livestream has not started")
+var ErrorLivestreamNotStarted = source.ErrNotStarted
 
-func (p *ytdlRemotePlaylist) Get() (string, error) {
-	log.Println("Getting playlist url for", p.request.VideoUrl)
+func (p *requestPlaylist) Get() (string, error) {
+	u, err := url.Parse(p.request.VideoUrl)
+	if err != nil {
+		return "", err
+	}
 
-	span := sentry.StartSpan(
-		context.Background(),
-		"youtube-dl get playlist",
-		sentry.TransactionName(
-			fmt.Sprintf("youtube-dl get playlist %s", p.request.VideoUrl)))
-	defer span.Finish()
+	provider, err := source.ForUrl(u)
+	if err != nil {
+		return "", err
+	}
 
 	// Check that we are trying to record a valid quality
 	if p.request.Quality <= 0 {
@@ -57,47 +62,15 @@ func (p *ytdlRemotePlaylist) Get() (string, error) {
 		}
 	}
 
-	output := new(strings.Builder)
-
-	cmd := exec.Command(os.Getenv("YT_DLP"), "--force-ipv4", "-f", strconv.Itoa(int(p.request.Quality)), "-g", p.request.VideoUrl)
-	cmd.Stdout = output
-	cmd.Stderr = output
-
-	err := cmd.Run()
-
-	// NOTE(emily): If the livestream has not started yet, ytdl will return 1
-	// We want to check first whether the live event WILL begin, and return the correct
-	// error
-	if strings.Contains(output.String(), "This live event will begin in") ||
-		strings.Contains(output.String(), "Premieres in") ||
-		strings.Contains(output.String(), "Premiere will begin") {
-		return "", ErrorLivestreamNotStarted
-	}
-
-	if err != nil {
-		sentry.AddBreadcrumb(&sentry.Breadcrumb{Level: sentry.LevelDebug, Message: fmt.Sprintf("ffmpeg output was %s", output)})
-		sentry.CaptureException(err)
-		log.Printf("cannot run youtube-dl: %s (output was %s)\n", err, output)
-		return "", err
-	}
-
-	span.Finish()
-	stringOutput := strings.TrimSpace(output.String())
-
-	if !strings.HasSuffix(stringOutput, ".m3u8") {
-		log.Printf("Expected m3u8 output, received %s\n", stringOutput)
-		return "", errors.New("expected m3u8")
-	}
-
-	return stringOutput, nil
+	return provider.Playlist(p.request.VideoUrl, strconv.Itoa(int(p.request.Quality)), p.master).Get()
 }
 
-var _ hls.RemotePlaylist = (*ytdlRemotePlaylist)(nil)
+var _ hls.RemotePlaylist = (*requestPlaylist)(nil)
 
 var ffmpegLogs = make(map[string]*strings.Builder)
 
 func hasLivestreamStarted(request VideoRequest) (bool, error) {
-	_, err := (&ytdlRemotePlaylist{request}).Get()
+	_, err := (&requestPlaylist{request: request}).Get()
 	if err == ErrorLivestreamNotStarted {
 		return false, nil
 	} else if err != nil {
@@ -107,28 +80,7 @@ func hasLivestreamStarted(request VideoRequest) (bool, error) {
 	return true, nil
 }
 
-func videoLengthFromLog(id string) time.Duration {
-	// NOTE(emily): Here we can get the video length by looking at the ffmpeg log
-	logString := strings.TrimSpace(ffmpegLogs[id].String())
-	timeStart := strings.LastIndex(logString, "time=") + len("time=")
-	timeEnd := strings.Index(logString[timeStart:], " ")
-
-	timeStr := logString[timeStart : timeStart+timeEnd]
-	// replace punctuation with their respective time units
-	timeStr = strings.Replace(timeStr, ":", "h", 1)
-	timeStr = strings.Replace(timeStr, ":", "m", 1)
-	timeStr = strings.Replace(timeStr, ".", "s", 1)
-	timeStr = timeStr + "ms"
-
-	duration, err := time.ParseDuration(timeStr)
-	if err != nil {
-		log.Println("Failed to parse duration from ffmpeg log")
-	}
-
-	return duration
-}
-
-func (app *Application) recordFinished(db *sql.DB, id string, size int64) error {
+func (app *Application) recordFinished(db *sql.DB, id string, size int64, length time.Duration) error {
 	tx, err := db.Begin()
 
 	if err != nil {
@@ -138,8 +90,6 @@ func (app *Application) recordFinished(db *sql.DB, id string, size int64) error
 
 	defer tx.Rollback()
 
-	length := videoLengthFromLog(id)
-
 	log.WithFields(log.Fields{
 		"id":     id,
 		"size":   size,
@@ -148,7 +98,7 @@ func (app *Application) recordFinished(db *sql.DB, id string, size int64) error
 
 	var video Video
 
-	statement, err := tx.Prepare("update videos set finished = true, file_size = $1, video_length = $2 where id = $3 returning *")
+	statement, err := tx.Prepare("update videos set finished = true, file_size = file_size + $1, video_length = video_length + $2 where id = $3 returning *")
 
 	if err != nil {
 		log.WithFields(log.Fields{"error": err}).Error("failed to prepare statement")
@@ -162,17 +112,7 @@ func (app *Application) recordFinished(db *sql.DB, id string, size int64) error
 		return err
 	}
 
-	if err = row.Scan(&video.Id,
-		pq.Array(&video.Submitters),
-		&video.Start,
-		&video.Finished,
-		&video.Title,
-		&video.ChannelName,
-		&video.ChannelId,
-		&video.Thumbnail,
-		&video.FileSize,
-		&video.Length,
-		&video.Downloads); err != nil {
+	if err = scanVideo(row, &video); err != nil {
 		log.WithFields(log.Fields{"error": err}).Error("failed to serialize row into video")
 		return err
 	}
@@ -189,7 +129,7 @@ func (app *Application) recordFinished(db *sql.DB, id string, size int64) error
 func recordFailed(db *sql.DB, id string) error {
 	log.Println("Record for", id, "failed, deleting from database")
 
-	// TODO(emily): Probably want to make sure that s3 is cleaned up as well
+	// TODO(emily): Probably want to make sure that storage is cleaned up as well
 
 	tx, err := db.Begin()
 
@@ -212,10 +152,41 @@ func recordFailed(db *sql.DB, id string) error {
 	return nil
 }
 
-func record(request VideoRequest) (size int64, err error) {
+// defaultMaxSegmentConcurrency is the upper limit of concurrently fetched segments unless SEGMENT_CONCURRENCY_MAX is set
+const defaultMaxSegmentConcurrency = 8
+
+// maxSegmentConcurrency returns how many segments a single recording may fetch at the same time
+func maxSegmentConcurrency() int {
+	if configured, err := strconv.Atoi(os.Getenv("SEGMENT_CONCURRENCY_MAX")); err == nil && configured > 0 {
+		return configured
+	}
+
+	return defaultMaxSegmentConcurrency
+}
+
+// segmentConcurrency returns how many segments are fetched at the same time for a submission requesting `requested`.
+// Falls back to SEGMENT_CONCURRENCY and never exceeds maxSegmentConcurrency, as every fetch holds a segment in memory.
+func segmentConcurrency(requested int32) int {
+	concurrency := int(requested)
+
+	if concurrency <= 0 {
+		concurrency, _ = strconv.Atoi(os.Getenv("SEGMENT_CONCURRENCY"))
+	}
+
+	if limit := maxSegmentConcurrency(); concurrency > limit {
+		concurrency = limit
+	}
+
+	return concurrency
+}
+
+// record records `part` of the livestream. Recordings consisting of more than one part are the result of pomu being
+// interrupted during an earlier recording, see resumeInterruptedRecording.
+// Cancelling `ctx` aborts the recording entirely, use Recordings.Stop to finish it early instead.
+func (app *Application) record(ctx context.Context, request VideoRequest, part int32) (size int64, length time.Duration, err error) {
 	log.Println("Starting recording of ", request.VideoUrl)
 	span := sentry.StartSpan(
-		context.Background(),
+		ctx,
 		"record",
 		sentry.TransactionName(
 			fmt.Sprintf("record %s", request.VideoUrl)))
@@ -227,39 +198,87 @@ func record(request VideoRequest) (size int64, err error) {
 		sentry.CaptureException(err)
 		return
 	}
+	// captureCtx only stops getting new segments, so that everything captured until then is still muxed and uploaded
+	captureCtx, stopCapture := context.WithCancel(ctx)
+	defer stopCapture()
+
+	recording := app.recordings.add(id, part, stopCapture)
+	defer app.recordings.remove(id)
+
 	// Start getting segments
 	hlsClient := hls.New(id)
-	defer hlsClient.Stop()
+	hlsClient.OnRefresh = func(refreshedAt time.Time) {
+		recording.update(func(progress *RecordingProgress) {
+			progress.LastPlaylistRefresh = &refreshedAt
+		})
+	}
+
+	remotePlaylist := &requestPlaylist{request: request}
+
+	// NOTE: with a variant policy the hls client picks the variant from the master playlist itself
+	if policy := os.Getenv("HLS_VARIANT_POLICY"); len(policy) > 0 {
+		variantPolicy, err := hls.ParseVariantPolicy(policy)
+
+		if err != nil {
+			logVideo(request, err).Warn("Invalid HLS_VARIANT_POLICY, recording requested quality instead")
+		} else {
+			hlsClient.Policy = variantPolicy
+			remotePlaylist.master = true
+		}
+	}
+
+	// Some sources publish the audio as a separate rendition, which then has to be recorded alongside
+	var audioClient *hls.Client
+
+	if remotePlaylist.master {
+		audioPlaylist, err := hlsClient.AudioRendition(captureCtx, remotePlaylist)
+
+		if err != nil {
+			logVideo(request, err).Warn("Failed to check for separate audio rendition, assuming audio is muxed into video")
+		} else if audioPlaylist != nil {
+			audioClient = hls.New(id)
+
+			go func() {
+				audioPlaylistSpan := span.StartChild("hls-client audio playlist")
+				defer audioPlaylistSpan.Finish()
+				logVideo(request, nil).Info("Starting HLS Client for audio rendition")
+				defer logVideo(request, nil).Info("HLS Client for audio rendition stopped")
+				audioClient.Playlist(captureCtx, audioPlaylist)
+			}()
+		}
+	}
+
 	go func() {
 		hlsClientPlaylistSpan := span.StartChild("hls-client playlist")
 		defer hlsClientPlaylistSpan.Finish()
 		logVideo(request, nil).Info("Starting HLS Client")
 		defer logVideo(request, nil).Info("HLS Client stopped")
-		hlsClient.Playlist(&ytdlRemotePlaylist{request})
+		hlsClient.Playlist(captureCtx, remotePlaylist)
 	}()
 
+	chatDone := app.recordChat(ctx, captureCtx, id, part, time.Now())
+	go app.watchTitle(captureCtx, id, part, time.Now())
+
 	// Start the video muxer
-	muxer := &video.Muxer{}
+	muxer := &video.Muxer{SeparateAudio: audioClient != nil}
 	ffmpegLogs[id] = new(strings.Builder)
 	muxer.Stderr = ffmpegLogs[id]
-	err = muxer.Start()
+	muxer.OnProgress = recording.updateMuxer
+	err = muxer.Start(ctx)
 	if err != nil {
 		log.Println(id, "Failed to start ffmpeg:", err)
 		sentry.CaptureException(err)
-		return 0, errors.New("failed to start ffmpeg")
+		return 0, 0, errors.New("failed to start ffmpeg")
 	}
 	finished := make(chan struct{})
 	defer close(finished)
 
-	s3, err := s3.New(os.Getenv("S3_BUCKET"))
-	if err != nil {
-		sentry.CaptureException(err)
-		return 0, errors.New("failed to contact s3 bucket")
-	}
-
 	sizeWritten := make(chan int64)
 	defer close(sizeWritten)
 
+	// uploadErr is set before `finished` is signalled
+	var uploadErr error
+
 	go func() {
 		muxerSpan := span.StartChild("muxer-uploader loop")
 		defer muxerSpan.Finish()
@@ -267,47 +286,129 @@ func record(request VideoRequest) (size int64, err error) {
 
 		go func() {
 			defer func() { finished <- struct{}{} }()
-			err := s3.Upload(fmt.Sprintf("%s.mp4", id), reader, "video/mp4")
+			err := app.storage.Upload(ctx, VideoPartKey(id, part, part, "mp4"), reader, "video/mp4")
 			if err != nil {
-				log.Println(id, "s3.Upload2():", err)
+				log.Println(id, "storage.Upload():", err)
 				sentry.CaptureException(err)
+				uploadErr = err
+
+				// nothing reads the pipe anymore, fail the copy below and stop capturing as nothing can be stored
+				_ = reader.CloseWithError(err)
+				stopCapture()
 				return
 			}
 
-			log.Println(id, "s3 Upload successfully finished")
+			log.Println(id, "storage upload successfully finished")
 		}()
 
 		logVideo(request, nil).Info("Begin copying")
-		// sentry.AddBreadcrumb(&sentry.Breadcrumb{Message: "copy from muxer to s3"})
-		size, err := io.Copy(writer, muxer)
+		// sentry.AddBreadcrumb(&sentry.Breadcrumb{Message: "copy from muxer to storage"})
+		size, err := io.Copy(&uploadProgressWriter{writer, recording}, muxer)
 		if err != nil {
-			logVideo(request, err).Error("copy muxer to s3:", err)
+			logVideo(request, err).Error("copy muxer to storage:", err)
 			sentry.CaptureException(err)
+
+			// keep reading so that ffmpeg does not block on a full stdout and can exit
+			_, _ = io.Copy(io.Discard, muxer)
 		}
 		logVideo(request, nil).Info(id, "Finished reading from ffmpeg: ", size)
+		app.setJobState(id, JobStateUploading, nil)
 		// NOTE(emily): Must close first before writing.
 		// sizeWritten <- size will block until its read
-		// but it wont be read until s3 finishes, which is after the writer
+		// but it wont be read until storage finishes, which is after the writer
 		// has closed.
 		_ = writer.CloseWithError(io.EOF)
 		sizeWritten <- size
 	}()
 
+	concurrency := segmentConcurrency(request.Concurrency)
+
+	downloadOptions := video.Options{
+		RetryPolicy: video.DefaultRetryPolicy(),
+		OnGap: func(gap video.Gap) {
+			recording.update(func(progress *RecordingProgress) {
+				progress.SegmentsFailed += int64(gap.Count)
+			})
+			app.recordGap(id, part, gap)
+		},
+		OnSegment: func(segment hls.Segment, size int) {
+			recording.update(func(progress *RecordingProgress) {
+				progress.SegmentsFetched += 1
+			})
+		},
+		Concurrency: concurrency,
+	}
+
+	if strings.ToLower(os.Getenv("KEEP_SEGMENTS")) == "true" {
+		downloadOptions.Archiver = video.NewStorageArchiver(app.storage, id, VideoPartKey(id, part, part, "segments"))
+	}
+
 	go func() {
 		downloaderSpan := span.StartChild("downloader")
 		defer downloaderSpan.Finish()
 		logVideo(request, nil).Info("Starting segment downloader")
 		defer logVideo(request, nil).Info("Segment downloader stopped")
-		video.Download(id, hlsClient.Segments, muxer)
+		video.Download(captureCtx, id, hlsClient.Segments, muxer, downloadOptions)
 	}()
 
+	if audioClient != nil {
+		audioOptions := downloadOptions
+		audioOptions.OnGap = func(gap video.Gap) {
+			gap.Reason = "audio: " + gap.Reason
+			downloadOptions.OnGap(gap)
+		}
+
+		if downloadOptions.Archiver != nil {
+			audioOptions.Archiver = video.NewStorageArchiver(app.storage, id, VideoPartKey(id, part, part, "audio.segments"))
+		}
+
+		go func() {
+			audioDownloaderSpan := span.StartChild("audio downloader")
+			defer audioDownloaderSpan.Finish()
+			logVideo(request, nil).Info("Starting audio segment downloader")
+			defer logVideo(request, nil).Info("Audio segment downloader stopped")
+			video.Download(captureCtx, id, audioClient.Segments, muxer.AudioWriter(), audioOptions)
+		}()
+	}
+
 	<-finished
+	size = <-sizeWritten
+
+	if err := muxer.Wait(); err != nil {
+		logVideo(request, err).Warn("ffmpeg exited with an error")
+	}
+
+	// the chat keeps going until the livestream is over, it ends together with the video
+	stopCapture()
+	<-chatDone
+
+	if uploadErr != nil {
+		go uploadLog(app.storage, id, VideoPartKey(id, part, part, "log"))
+		return 0, 0, fmt.Errorf("failed to upload recording: %w", uploadErr)
+	}
+
 	log.Println(id, "record finished")
-	go uploadLog(s3, id)
-	return <-sizeWritten, nil
+	go uploadLog(app.storage, id, VideoPartKey(id, part, part, "log"))
+	return size, muxer.Progress().OutTime, nil
 }
 
-func uploadLog(s3 *s3.Client, id string) {
+// uploadProgressWriter counts the bytes handed to the storage upload
+type uploadProgressWriter struct {
+	writer    io.Writer
+	recording *activeRecording
+}
+
+func (w *uploadProgressWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+
+	w.recording.update(func(progress *RecordingProgress) {
+		progress.BytesUploaded += int64(n)
+	})
+
+	return n, err
+}
+
+func uploadLog(backend storage.Backend, id string, key string) {
 	ffmpegLog := ffmpegLogs[id].String()
 	lines := strings.Split(ffmpegLog, "\n")
 
@@ -315,9 +416,9 @@ func uploadLog(s3 *s3.Client, id string) {
 		lines = lines[3:]
 	}
 
-	err := s3.Upload(fmt.Sprintf("%s.log", id), strings.NewReader(strings.Join(lines, "\n")), "text/plain")
+	err := backend.Upload(context.Background(), key, strings.NewReader(strings.Join(lines, "\n")), "text/plain")
 	if err != nil {
-		log.Println(id, "uploadLog: s3.Upload2():", err)
+		log.Println(id, "uploadLog: storage.Upload():", err)
 		sentry.CaptureException(err)
 		return
 	}
@@ -332,6 +433,46 @@ func logVideo(request VideoRequest, err error) (entry *log.Entry) {
 	return
 }
 
+// metadataRetryBackoff is how long to wait before looking up the metadata of a job again after a transient error, it
+// doubles with every further attempt up to metadataRetryMaxBackoff
+const metadataRetryBackoff = time.Minute
+const metadataRetryMaxBackoff = 30 * time.Minute
+
+// metadataRetryAttempts is how many attempts a job gets before a transient metadata error fails it
+const metadataRetryAttempts = 10
+
+// retryAfterMetadataError reschedules the job of `id` with backoff if looking up its metadata failed transiently,
+// e.g. because YouTube or the network was unreachable. Unknown videos and jobs without attempts left fail.
+func (app *Application) retryAfterMetadataError(id string, request VideoRequest, attempts int32, err error) {
+	if errors.Is(err, source.ErrNotFound) || errors.Is(err, source.ErrUnsupportedUrl) || attempts >= metadataRetryAttempts {
+		app.setJobState(id, JobStateFailed, err)
+		return
+	}
+
+	delay := metadataRetryBackoff
+
+	for i := int32(1); i < attempts && delay < metadataRetryMaxBackoff; i++ {
+		delay *= 2
+	}
+
+	if delay > metadataRetryMaxBackoff {
+		delay = metadataRetryMaxBackoff
+	}
+
+	logVideo(request, err).Warn("Retrying to get metadata in ", delay)
+	app.setJobState(id, JobStateScheduled, err)
+
+	if _, err := Scheduler.
+		SingletonMode().
+		LimitRunsTo(1).
+		StartAt(time.Now().Add(delay)).
+		Tag("RetryMetadata"+id).
+		Do(StartRecording, app, request); err != nil {
+		logVideo(request, err).Error("Failed to reschedule video")
+		app.setJobState(id, JobStateFailed, err)
+	}
+}
+
 func StartRecording(app *Application, request VideoRequest) {
 	logVideo(request, nil).Info("Start recording")
 	id, err := request.Id()
@@ -339,17 +480,31 @@ func StartRecording(app *Application, request VideoRequest) {
 		logVideo(request, err).Error("Failed to get video id")
 		return
 	}
+
+	if err := BeginRecordingJobAttempt(app.db, id); err != nil {
+		logVideo(request, err).Error("Failed to begin recording job attempt")
+	}
+
 	// See if this video has been re-scheduled into the future...
 	metadata, err := GetVideoMetadata(id)
 
 	if err != nil {
 		logVideo(request, err).Error("Failed to get metadata for scheduled video")
+
+		var attempts int32
+
+		if job, jobErr := FindRecordingJob(app.db, id); jobErr == nil && job != nil {
+			attempts = job.Attempts
+		}
+
+		app.retryAfterMetadataError(id, request, attempts, err)
 		return
 	}
 
 	newStartTime, err := GetVideoStartTime(metadata)
 	if err != nil {
 		logVideo(request, err).Error("Failed to parse new start time from metadata for video")
+		app.setJobState(id, JobStateFailed, err)
 		return
 	}
 
@@ -359,6 +514,7 @@ func StartRecording(app *Application, request VideoRequest) {
 
 	if time.Until(newStartTime) > (RETRY_INTERVAL * MAX_RETRIES) {
 		logVideo(request, nil).Info("video has been moved to more than", MAX_DURATION.String(), "into the future, rescheduling")
+		app.setJobState(id, JobStateScheduled, nil)
 		// Schedule a new cronjob that will re-queue the video
 		if _, err := Scheduler.
 			SingletonMode().
@@ -373,20 +529,34 @@ func StartRecording(app *Application, request VideoRequest) {
 
 	for try := 0; try < MAX_RETRIES; try += 1 {
 		if started, err := hasLivestreamStarted(request); err == nil && started {
-			size, err := record(request)
+			part, err := VideoParts(app.db, id)
+			if err != nil {
+				logVideo(request, err).Error("Failed to get amount of video parts")
+				app.setJobState(id, JobStateFailed, err)
+				return
+			}
+			app.setJobState(id, JobStateRecording, nil)
+			size, length, err := app.record(context.Background(), request, part)
 			if err != nil {
 				log.Println("record failed:", err)
+				app.setJobState(id, JobStateFailed, err)
 				return
 			}
-			err = app.recordFinished(app.db, id, size)
+			err = app.recordFinished(app.db, id, size, length)
 			if err != nil {
 				logVideo(request, err).Error("Failed record finish")
+				app.setJobState(id, JobStateFailed, err)
+				return
 			}
+			app.setJobState(id, JobStateFinished, nil)
+
+			go app.postProcessVideo(id)
 			return
 		} else if err == ErrorLivestreamNotStarted {
 			logVideo(request, nil).Info("Livestream has not started yet")
 		} else if err != nil {
 			logVideo(request, err).Error("Failed checking livestream started")
+			app.setJobState(id, JobStateFailed, err)
 			err = recordFailed(app.db, id)
 			if err != nil {
 				logVideo(request, err).Error("Failed recordFailed")
@@ -397,6 +567,9 @@ func StartRecording(app *Application, request VideoRequest) {
 		logVideo(request, nil).Info("Waiting for video, try=", try)
 		time.Sleep(RETRY_INTERVAL)
 	}
+
+	logVideo(request, nil).Error("Livestream did not start within ", MAX_DURATION.String())
+	app.setJobState(id, JobStateFailed, errors.New("livestream did not start within "+MAX_DURATION.String()))
 }
 
 func (app *Application) Log(w http.ResponseWriter, r *http.Request) {
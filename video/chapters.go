@@ -0,0 +1,20 @@
+package video
+
+import (
+	"context"
+)
+
+// AddChapters copies `input` into `output` with the chapters of the ffmpeg metadata file at `metadata`, replacing
+// any chapters `input` already has. `input` can be a path or url, `output` has to be a path.
+func AddChapters(ctx context.Context, input string, metadata string, output string) error {
+	return runFfmpeg(ctx, []string{
+		"-hide_banner", "-y",
+		"-i", input,
+		"-i", metadata,
+		"-map", "0",
+		"-map_chapters", "1",
+		"-c", "copy",
+		"-movflags", "+faststart",
+		output,
+	})
+}
@@ -2,11 +2,14 @@ package video
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"pomu/hls"
+	"sync"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
@@ -22,82 +25,326 @@ func logVideo(id string, err error) (entry *log.Entry) {
 	return
 }
 
+// Gap is one or more segments which could not be downloaded and are therefore missing from the recording
+type Gap struct {
+	// Sequence is the media sequence number of the first missing segment
+	Sequence uint64
+	// Count is the amount of consecutive segments which are missing
+	Count int
+	// Time is when the missing segment was received since the playlist started
+	Time time.Duration
+	// Reason describes why the segment could not be downloaded
+	Reason string
+	// Attempts is how often pomu has tried to download the segment
+	Attempts   int
+	OccurredAt time.Time
+}
+
+// Options configure how Download handles segments
+type Options struct {
+	// Archiver, if set, additionally receives every segment that was written into the writer
+	Archiver SegmentArchiver
+	// RetryPolicy decides whether and when a failed segment download is retried
+	RetryPolicy RetryPolicy
+	// OnGap, if set, is called for every segment which is missing from the recording
+	OnGap func(gap Gap)
+	// OnSegment, if set, is called for every segment which has been written, with the size of the segment in bytes
+	OnSegment func(segment hls.Segment, size int)
+	// Concurrency is how many segments are fetched at the same time. They are still written in order.
+	Concurrency int
+}
+
+// segmentJob is a segment to fetch. Index counts the segments in the order they have been received, media sequence
+// numbers cannot be used as they start over if the playlist gets reset.
+type segmentJob struct {
+	index   uint64
+	segment hls.Segment
+}
+
+// segmentResult is a downloaded segment waiting to be written
+type segmentResult struct {
+	index    uint64
+	segment  hls.Segment
+	data     []byte
+	attempts int
+	err      error
+}
+
+// segmentError is returned if a segment could not be downloaded
+type segmentError struct {
+	reason string
+	// statusCode is the http status of the response, if one was received
+	statusCode int
+	// retryable is whether a failure without a response is worth retrying
+	retryable bool
+}
+
+func (err *segmentError) Error() string {
+	return err.reason
+}
+
+// fetchSegment does a single attempt at downloading `segment`
+func fetchSegment(ctx context.Context, client *http.Client, segment hls.Segment) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", segment.Url, nil)
+	if err != nil {
+		return nil, &segmentError{reason: fmt.Sprint("http.NewRequest(): ", err)}
+	}
+
+	req.Header.Set("User-Agent", os.Getenv("HTTP_USERAGENT"))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, &segmentError{reason: fmt.Sprint("request failed: ", err), retryable: true}
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		sentry.AddBreadcrumb(&sentry.Breadcrumb{
+			Data: map[string]interface{}{
+				"status":           resp.Status,
+				"response headers": resp.Header,
+			},
+			Level: sentry.LevelInfo,
+		})
+		return nil, &segmentError{reason: fmt.Sprint("unexpected status ", resp.Status), statusCode: resp.StatusCode}
+	}
+
+	// NOTE: segments are read fully first so that a partially received segment can be retried
+	// and the segment can be handed to the archiver as well
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &segmentError{reason: fmt.Sprint("failed to read segment: ", err), retryable: true}
+	}
+
+	if resp.ContentLength > int64(len(data)) {
+		return nil, &segmentError{reason: fmt.Sprint("io.ReadAll did not read enough ", len(data), " read vs ", resp.ContentLength), retryable: true}
+	}
+
+	return data, nil
+}
+
+// downloadSegment downloads `segment`, retrying according to `policy`
+func downloadSegment(ctx context.Context, id string, client *http.Client, segment hls.Segment, policy RetryPolicy) ([]byte, int, error) {
+	var err error
+
+	for attempt := 1; ; attempt++ {
+		var data []byte
+		data, err = fetchSegment(ctx, client, segment)
+
+		if err == nil {
+			return data, attempt, nil
+		}
+
+		if ctx.Err() != nil {
+			return nil, attempt, ctx.Err()
+		}
+
+		retryable := err.(*segmentError).retryable
+
+		if statusCode := err.(*segmentError).statusCode; statusCode != 0 {
+			retryable = policy.retriesStatus(statusCode)
+		}
+
+		if !retryable || attempt >= policy.Attempts {
+			return nil, attempt, err
+		}
+
+		delay := policy.delay(attempt)
+		logVideo(id, err).Warn("Download failed segment attempt ", attempt, ", retrying in ", delay)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, attempt, ctx.Err()
+		}
+	}
+}
+
 // Download downloads segments from the segments channel
-// and writes the data into writer w
-func Download(id string, segments chan hls.Segment, w io.WriteCloser) {
+// and writes the data into writer w.
+// Cancelling `ctx` stops taking new segments, everything downloaded up to then is still written before w is closed.
+func Download(ctx context.Context, id string, segments chan hls.Segment, w io.WriteCloser, options Options) {
+	if options.Archiver != nil {
+		// deferred first so that the writer is closed before waiting for the archiver
+		defer func() {
+			if err := options.Archiver.Close(); err != nil {
+				sentry.CaptureException(err)
+				logVideo(id, err).Error("Failed to archive segments")
+			}
+		}()
+	}
+
 	defer w.Close()
-	client := http.DefaultClient
+
+	concurrency := options.Concurrency
+
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = concurrency
+	client := &http.Client{Transport: transport}
 	failedSegments := 0
 
 	span := sentry.StartSpan(
-		context.Background(),
+		ctx,
 		"Download",
 		sentry.TransactionName(
 			fmt.Sprintf("Download %s", id)))
 	defer span.Finish()
 
-	for segment := range segments {
-		req, err := http.NewRequest("GET", segment.Url, nil)
-		if err != nil {
-			log.Println("http.NewRequest():", err)
-			sentry.CaptureException(err)
-			return
-		}
+	// order holds the indices of the segments in the order they have been received. Its capacity limits how far
+	// the fetchers may get ahead of a slow segment.
+	order := make(chan uint64, concurrency*2)
+	jobs := make(chan segmentJob)
+	results := make(chan segmentResult, concurrency)
 
-		sentry.AddBreadcrumb(&sentry.Breadcrumb{
-			Data: map[string]interface{}{
-				"segmentURL":  segment.Url,
-				"segmentTIme": segment.Time,
-			},
-			Level: sentry.LevelInfo,
-		})
+	go func() {
+		defer close(jobs)
+		defer close(order)
 
-		req.Header.Set("User-Agent", os.Getenv("HTTP_USERAGENT"))
+		index := uint64(0)
 
-		resp, err := client.Do(req)
-		if err != nil {
-			logVideo(id, err).Error("Download failed request for segment", segment.Time, segment.Url)
-			sentry.CaptureException(err)
-			break
+		for {
+			select {
+			case segment, ok := <-segments:
+				if !ok {
+					return
+				}
+
+				order <- index
+				jobs <- segmentJob{index, segment}
+				index++
+			case <-ctx.Done():
+				return
+			}
 		}
+	}()
+
+	var fetchers sync.WaitGroup
 
-		if !func(resp *http.Response) bool {
-			defer resp.Body.Close()
+	for i := 0; i < concurrency; i++ {
+		fetchers.Add(1)
+
+		go func() {
+			defer fetchers.Done()
+
+			for job := range jobs {
+				segment := job.segment
 
-			if resp.StatusCode != 200 {
 				sentry.AddBreadcrumb(&sentry.Breadcrumb{
 					Data: map[string]interface{}{
-						"status":           resp.Status,
-						"response headers": resp.Header,
+						"segmentURL":      segment.Url,
+						"segmentTIme":     segment.Time,
+						"segmentSequence": segment.Sequence,
 					},
 					Level: sentry.LevelInfo,
 				})
-				logVideo(id, nil).Error("Download failed to get segment because of status", resp.StatusCode)
-				failedSegments += 1
-				return false
+
+				data, attempts, err := downloadSegment(ctx, id, client, segment, options.RetryPolicy)
+				results <- segmentResult{job.index, segment, data, attempts, err}
 			}
+		}()
+	}
 
-			n, err := io.Copy(w, resp.Body)
-			if err != nil {
-				logVideo(id, err).Error("Download failed to copy segment to writer")
-				failedSegments += 1
-				sentry.AddBreadcrumb(&sentry.Breadcrumb{
-					Message: "Failed to copy segment to writer",
-					Level:   sentry.LevelError,
+	go func() {
+		fetchers.Wait()
+		close(results)
+	}()
+
+	// reorder buffer, keyed by index
+	pending := make(map[uint64]segmentResult)
+	var previous *hls.Segment
+
+	for index := range order {
+		result, ok := pending[index]
+
+		for !ok {
+			received, open := <-results
+			if !open {
+				break
+			}
+
+			pending[received.index] = received
+			result, ok = pending[index]
+		}
+
+		if !ok {
+			logVideo(id, nil).Error("Download lost segment ", index)
+			continue
+		}
+
+		delete(pending, index)
+		segment := result.segment
+
+		if segment.Discontinuity {
+			logVideo(id, nil).Warn("Download received discontinuity at segment ", segment.Sequence)
+		} else if previous != nil && segment.Sequence > previous.Sequence+1 {
+			missing := int(segment.Sequence - previous.Sequence - 1)
+			logVideo(id, nil).Error("Download is missing ", missing, " segments before segment ", segment.Sequence)
+			failedSegments += missing
+
+			if options.OnGap != nil {
+				options.OnGap(Gap{
+					Sequence:   previous.Sequence + 1,
+					Count:      missing,
+					Time:       segment.Time,
+					Reason:     "segments left the playlist before they could be downloaded",
+					OccurredAt: time.Now(),
 				})
 			}
-			if resp.ContentLength > n {
-				log.Println("video.Download(): io.Copy did not copy enough", n, "copied vs", resp.ContentLength)
-				failedSegments += 1
-				sentry.AddBreadcrumb(&sentry.Breadcrumb{
-					Message: "io.Copy did not copy enough",
-					Level:   sentry.LevelError,
+		}
+
+		previous = &segment
+
+		// segments which were still in flight when the download got stopped are not missing, they are just
+		// after the end of the recording
+		if result.err != nil && errors.Is(result.err, ctx.Err()) {
+			continue
+		}
+
+		if result.err != nil {
+			logVideo(id, result.err).Error("Download failed to get segment ", segment.Time, " after ", result.attempts, " attempts")
+			failedSegments += 1
+
+			if options.OnGap != nil {
+				options.OnGap(Gap{
+					Sequence:   segment.Sequence,
+					Count:      1,
+					Time:       segment.Time,
+					Reason:     result.err.Error(),
+					Attempts:   result.attempts,
+					OccurredAt: time.Now(),
 				})
 			}
-			return true
-		}(resp) {
-			logVideo(id, nil).Error("Failed to download a segment")
+			continue
+		}
+
+		if _, err := w.Write(result.data); err != nil {
+			logVideo(id, err).Error("Download failed to copy segment to writer")
+			failedSegments += 1
+			sentry.AddBreadcrumb(&sentry.Breadcrumb{
+				Message: "Failed to copy segment to writer",
+				Level:   sentry.LevelError,
+			})
+			continue
+		}
+
+		if options.OnSegment != nil {
+			options.OnSegment(segment, len(result.data))
 		}
+
+		if options.Archiver != nil {
+			if err := options.Archiver.Archive(segment, result.data); err != nil {
+				logVideo(id, err).Error("Download failed to archive segment")
+			}
+		}
+	}
+
+	// drain whatever is left so that no fetcher stays blocked
+	for range results {
 	}
 
 	if failedSegments > 0 {
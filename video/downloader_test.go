@@ -0,0 +1,242 @@
+package video
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"pomu/hls"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type closeBuffer struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (b *closeBuffer) Close() error {
+	b.closed = true
+	return nil
+}
+
+// segmentServer serves /<sequence> as "<sequence>|" after `delays[sequence]`. The statuses in `failures[sequence]`
+// are answered to the first requests of a segment instead.
+type segmentServer struct {
+	delays   map[uint64]time.Duration
+	failures map[uint64][]int
+
+	mutex    sync.Mutex
+	requests map[uint64]int
+	// finished is the order in which segments have been served successfully
+	finished []uint64
+}
+
+func (s *segmentServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	sequence, _ := strconv.ParseUint(strings.TrimPrefix(r.URL.Path, "/"), 10, 64)
+
+	s.mutex.Lock()
+	attempt := s.requests[sequence]
+	s.requests[sequence]++
+	s.mutex.Unlock()
+
+	if failures := s.failures[sequence]; attempt < len(failures) {
+		w.WriteHeader(failures[attempt])
+		return
+	}
+
+	time.Sleep(s.delays[sequence])
+
+	s.mutex.Lock()
+	s.finished = append(s.finished, sequence)
+	s.mutex.Unlock()
+
+	_, _ = fmt.Fprintf(w, "%d|", sequence)
+}
+
+func sendSegments(server *httptest.Server, sequences ...uint64) chan hls.Segment {
+	segments := make(chan hls.Segment, len(sequences))
+
+	for _, sequence := range sequences {
+		segments <- hls.Segment{Url: fmt.Sprintf("%s/%d", server.URL, sequence), Sequence: sequence}
+	}
+
+	return segments
+}
+
+func TestDownload(t *testing.T) {
+	handler := &segmentServer{
+		delays: map[uint64]time.Duration{0: 80 * time.Millisecond, 2: 40 * time.Millisecond, 4: 20 * time.Millisecond},
+		failures: map[uint64][]int{
+			3: {404},
+			5: {500},
+			6: {503, 503, 503},
+		},
+		requests: make(map[uint64]int),
+	}
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	// 7 and 8 left the playlist before they were received
+	segments := sendSegments(server, 0, 1, 2, 3, 4, 5, 6, 9)
+	close(segments)
+
+	var gaps []Gap
+	var written []uint64
+	output := &closeBuffer{}
+
+	Download(context.Background(), "test", segments, output, Options{
+		RetryPolicy: RetryPolicy{Attempts: 3, Backoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond, RetryStatusCodes: []int{500, 503}},
+		OnGap:       func(gap Gap) { gaps = append(gaps, gap) },
+		OnSegment:   func(segment hls.Segment, size int) { written = append(written, segment.Sequence) },
+		Concurrency: 4,
+	})
+
+	assert.NotEqual(t, []uint64{0, 1, 2, 4, 5, 9}, handler.finished, "segments did not finish out of order")
+	assert.Equal(t, "0|1|2|4|5|9|", output.String())
+	assert.Equal(t, []uint64{0, 1, 2, 4, 5, 9}, written)
+	assert.True(t, output.closed)
+
+	assert.Equal(t, 1, handler.requests[3], "404 is not retried by the policy")
+	assert.Equal(t, 2, handler.requests[5])
+	assert.Equal(t, 3, handler.requests[6])
+
+	if assert.Len(t, gaps, 3) {
+		assert.Equal(t, uint64(3), gaps[0].Sequence)
+		assert.Equal(t, 1, gaps[0].Count)
+		assert.Equal(t, 1, gaps[0].Attempts)
+		assert.Contains(t, gaps[0].Reason, "404")
+
+		assert.Equal(t, uint64(6), gaps[1].Sequence)
+		assert.Equal(t, 3, gaps[1].Attempts)
+		assert.Contains(t, gaps[1].Reason, "503")
+
+		assert.Equal(t, uint64(7), gaps[2].Sequence)
+		assert.Equal(t, 2, gaps[2].Count)
+	}
+}
+
+func TestDownloadCancel(t *testing.T) {
+	var mutex sync.Mutex
+	active, requests := 0, 0
+	started := make(chan struct{}, 10)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		active++
+		requests++
+		mutex.Unlock()
+
+		started <- struct{}{}
+		<-r.Context().Done()
+
+		mutex.Lock()
+		active--
+		mutex.Unlock()
+	}))
+	defer server.Close()
+
+	// the channel is never closed, only cancelling ends the download
+	segments := sendSegments(server, 0, 1, 2, 3, 4, 5)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	var gaps []Gap
+	output := &closeBuffer{}
+
+	go func() {
+		Download(ctx, "test", segments, output, Options{
+			RetryPolicy: RetryPolicy{Attempts: 3, Backoff: time.Millisecond, MaxBackoff: time.Millisecond},
+			OnGap:       func(gap Gap) { gaps = append(gaps, gap) },
+			Concurrency: 2,
+		})
+		close(done)
+	}()
+
+	<-started
+	<-started
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("download did not stop after cancelling")
+	}
+
+	// Download only returns after every fetcher has exited, so no request is started anymore
+	mutex.Lock()
+	requestsAfterCancel := requests
+	mutex.Unlock()
+
+	assert.Eventually(t, func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		return active == 0
+	}, time.Second, 10*time.Millisecond)
+
+	time.Sleep(50 * time.Millisecond)
+
+	mutex.Lock()
+	assert.Equal(t, requestsAfterCancel, requests)
+	mutex.Unlock()
+
+	assert.LessOrEqual(t, requestsAfterCancel, 2)
+	assert.Empty(t, gaps, "segments in flight when cancelling are not gaps")
+	assert.Empty(t, output.String())
+	assert.True(t, output.closed)
+}
+
+func TestDownloadSequenceReset(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the segments before the reset finish last, while the repeated sequence numbers are waiting to be written
+		if strings.HasPrefix(r.URL.Path, "/before/") {
+			time.Sleep(50 * time.Millisecond)
+		}
+
+		_, _ = fmt.Fprintf(w, "%s|", strings.TrimPrefix(r.URL.Path, "/"))
+	}))
+	defer server.Close()
+
+	segments := make(chan hls.Segment, 4)
+
+	for _, segment := range []hls.Segment{
+		{Url: server.URL + "/before/5", Sequence: 5},
+		{Url: server.URL + "/before/6", Sequence: 6},
+		{Url: server.URL + "/after/5", Sequence: 5, Discontinuity: true},
+		{Url: server.URL + "/after/6", Sequence: 6},
+	} {
+		segments <- segment
+	}
+
+	close(segments)
+
+	var gaps []Gap
+	output := &closeBuffer{}
+	done := make(chan struct{})
+
+	go func() {
+		Download(context.Background(), "test", segments, output, Options{
+			RetryPolicy: RetryPolicy{Attempts: 1},
+			OnGap:       func(gap Gap) { gaps = append(gaps, gap) },
+			Concurrency: 4,
+		})
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("download did not finish after the sequence reset")
+	}
+
+	assert.Equal(t, "before/5|before/6|after/5|after/6|", output.String())
+	assert.Empty(t, gaps)
+}
@@ -2,10 +2,12 @@ package video
 
 import (
 	"context"
+	"fmt"
 	log "github.com/sirupsen/logrus"
 	"io"
 	"os"
 	"os/exec"
+	"sync"
 
 	"github.com/getsentry/sentry-go"
 )
@@ -14,38 +16,148 @@ type Muxer struct {
 	cmd    *exec.Cmd
 	stdin  io.WriteCloser
 	stdout io.ReadCloser
+	audio  *os.File
 	// Stderr is where to write stderr to
 	Stderr io.Writer
+	// SeparateAudio adds a second input for an audio rendition which is published separately from the video.
+	// The audio segments have to be written into AudioWriter.
+	SeparateAudio bool
+	// Subtitles are added as text tracks. They have to be complete before the muxer is started.
+	Subtitles []SubtitleTrack
+	// OnProgress, if set, is called for every progress report of ffmpeg
+	OnProgress func(progress Progress)
+
+	progressMutex sync.Mutex
+	progress      Progress
+	progressDone  chan struct{}
 }
 
-func (w *Muxer) Start() error {
-	span := sentry.StartSpan(context.Background(), "ffmpeg start muxer")
+// Start starts ffmpeg. Cancelling `ctx` kills ffmpeg, to finish the recording gracefully close the muxer instead.
+func (w *Muxer) Start(ctx context.Context) error {
+	span := sentry.StartSpan(ctx, "ffmpeg start muxer")
 	defer span.Finish()
 
-	cmd := exec.Command(
-		os.Getenv("FFMPEG"),
-		"-i", "pipe:0",
-		"-c", "copy",
+	// NOTE: ExtraFiles start at file descriptor 3
+	var extraFiles []*os.File
+
+	progressReader, progressWriter, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+
+	extraFiles = append(extraFiles, progressWriter)
+	args := []string{"-progress", "pipe:3", "-i", "pipe:0"}
+	var maps []string
+	inputs := 1
+
+	if w.SeparateAudio {
+		var audioReader *os.File
+		audioReader, w.audio, err = os.Pipe()
+		if err != nil {
+			_ = progressReader.Close()
+			_ = progressWriter.Close()
+			return err
+		}
+
+		extraFiles = append(extraFiles, audioReader)
+		args = append(args, "-i", fmt.Sprintf("pipe:%d", 2+len(extraFiles)))
+		maps = []string{"-map", "0:v", "-map", "1:a"}
+		inputs += 1
+	} else if len(w.Subtitles) > 0 {
+		// mapping the subtitles disables the automatic stream selection
+		maps = []string{"-map", "0:v", "-map", "0:a?"}
+	}
+
+	// NOTE: every input has to come before the output options
+	subtitleInputs, subtitleOptions := subtitleArgs(w.Subtitles, inputs)
+	args = append(args, subtitleInputs...)
+	args = append(args, maps...)
+	args = append(args, "-c", "copy")
+	args = append(args, subtitleOptions...)
+
+	cmd := exec.CommandContext(ctx, os.Getenv("FFMPEG"), append(args,
 		"-movflags", "frag_keyframe+empty_moov",
 		"-max_muxing_queue_size", "1024",
 		"-bsf:a", "aac_adtstoasc",
 		"-f", "mp4",
 		"-hide_banner",
-		"pipe:1")
+		"pipe:1")...)
+
+	cmd.ExtraFiles = extraFiles
+
+	// ffmpeg holds its own copies once started, ours would keep the pipes open after ffmpeg exits or AudioWriter is closed
+	defer func() {
+		for _, file := range extraFiles {
+			_ = file.Close()
+		}
+	}()
 
-	var err error
 	w.stdin, err = cmd.StdinPipe()
 	if err != nil {
+		_ = progressReader.Close()
 		return err
 	}
 	w.stdout, err = cmd.StdoutPipe()
 	if err != nil {
+		_ = progressReader.Close()
 		return err
 	}
 	cmd.Stderr = w.Stderr
 	w.cmd = cmd
 	defer log.Println("Started ffmpeg")
-	return w.cmd.Start()
+
+	if err := w.cmd.Start(); err != nil {
+		_ = progressReader.Close()
+		return err
+	}
+
+	w.progressDone = make(chan struct{})
+
+	go func() {
+		defer close(w.progressDone)
+		defer progressReader.Close()
+
+		err := ReadProgress(progressReader, func(progress Progress) {
+			w.progressMutex.Lock()
+			w.progress = progress
+			w.progressMutex.Unlock()
+
+			if w.OnProgress != nil {
+				w.OnProgress(progress)
+			}
+		})
+
+		if err != nil {
+			log.WithFields(log.Fields{"error": err}).Warn("Failed to read ffmpeg progress")
+		}
+	}()
+
+	return nil
+}
+
+// Progress returns the latest progress report of ffmpeg
+func (w *Muxer) Progress() Progress {
+	w.progressMutex.Lock()
+	defer w.progressMutex.Unlock()
+
+	return w.progress
+}
+
+// Wait waits for ffmpeg to exit after everything has been read from the muxer. Afterwards Progress contains the final
+// progress report.
+func (w *Muxer) Wait() error {
+	err := w.cmd.Wait()
+	<-w.progressDone
+	return err
+}
+
+// AudioWriter returns the writer of the separate audio input, nil unless SeparateAudio is set
+func (w *Muxer) AudioWriter() io.WriteCloser {
+	if w.audio == nil {
+		return nil
+	}
+
+	return w.audio
 }
 
 func (w *Muxer) Write(p []byte) (int, error) {
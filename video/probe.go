@@ -0,0 +1,135 @@
+package video
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProbeResult describes the media of a file as reported by ffprobe
+type ProbeResult struct {
+	VideoCodec string
+	AudioCodec string
+	Width      int
+	Height     int
+	Fps        float64
+	// Bitrate in bit/s
+	Bitrate       int64
+	AudioChannels int
+	Duration      time.Duration
+}
+
+type ffprobeOutput struct {
+	Streams []struct {
+		CodecType    string `json:"codec_type"`
+		CodecName    string `json:"codec_name"`
+		Width        int    `json:"width"`
+		Height       int    `json:"height"`
+		AvgFrameRate string `json:"avg_frame_rate"`
+		Channels     int    `json:"channels"`
+		Duration     string `json:"duration"`
+	} `json:"streams"`
+	Format struct {
+		Duration string `json:"duration"`
+		BitRate  string `json:"bit_rate"`
+	} `json:"format"`
+}
+
+// Probe runs ffprobe against `input`, which can be a path or url
+func Probe(ctx context.Context, input string) (*ProbeResult, error) {
+	ffprobe := os.Getenv("FFPROBE")
+
+	if len(ffprobe) <= 0 {
+		ffprobe = "ffprobe"
+	}
+
+	stdout := new(bytes.Buffer)
+	stderr := new(strings.Builder)
+
+	cmd := exec.CommandContext(ctx, ffprobe, "-v", "error", "-print_format", "json", "-show_format", "-show_streams", input)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ffprobe failed: %w (output was %s)", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return parseProbe(stdout.Bytes())
+}
+
+func parseProbe(data []byte) (*ProbeResult, error) {
+	var output ffprobeOutput
+
+	if err := json.Unmarshal(data, &output); err != nil {
+		return nil, err
+	}
+
+	var result ProbeResult
+	var streamDuration float64
+
+	for _, stream := range output.Streams {
+		switch stream.CodecType {
+		case "video":
+			if len(result.VideoCodec) > 0 {
+				continue
+			}
+
+			result.VideoCodec = stream.CodecName
+			result.Width = stream.Width
+			result.Height = stream.Height
+			result.Fps = parseFrameRate(stream.AvgFrameRate)
+		case "audio":
+			if len(result.AudioCodec) > 0 {
+				continue
+			}
+
+			result.AudioCodec = stream.CodecName
+			result.AudioChannels = stream.Channels
+		default:
+			continue
+		}
+
+		if duration, err := strconv.ParseFloat(stream.Duration, 64); err == nil && duration > streamDuration {
+			streamDuration = duration
+		}
+	}
+
+	duration, err := strconv.ParseFloat(output.Format.Duration, 64)
+
+	// fragmented files do not always carry a duration in their header, fall back onto the longest stream
+	if err != nil || duration <= 0 {
+		duration = streamDuration
+	}
+
+	result.Duration = time.Duration(duration * float64(time.Second))
+	result.Bitrate, _ = strconv.ParseInt(output.Format.BitRate, 10, 64)
+
+	return &result, nil
+}
+
+// parseFrameRate parses a rational frame rate such as `30000/1001`
+func parseFrameRate(rate string) float64 {
+	numerator, denominator, found := strings.Cut(rate, "/")
+
+	value, err := strconv.ParseFloat(numerator, 64)
+	if err != nil {
+		return 0
+	}
+
+	if !found {
+		return value
+	}
+
+	divisor, err := strconv.ParseFloat(denominator, 64)
+	if err != nil || divisor == 0 {
+		return 0
+	}
+
+	return value / divisor
+}
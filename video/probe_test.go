@@ -0,0 +1,55 @@
+package video
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const probeOutput = `{
+    "streams": [
+        {
+            "index": 0,
+            "codec_name": "h264",
+            "codec_type": "video",
+            "width": 1920,
+            "height": 1080,
+            "avg_frame_rate": "30000/1001",
+            "duration": "3600.066667"
+        },
+        {
+            "index": 1,
+            "codec_name": "aac",
+            "codec_type": "audio",
+            "channels": 2,
+            "avg_frame_rate": "0/0",
+            "duration": "3600.022000"
+        }
+    ],
+    "format": {
+        "duration": "3600.066667",
+        "bit_rate": "4500123"
+    }
+}`
+
+func TestParseProbe(t *testing.T) {
+	result, err := parseProbe([]byte(probeOutput))
+
+	assert.NoError(t, err)
+	assert.Equal(t, "h264", result.VideoCodec)
+	assert.Equal(t, "aac", result.AudioCodec)
+	assert.Equal(t, 1920, result.Width)
+	assert.Equal(t, 1080, result.Height)
+	assert.InDelta(t, 29.97, result.Fps, 0.01)
+	assert.Equal(t, int64(4500123), result.Bitrate)
+	assert.Equal(t, 2, result.AudioChannels)
+	assert.Equal(t, 3600*time.Second, result.Duration.Truncate(time.Second))
+}
+
+func TestParseProbeFallsBackOntoStreamDuration(t *testing.T) {
+	result, err := parseProbe([]byte(`{"streams": [{"codec_type": "video", "codec_name": "h264", "duration": "12.5"}], "format": {}}`))
+
+	assert.NoError(t, err)
+	assert.Equal(t, 12500*time.Millisecond, result.Duration)
+}
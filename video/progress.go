@@ -0,0 +1,75 @@
+package video
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Progress is a single progress report which ffmpeg writes to its `-progress` output
+type Progress struct {
+	Frame int64
+	Fps   float64
+	// Bitrate in kbit/s, 0 if ffmpeg does not know it yet
+	Bitrate float64
+	// TotalSize is the amount of bytes written so far
+	TotalSize int64
+	// OutTime is how much of the input has been muxed so far
+	OutTime         time.Duration
+	DuplicateFrames int64
+	DroppedFrames   int64
+	// Speed relative to real time, 0 if ffmpeg does not know it yet
+	Speed float64
+	// End is set on the last report, after ffmpeg has finished muxing
+	End bool
+}
+
+// ReadProgress parses ffmpeg's `-progress` output from `reader`, calling `handle` for every complete report.
+// Returns once `reader` has been read entirely.
+func ReadProgress(reader io.Reader, handle func(progress Progress)) error {
+	scanner := bufio.NewScanner(reader)
+	var progress Progress
+
+	for scanner.Scan() {
+		key, value, found := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+
+		if !found {
+			continue
+		}
+
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "frame":
+			progress.Frame, _ = strconv.ParseInt(value, 10, 64)
+		case "fps":
+			progress.Fps, _ = strconv.ParseFloat(value, 64)
+		case "bitrate":
+			// e.g. `2540.1kbits/s` or `N/A`
+			progress.Bitrate, _ = strconv.ParseFloat(strings.TrimSuffix(value, "kbits/s"), 64)
+		case "total_size":
+			progress.TotalSize, _ = strconv.ParseInt(value, 10, 64)
+		case "out_time_us", "out_time_ms":
+			// NOTE: despite its name, out_time_ms is in microseconds as well
+			if microseconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+				progress.OutTime = time.Duration(microseconds) * time.Microsecond
+			}
+		case "dup_frames":
+			progress.DuplicateFrames, _ = strconv.ParseInt(value, 10, 64)
+		case "drop_frames":
+			progress.DroppedFrames, _ = strconv.ParseInt(value, 10, 64)
+		case "speed":
+			// e.g. `1.01x` or `N/A`
+			progress.Speed, _ = strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64)
+		case "progress":
+			// every report is terminated by its progress state
+			progress.End = value == "end"
+			handle(progress)
+			progress = Progress{}
+		}
+	}
+
+	return scanner.Err()
+}
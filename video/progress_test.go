@@ -0,0 +1,71 @@
+package video
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const progressOutput = `frame=250
+fps=50.00
+stream_0_0_q=-1.0
+bitrate=2540.1kbits/s
+total_size=3175168
+out_time_us=10000000
+out_time_ms=10000000
+out_time=00:00:10.000000
+dup_frames=1
+drop_frames=2
+speed=1.01x
+progress=continue
+frame=500
+fps=N/A
+bitrate=N/A
+total_size=N/A
+out_time_us=N/A
+out_time_ms=N/A
+out_time=N/A
+dup_frames=0
+drop_frames=0
+speed=N/A
+progress=end
+`
+
+func TestReadProgress(t *testing.T) {
+	var reports []Progress
+
+	err := ReadProgress(strings.NewReader(progressOutput), func(progress Progress) {
+		reports = append(reports, progress)
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []Progress{
+		{
+			Frame:           250,
+			Fps:             50,
+			Bitrate:         2540.1,
+			TotalSize:       3175168,
+			OutTime:         10 * time.Second,
+			DuplicateFrames: 1,
+			DroppedFrames:   2,
+			Speed:           1.01,
+		},
+		{
+			Frame: 500,
+			End:   true,
+		},
+	}, reports)
+}
+
+func TestReadProgressIgnoresGarbage(t *testing.T) {
+	var reports []Progress
+
+	err := ReadProgress(strings.NewReader("\n[mpegts @ 0x0] this is not progress\nout_time_us=1000\nprogress=continue"), func(progress Progress) {
+		reports = append(reports, progress)
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []Progress{{OutTime: time.Millisecond}}, reports)
+}
@@ -0,0 +1,33 @@
+package video
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Remux copies every stream of `input` into `output` without re-encoding. `args` are passed to ffmpeg as output
+// options, e.g. `-movflags +faststart`. `input` can be a path or url, `output` has to be a path.
+func Remux(ctx context.Context, input string, output string, args ...string) error {
+	ffmpegArgs := []string{"-hide_banner", "-y", "-i", input, "-c", "copy"}
+	ffmpegArgs = append(ffmpegArgs, args...)
+	ffmpegArgs = append(ffmpegArgs, output)
+
+	return runFfmpeg(ctx, ffmpegArgs)
+}
+
+// runFfmpeg runs ffmpeg to completion, the last line of its output is included in the error if it fails
+func runFfmpeg(ctx context.Context, args []string) error {
+	stderr := new(strings.Builder)
+	cmd := exec.CommandContext(ctx, os.Getenv("FFMPEG"), args...)
+	cmd.Stderr = stderr
+
+	if err := cmd.Run(); err != nil {
+		lines := strings.Split(strings.TrimSpace(stderr.String()), "\n")
+		return fmt.Errorf("ffmpeg failed: %w (%s)", err, lines[len(lines)-1])
+	}
+
+	return nil
+}
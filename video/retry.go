@@ -0,0 +1,75 @@
+package video
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// RetryPolicy configures how often and when a segment download is retried
+type RetryPolicy struct {
+	// Attempts is the maximum amount of times a segment is requested, including the first request
+	Attempts int
+	// Backoff is how long to wait before the first retry, it doubles with every further retry
+	Backoff time.Duration
+	// MaxBackoff caps the time waited between two retries
+	MaxBackoff time.Duration
+	// RetryStatusCodes are the http status codes which are retried. Any other non-200 status is given up on immediately.
+	RetryStatusCodes []int
+}
+
+// DefaultRetryPolicy returns the retry policy configured through the `SEGMENT_RETRY_*` environment variables
+func DefaultRetryPolicy() RetryPolicy {
+	policy := RetryPolicy{
+		Attempts:         5,
+		Backoff:          250 * time.Millisecond,
+		MaxBackoff:       4 * time.Second,
+		RetryStatusCodes: []int{403, 404, 408, 429, 500, 502, 503, 504},
+	}
+
+	if attempts, err := strconv.Atoi(os.Getenv("SEGMENT_RETRY_ATTEMPTS")); err == nil && attempts > 0 {
+		policy.Attempts = attempts
+	}
+
+	if backoff, err := time.ParseDuration(os.Getenv("SEGMENT_RETRY_BACKOFF")); err == nil {
+		policy.Backoff = backoff
+	}
+
+	if maxBackoff, err := time.ParseDuration(os.Getenv("SEGMENT_RETRY_MAX_BACKOFF")); err == nil {
+		policy.MaxBackoff = maxBackoff
+	}
+
+	if statusCodes := strings.TrimSpace(os.Getenv("SEGMENT_RETRY_STATUS_CODES")); len(statusCodes) > 0 {
+		policy.RetryStatusCodes = []int{}
+
+		for _, statusCode := range strings.Split(statusCodes, ",") {
+			if code, err := strconv.Atoi(strings.TrimSpace(statusCode)); err == nil {
+				policy.RetryStatusCodes = append(policy.RetryStatusCodes, code)
+			}
+		}
+	}
+
+	return policy
+}
+
+// delay returns how long to wait before doing attempt `attempt` (starting at 1 for the first retry)
+func (policy RetryPolicy) delay(attempt int) time.Duration {
+	delay := policy.Backoff
+
+	for i := 1; i < attempt && delay < policy.MaxBackoff; i++ {
+		delay *= 2
+	}
+
+	if delay > policy.MaxBackoff {
+		return policy.MaxBackoff
+	}
+
+	return delay
+}
+
+func (policy RetryPolicy) retriesStatus(statusCode int) bool {
+	return slices.Contains(policy.RetryStatusCodes, statusCode)
+}
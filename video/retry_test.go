@@ -0,0 +1,159 @@
+package video
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"pomu/hls"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{Backoff: 250 * time.Millisecond, MaxBackoff: 4 * time.Second}
+
+	for _, test := range []struct {
+		attempt int
+		delay   time.Duration
+	}{
+		{1, 250 * time.Millisecond},
+		{2, 500 * time.Millisecond},
+		{3, time.Second},
+		{4, 2 * time.Second},
+		{5, 4 * time.Second},
+		{6, 4 * time.Second},
+		{100, 4 * time.Second},
+	} {
+		assert.Equal(t, test.delay, policy.delay(test.attempt), "attempt %d", test.attempt)
+	}
+
+	// a backoff above the cap is capped right away
+	assert.Equal(t, time.Second, RetryPolicy{Backoff: 3 * time.Second, MaxBackoff: time.Second}.delay(1))
+	assert.Equal(t, time.Duration(0), RetryPolicy{}.delay(3))
+}
+
+func TestDefaultRetryPolicy(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		env    map[string]string
+		policy RetryPolicy
+	}{
+		{
+			name: "defaults",
+			policy: RetryPolicy{
+				Attempts:         5,
+				Backoff:          250 * time.Millisecond,
+				MaxBackoff:       4 * time.Second,
+				RetryStatusCodes: []int{403, 404, 408, 429, 500, 502, 503, 504},
+			},
+		},
+		{
+			name: "configured",
+			env: map[string]string{
+				"SEGMENT_RETRY_ATTEMPTS":     "3",
+				"SEGMENT_RETRY_BACKOFF":      "1s",
+				"SEGMENT_RETRY_MAX_BACKOFF":  "10s",
+				"SEGMENT_RETRY_STATUS_CODES": " 500, 503 ,x",
+			},
+			policy: RetryPolicy{
+				Attempts:         3,
+				Backoff:          time.Second,
+				MaxBackoff:       10 * time.Second,
+				RetryStatusCodes: []int{500, 503},
+			},
+		},
+		{
+			name: "invalid",
+			env: map[string]string{
+				"SEGMENT_RETRY_ATTEMPTS":    "0",
+				"SEGMENT_RETRY_BACKOFF":     "soon",
+				"SEGMENT_RETRY_MAX_BACKOFF": "-",
+			},
+			policy: RetryPolicy{
+				Attempts:         5,
+				Backoff:          250 * time.Millisecond,
+				MaxBackoff:       4 * time.Second,
+				RetryStatusCodes: []int{403, 404, 408, 429, 500, 502, 503, 504},
+			},
+		},
+		{
+			name: "no retried status codes",
+			env:  map[string]string{"SEGMENT_RETRY_STATUS_CODES": "none"},
+			policy: RetryPolicy{
+				Attempts:         5,
+				Backoff:          250 * time.Millisecond,
+				MaxBackoff:       4 * time.Second,
+				RetryStatusCodes: []int{},
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			for _, name := range []string{"SEGMENT_RETRY_ATTEMPTS", "SEGMENT_RETRY_BACKOFF", "SEGMENT_RETRY_MAX_BACKOFF", "SEGMENT_RETRY_STATUS_CODES"} {
+				t.Setenv(name, test.env[name])
+			}
+
+			assert.Equal(t, test.policy, DefaultRetryPolicy())
+		})
+	}
+}
+
+func TestRetriesStatus(t *testing.T) {
+	policy := RetryPolicy{RetryStatusCodes: []int{404, 503}}
+
+	for statusCode, retried := range map[int]bool{404: true, 503: true, 500: false, 403: false, 200: false} {
+		assert.Equal(t, retried, policy.retriesStatus(statusCode), "status %d", statusCode)
+	}
+
+	assert.False(t, RetryPolicy{}.retriesStatus(503))
+}
+
+func TestDownloadSegmentAttempts(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		statuses []int
+		attempts int
+		err      bool
+	}{
+		{"success", nil, 1, false},
+		{"retried until success", []int{503, 500}, 3, false},
+		{"not retried", []int{403}, 1, true},
+		{"max attempts", []int{503, 503, 503, 503}, 3, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var mutex sync.Mutex
+			requests := 0
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mutex.Lock()
+				attempt := requests
+				requests++
+				mutex.Unlock()
+
+				if attempt < len(test.statuses) {
+					w.WriteHeader(test.statuses[attempt])
+					return
+				}
+
+				_, _ = w.Write([]byte("segment"))
+			}))
+			defer server.Close()
+
+			policy := RetryPolicy{Attempts: 3, Backoff: time.Millisecond, MaxBackoff: time.Millisecond, RetryStatusCodes: []int{500, 503}}
+			data, attempts, err := downloadSegment(context.Background(), "test", server.Client(), hls.Segment{Url: server.URL}, policy)
+
+			assert.Equal(t, test.attempts, attempts)
+			assert.Equal(t, test.attempts, requests)
+
+			if test.err {
+				assert.Error(t, err)
+				assert.Nil(t, data)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, "segment", string(data))
+			}
+		})
+	}
+}
@@ -0,0 +1,270 @@
+package video
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"pomu/hls"
+	"pomu/storage"
+	"sync"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+)
+
+// manifestUploadInterval is after how many segments the manifest gets re-uploaded, so that it
+// stays mostly up-to-date even if pomu gets interrupted
+const manifestUploadInterval = 30
+
+// SegmentArchiver keeps the original segments next to the muxed recording
+type SegmentArchiver interface {
+	// Archive stores a downloaded segment. It may not hold on to `data` after returning.
+	Archive(segment hls.Segment, data []byte) error
+	// Close finishes archiving and blocks until every segment has been stored
+	Close() error
+}
+
+// Manifest lists the archived segments in the order they have been written into the muxer
+type Manifest struct {
+	VideoId  string            `json:"videoId"`
+	Segments []ManifestSegment `json:"segments"`
+	// Dropped are the sequence numbers of segments which were muxed but could not be archived
+	Dropped []uint64 `json:"dropped,omitempty"`
+}
+
+type ManifestSegment struct {
+	Index    int    `json:"index"`
+	Key      string `json:"key"`
+	Sequence uint64 `json:"sequence"`
+	// Time is the amount of seconds since the playlist started at which the segment was received
+	Time float64 `json:"time"`
+	// Duration is the duration of the segment in seconds
+	Duration        float64    `json:"duration"`
+	Discontinuity   bool       `json:"discontinuity,omitempty"`
+	ProgramDateTime *time.Time `json:"programDateTime,omitempty"`
+	Size            int        `json:"size"`
+}
+
+// ManifestKey returns the storage key of the manifest for segments stored below `prefix`
+func ManifestKey(prefix string) string {
+	return prefix + "/manifest.json"
+}
+
+// archiveBufferSize is how many segments may wait for their upload in memory, further segments are spooled to disk
+const archiveBufferSize = 20
+
+// StorageArchiver uploads segments and their manifest into a storage.Backend below a common prefix
+type StorageArchiver struct {
+	backend  storage.Backend
+	prefix   string
+	manifest Manifest
+	// pending are the segments waiting for their upload, signalled through `wake`
+	pending []archivedSegment
+	// buffered is how many of `pending` are held in memory
+	buffered int
+	closed   bool
+	wake     *sync.Cond
+	done     chan struct{}
+	mutex    sync.Mutex
+	err      error
+}
+
+type archivedSegment struct {
+	entry ManifestSegment
+	data  []byte
+	// spool is the local file holding the data of segments which did not fit into the buffer
+	spool string
+}
+
+// NewStorageArchiver creates an archiver which stores segments of `id` into `backend` below `prefix`
+func NewStorageArchiver(backend storage.Backend, id string, prefix string) *StorageArchiver {
+	archiver := &StorageArchiver{
+		backend:  backend,
+		prefix:   prefix,
+		manifest: Manifest{VideoId: id, Segments: []ManifestSegment{}},
+		done:     make(chan struct{}),
+	}
+
+	// uploading happens in the background and segments go to disk once the buffer is full, so that a slow upload
+	// never stalls the muxer
+	archiver.wake = sync.NewCond(&archiver.mutex)
+
+	go archiver.upload()
+	return archiver
+}
+
+// Archive queues `segment` for uploading. Segments which can neither be buffered nor spooled to disk are dropped and
+// listed in the manifest.
+func (archiver *StorageArchiver) Archive(segment hls.Segment, data []byte) error {
+	archiver.mutex.Lock()
+	defer archiver.mutex.Unlock()
+
+	index := len(archiver.manifest.Segments)
+	entry := ManifestSegment{
+		Index:         index,
+		Key:           fmt.Sprintf("%s/%06d.ts", archiver.prefix, index),
+		Sequence:      segment.Sequence,
+		Time:          segment.Time.Seconds(),
+		Duration:      segment.Duration.Seconds(),
+		Discontinuity: segment.Discontinuity,
+		Size:          len(data),
+	}
+
+	if !segment.ProgramDateTime.IsZero() {
+		entry.ProgramDateTime = &segment.ProgramDateTime
+	}
+
+	pending := archivedSegment{entry: entry}
+
+	if archiver.buffered < archiveBufferSize {
+		pending.data = append([]byte(nil), data...)
+		archiver.buffered++
+	} else {
+		spool, err := spoolSegment(data)
+
+		if err != nil {
+			archiver.manifest.Dropped = append(archiver.manifest.Dropped, segment.Sequence)
+			return fmt.Errorf("dropped segment %d as the upload is behind and spooling failed: %w", segment.Sequence, err)
+		}
+
+		pending.spool = spool
+	}
+
+	archiver.manifest.Segments = append(archiver.manifest.Segments, entry)
+	archiver.pending = append(archiver.pending, pending)
+	archiver.wake.Signal()
+
+	return nil
+}
+
+// spoolSegment writes `data` into a temporary file and returns its path
+func spoolSegment(data []byte) (string, error) {
+	file, err := os.CreateTemp("", "pomu-segment-*.ts")
+	if err != nil {
+		return "", err
+	}
+
+	_, err = file.Write(data)
+
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return "", err
+	}
+
+	return file.Name(), nil
+}
+
+// next blocks until a segment is pending and returns it, returns false once the archiver is closed and drained
+func (archiver *StorageArchiver) next() (archivedSegment, bool) {
+	archiver.mutex.Lock()
+	defer archiver.mutex.Unlock()
+
+	for len(archiver.pending) == 0 && !archiver.closed {
+		archiver.wake.Wait()
+	}
+
+	if len(archiver.pending) == 0 {
+		return archivedSegment{}, false
+	}
+
+	segment := archiver.pending[0]
+	archiver.pending = archiver.pending[1:]
+
+	if len(segment.spool) == 0 {
+		archiver.buffered--
+	}
+
+	return segment, true
+}
+
+func (archiver *StorageArchiver) upload() {
+	defer close(archiver.done)
+
+	for {
+		segment, ok := archiver.next()
+		if !ok {
+			return
+		}
+
+		data := segment.data
+
+		if len(segment.spool) > 0 {
+			var err error
+			data, err = os.ReadFile(segment.spool)
+			_ = os.Remove(segment.spool)
+
+			if err != nil {
+				logVideo(archiver.manifest.VideoId, err).Error("Failed to read spooled segment ", segment.entry.Key)
+				archiver.err = err
+				continue
+			}
+		}
+
+		if err := archiver.backend.Upload(context.Background(), segment.entry.Key, bytes.NewReader(data), "video/mp2t"); err != nil {
+			sentry.CaptureException(err)
+			logVideo(archiver.manifest.VideoId, err).Error("Failed to archive segment ", segment.entry.Key)
+			archiver.err = err
+			continue
+		}
+
+		if (segment.entry.Index+1)%manifestUploadInterval == 0 {
+			if err := archiver.uploadManifest(); err != nil {
+				logVideo(archiver.manifest.VideoId, err).Error("Failed to upload segment manifest")
+			}
+		}
+	}
+}
+
+func (archiver *StorageArchiver) uploadManifest() error {
+	archiver.mutex.Lock()
+	manifest, err := json.Marshal(archiver.manifest)
+	archiver.mutex.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	return archiver.backend.Upload(context.Background(), ManifestKey(archiver.prefix), bytes.NewReader(manifest), "application/json")
+}
+
+func (archiver *StorageArchiver) Close() error {
+	archiver.mutex.Lock()
+	archiver.closed = true
+	archiver.wake.Signal()
+	archiver.mutex.Unlock()
+
+	<-archiver.done
+
+	if err := archiver.uploadManifest(); err != nil {
+		return err
+	}
+
+	return archiver.err
+}
+
+// LoadManifest downloads the manifest of the segments stored below `prefix`
+func LoadManifest(backend storage.Backend, prefix string) (*Manifest, error) {
+	reader, err := backend.Download(ManifestKey(prefix))
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer reader.Close()
+
+	var manifest Manifest
+
+	if err := json.NewDecoder(reader).Decode(&manifest); err != nil {
+		return nil, err
+	}
+
+	return &manifest, nil
+}
+
+var _ SegmentArchiver = (*StorageArchiver)(nil)
@@ -0,0 +1,106 @@
+package video
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"pomu/hls"
+	"pomu/storage"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// blockingBackend holds every segment upload until `release` is closed
+type blockingBackend struct {
+	storage.Backend
+	release chan struct{}
+}
+
+func (b *blockingBackend) Upload(ctx context.Context, path string, reader io.Reader, contentType string) error {
+	if contentType == "video/mp2t" {
+		<-b.release
+	}
+
+	return b.Backend.Upload(ctx, path, reader, contentType)
+}
+
+// archiveBehind archives `count` segments while every upload is held and returns how many have been archived
+func archiveBehind(t *testing.T, count int) (*StorageArchiver, storage.Backend, int) {
+	local, err := storage.NewLocal(t.TempDir(), "/storage/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backend := &blockingBackend{Backend: local, release: make(chan struct{})}
+	archiver := NewStorageArchiver(backend, "test", "test.segments")
+	archived := 0
+
+	for sequence := uint64(0); sequence < uint64(count); sequence++ {
+		done := make(chan error)
+
+		go func() {
+			done <- archiver.Archive(hls.Segment{Sequence: sequence}, []byte(fmt.Sprint(sequence)))
+		}()
+
+		select {
+		case err := <-done:
+			if err == nil {
+				archived++
+			}
+		case <-time.After(time.Second):
+			t.Fatal("archiving blocked on a slow upload")
+		}
+	}
+
+	close(backend.release)
+	return archiver, local, archived
+}
+
+func TestStorageArchiverSpoolsWhenBehind(t *testing.T) {
+	archiver, backend, archived := archiveBehind(t, archiveBufferSize+5)
+
+	assert.NoError(t, archiver.Close())
+	assert.Equal(t, archiveBufferSize+5, archived)
+
+	manifest, err := LoadManifest(backend, "test.segments")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Len(t, manifest.Segments, archiveBufferSize+5)
+	assert.Empty(t, manifest.Dropped)
+
+	for index, segment := range manifest.Segments {
+		reader, err := backend.Download(segment.Key)
+
+		if assert.NoError(t, err, segment.Key) {
+			data, _ := io.ReadAll(reader)
+			_ = reader.Close()
+
+			assert.Equal(t, fmt.Sprint(index), string(data))
+		}
+	}
+}
+
+func TestStorageArchiverDropsWhenSpoolingFails(t *testing.T) {
+	t.Setenv("TMPDIR", filepath.Join(t.TempDir(), "missing"))
+
+	archiver, backend, archived := archiveBehind(t, archiveBufferSize+5)
+
+	assert.NoError(t, archiver.Close())
+	assert.GreaterOrEqual(t, archived, archiveBufferSize)
+
+	manifest, err := LoadManifest(backend, "test.segments")
+	if assert.NoError(t, err) {
+		assert.Len(t, manifest.Segments, archived, "dropped segments are not listed as archived")
+		assert.Len(t, manifest.Dropped, archiveBufferSize+5-archived)
+		assert.Contains(t, manifest.Dropped, uint64(archiveBufferSize+4))
+
+		for index, segment := range manifest.Segments {
+			assert.Equal(t, index, segment.Index)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package video
+
+import (
+	"context"
+	"fmt"
+)
+
+// SubtitleTrack is a text track which is muxed into the mp4
+type SubtitleTrack struct {
+	// Path is a path or url of a subtitle file ffmpeg can read, e.g. WebVTT
+	Path string
+	// Language is the ISO 639-2 code of the track, e.g. `jpn`. Optional.
+	Language string
+	// Title is the name players show for the track. Optional.
+	Title string
+}
+
+// subtitleArgs returns the ffmpeg inputs and output options adding `tracks` as mov_text tracks. The first track is
+// input number `firstInput`.
+func subtitleArgs(tracks []SubtitleTrack, firstInput int) (inputs []string, options []string) {
+	for i, track := range tracks {
+		inputs = append(inputs, "-i", track.Path)
+		options = append(options, "-map", fmt.Sprintf("%d:s", firstInput+i))
+
+		if len(track.Language) > 0 {
+			options = append(options, fmt.Sprintf("-metadata:s:s:%d", i), "language="+track.Language)
+		}
+
+		if len(track.Title) > 0 {
+			options = append(options, fmt.Sprintf("-metadata:s:s:%d", i), "title="+track.Title)
+		}
+	}
+
+	if len(tracks) > 0 {
+		// mp4 only supports mov_text, which means the subtitles cannot just be copied
+		options = append(options, "-c:s", "mov_text")
+	}
+
+	return
+}
+
+// AddSubtitles copies `input` into `output` with `tracks` as its subtitle tracks, replacing any subtitle tracks
+// `input` already has. `input` can be a path or url, `output` has to be a path.
+func AddSubtitles(ctx context.Context, input string, output string, tracks ...SubtitleTrack) error {
+	inputs, options := subtitleArgs(tracks, 1)
+
+	args := []string{"-hide_banner", "-y", "-i", input}
+	args = append(args, inputs...)
+	args = append(args, "-map", "0", "-map", "-0:s", "-c", "copy")
+	args = append(args, options...)
+	args = append(args, "-movflags", "+faststart", output)
+
+	return runFfmpeg(ctx, args)
+}
@@ -0,0 +1,106 @@
+package video
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/kz26/m3u8"
+)
+
+// VodSegment is a single segment of a VOD playlist
+type VodSegment struct {
+	// Uri is the segment relative to the playlist
+	Uri      string
+	Duration float64
+	// Discontinuity is set if the segment starts a new part
+	Discontinuity bool
+}
+
+// SegmentHls splits `input` into MPEG-TS segments of roughly `segmentDuration` inside `directory` without re-encoding.
+// Segment files are prefixed with `name`. Returns the segments in order.
+func SegmentHls(ctx context.Context, input string, directory string, name string, segmentDuration time.Duration) ([]VodSegment, error) {
+	playlistPath := filepath.Join(directory, name+".m3u8")
+
+	stderr := new(strings.Builder)
+	cmd := exec.CommandContext(ctx, os.Getenv("FFMPEG"),
+		"-hide_banner", "-y",
+		"-i", input,
+		"-map", "0",
+		// MPEG-TS cannot carry mov_text, subtitles are served separately
+		"-map", "-0:s",
+		"-c", "copy",
+		"-f", "hls",
+		"-hls_time", fmt.Sprintf("%.0f", segmentDuration.Seconds()),
+		"-hls_playlist_type", "vod",
+		"-hls_segment_filename", filepath.Join(directory, name+"_%05d.ts"),
+		playlistPath)
+	cmd.Stderr = stderr
+
+	if err := cmd.Run(); err != nil {
+		lines := strings.Split(strings.TrimSpace(stderr.String()), "\n")
+		return nil, fmt.Errorf("ffmpeg failed: %w (%s)", err, lines[len(lines)-1])
+	}
+
+	file, err := os.Open(playlistPath)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	playlist, _, err := m3u8.DecodeFrom(bufio.NewReader(file), true)
+	if err != nil {
+		return nil, err
+	}
+
+	media, ok := playlist.(*m3u8.MediaPlaylist)
+	if !ok {
+		return nil, fmt.Errorf("ffmpeg did not produce a media playlist")
+	}
+
+	var segments []VodSegment
+
+	for _, segment := range media.Segments {
+		if segment == nil {
+			continue
+		}
+
+		segments = append(segments, VodSegment{Uri: segment.URI, Duration: segment.Duration})
+	}
+
+	return segments, nil
+}
+
+// WriteVodPlaylist writes a VOD playlist containing `segments` into `w`
+func WriteVodPlaylist(w io.Writer, segments []VodSegment) error {
+	var targetDuration float64
+
+	for _, segment := range segments {
+		targetDuration = math.Max(targetDuration, math.Ceil(segment.Duration))
+	}
+
+	builder := new(strings.Builder)
+	builder.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-PLAYLIST-TYPE:VOD\n")
+	builder.WriteString(fmt.Sprintf("#EXT-X-TARGETDURATION:%.0f\n#EXT-X-MEDIA-SEQUENCE:0\n", targetDuration))
+
+	for _, segment := range segments {
+		if segment.Discontinuity {
+			builder.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+
+		builder.WriteString(fmt.Sprintf("#EXTINF:%.3f,\n%s\n", segment.Duration, segment.Uri))
+	}
+
+	builder.WriteString("#EXT-X-ENDLIST\n")
+
+	_, err := io.WriteString(w, builder.String())
+	return err
+}
@@ -0,0 +1,34 @@
+package video
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteVodPlaylist(t *testing.T) {
+	output := new(strings.Builder)
+
+	err := WriteVodPlaylist(output, []VodSegment{
+		{Uri: "part1_00000.ts", Duration: 6.006},
+		{Uri: "part1_00001.ts", Duration: 2.5},
+		{Uri: "part2_00000.ts", Duration: 6.4, Discontinuity: true},
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, `#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-PLAYLIST-TYPE:VOD
+#EXT-X-TARGETDURATION:7
+#EXT-X-MEDIA-SEQUENCE:0
+#EXTINF:6.006,
+part1_00000.ts
+#EXTINF:2.500,
+part1_00001.ts
+#EXT-X-DISCONTINUITY
+#EXTINF:6.400,
+part2_00000.ts
+#EXT-X-ENDLIST
+`, output.String())
+}
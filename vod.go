@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/getsentry/sentry-go"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"os"
+	"path/filepath"
+	"pomu/video"
+	"strings"
+	"time"
+)
+
+// vodSegmentDuration is the targeted duration of each segment of a published archive
+const vodSegmentDuration = 6 * time.Second
+
+// vodTimeout is how long segmenting and uploading a single part may take
+const vodTimeout = 2 * time.Hour
+
+// VodPrefix returns the storage prefix below which the hls vod playlist of `id` is stored
+func VodPrefix(id string) string {
+	return fmt.Sprintf("%s.hls", id)
+}
+
+// publishVod segments every part of `videoId` into an hls vod playlist, so that the archive can be streamed in the browser
+func (app *Application) publishVod(videoId string) error {
+	parts, err := VideoParts(app.db, videoId)
+
+	if err != nil {
+		return err
+	}
+
+	directory, err := os.MkdirTemp("", "pomu-hls-*")
+	if err != nil {
+		return err
+	}
+
+	defer os.RemoveAll(directory)
+
+	prefix := VodPrefix(videoId)
+	var segments []video.VodSegment
+
+	for part := int32(1); part <= parts; part++ {
+		partSegments, err := app.publishVodPart(VideoPartKey(videoId, part, parts, "mp4"), directory, prefix, fmt.Sprintf("part%d", part))
+
+		if err != nil {
+			return fmt.Errorf("failed to publish part %d: %w", part, err)
+		}
+
+		if len(partSegments) > 0 && part > 1 {
+			partSegments[0].Discontinuity = true
+		}
+
+		segments = append(segments, partSegments...)
+	}
+
+	playlist := new(bytes.Buffer)
+
+	if err := video.WriteVodPlaylist(playlist, segments); err != nil {
+		return err
+	}
+
+	// the playlist is uploaded last so that it never references segments which do not exist yet
+	if err := app.storage.Upload(context.Background(), prefix+"/playlist.m3u8", playlist, "application/vnd.apple.mpegurl"); err != nil {
+		return err
+	}
+
+	tx, err := app.db.Begin()
+
+	if err != nil {
+		sentry.CaptureException(err)
+		return err
+	}
+
+	defer tx.Rollback()
+
+	var archive Video
+
+	if err := scanVideo(tx.QueryRow("update videos set hls = true where id = $1 returning *", videoId), &archive); err != nil {
+		sentry.CaptureException(err)
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		sentry.CaptureException(err)
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"video_id": videoId,
+		"segments": len(segments),
+	}).Info("published archive as hls vod")
+
+	go app.UpsertVideo(archive)
+	return nil
+}
+
+// publishVodPart segments the stored file at `key` and uploads the segments below `prefix`
+func (app *Application) publishVodPart(key string, directory string, prefix string, name string) ([]video.VodSegment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), vodTimeout)
+	defer cancel()
+
+	input, err := app.storageInput(key)
+	if err != nil {
+		return nil, err
+	}
+
+	segments, err := video.SegmentHls(ctx, input, directory, name, vodSegmentDuration)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, segment := range segments {
+		path := filepath.Join(directory, segment.Uri)
+		file, err := os.Open(path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		err = app.storage.Upload(ctx, prefix+"/"+segment.Uri, file, "video/mp2t")
+		_ = file.Close()
+
+		if err != nil {
+			return nil, err
+		}
+
+		// segments can add up to the size of the whole archive, don't keep them around
+		_ = os.Remove(path)
+	}
+
+	return segments, nil
+}
+
+func (app *Application) GetPlaylist(w http.ResponseWriter, r *http.Request) {
+	videoId := mux.Vars(r)["id"]
+
+	var published bool
+
+	if err := app.db.QueryRow("select hls from videos where id = $1", videoId).Scan(&published); err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "video not found", http.StatusNotFound)
+			return
+		}
+
+		sentry.CaptureException(err)
+		http.Error(w, "failed to query video", http.StatusInternalServerError)
+		return
+	}
+
+	if !published {
+		http.Error(w, "video has not been published for streaming", http.StatusNotFound)
+		return
+	}
+
+	prefix := VodPrefix(videoId)
+	reader, err := app.storage.Download(prefix + "/playlist.m3u8")
+
+	if err != nil {
+		sentry.CaptureException(err)
+		http.Error(w, "failed to read playlist", http.StatusInternalServerError)
+		return
+	}
+
+	defer reader.Close()
+
+	// segments are stored relative to the playlist, point them at where they can actually be downloaded
+	playlist := new(strings.Builder)
+	scanner := bufio.NewScanner(reader)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if len(line) > 0 && !strings.HasPrefix(line, "#") {
+			url, err := app.storage.Url(prefix + "/" + line)
+
+			if err != nil {
+				sentry.CaptureException(err)
+				http.Error(w, "failed to get segment url", http.StatusInternalServerError)
+				return
+			}
+
+			line = url
+		}
+
+		playlist.WriteString(line)
+		playlist.WriteString("\n")
+	}
+
+	if err := scanner.Err(); err != nil {
+		sentry.CaptureException(err)
+		http.Error(w, "failed to read playlist", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	// presigned segment urls expire, don't let the playlist be cached for too long
+	w.Header().Set("Cache-Control", "max-age=3600")
+
+	_, _ = w.Write([]byte(playlist.String()))
+}
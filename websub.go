@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"net/http"
+	"os"
+	"pomu/feed"
+	"pomu/websub"
+	"strings"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultWebSubHub = "https://pubsubhubbub.appspot.com/subscribe"
+	// webSubLease is the lease requested from the hub, YouTube's hub does not grant more than 5 days
+	webSubLease = 5 * 24 * time.Hour
+	// webSubRenewBefore is how long before a lease runs out it is renewed
+	webSubRenewBefore = 24 * time.Hour
+)
+
+// WebSub receives the feeds of subscribed YouTube channels from a hub as soon as a livestream is scheduled, instead of
+// waiting for the next poll
+type WebSub struct {
+	client *websub.Client
+	// callback is the public url of /api/websub
+	callback string
+}
+
+// webSub returns the WebSub configuration if WEBSUB_ENABLE is set
+func webSub() *WebSub {
+	if strings.ToLower(os.Getenv("WEBSUB_ENABLE")) != "true" {
+		return nil
+	}
+
+	callback := os.Getenv("WEBSUB_CALLBACK_URL")
+
+	if len(callback) == 0 {
+		log.Fatal("WEBSUB_CALLBACK_URL is required for websub")
+	}
+
+	// without a secret anyone could push forged feeds to the callback
+	if len(os.Getenv("WEBSUB_SECRET")) == 0 {
+		log.Fatal("WEBSUB_SECRET is required for websub")
+	}
+
+	hub := os.Getenv("WEBSUB_HUB")
+
+	if len(hub) == 0 {
+		hub = defaultWebSubHub
+	}
+
+	return &WebSub{
+		client:   &websub.Client{Hub: hub, Secret: os.Getenv("WEBSUB_SECRET")},
+		callback: callback,
+	}
+}
+
+// subscribeWebSub asks the hub to push the feed of a subscribed channel. The lease is stored once the hub verifies it.
+func (app *Application) subscribeWebSub(subscription *ChannelSubscription) {
+	if app.webSub == nil || subscription.Source != "youtube" {
+		return
+	}
+
+	err := app.webSub.client.Subscribe(context.Background(), feed.Topic(subscription.ChannelId), app.webSub.callback, webSubLease)
+
+	if err != nil {
+		sentry.CaptureException(err)
+		log.WithFields(log.Fields{"channel_id": subscription.ChannelId, "error": err}).Warn("failed to subscribe to channel feed")
+	}
+}
+
+// unsubscribeWebSub stops the hub from pushing the feed of a channel which is not subscribed to anymore
+func (app *Application) unsubscribeWebSub(subscription *ChannelSubscription) {
+	if app.webSub == nil || subscription.Source != "youtube" {
+		return
+	}
+
+	if err := app.webSub.client.Unsubscribe(context.Background(), feed.Topic(subscription.ChannelId), app.webSub.callback); err != nil {
+		log.WithFields(log.Fields{"channel_id": subscription.ChannelId, "error": err}).Warn("failed to unsubscribe from channel feed")
+	}
+}
+
+// RenewWebSubLeases subscribes to the feed of every subscribed YouTube channel whose lease runs out soon or which has
+// not been verified by the hub yet
+func RenewWebSubLeases(app *Application) {
+	rows, err := app.db.Query(
+		"select * from channel_subscriptions where source = 'youtube' and (websub_expires_at is null or websub_expires_at < $1)",
+		time.Now().Add(webSubRenewBefore))
+
+	if err != nil {
+		sentry.CaptureException(err)
+		log.WithFields(log.Fields{"error": err}).Error("failed to query websub leases")
+		return
+	}
+
+	var subscriptions []ChannelSubscription
+
+	for rows.Next() {
+		var subscription ChannelSubscription
+
+		if err := scanSubscription(rows, &subscription); err != nil {
+			sentry.CaptureException(err)
+			continue
+		}
+
+		subscriptions = append(subscriptions, subscription)
+	}
+
+	rows.Close()
+
+	for _, subscription := range subscriptions {
+		app.subscribeWebSub(&subscription)
+	}
+
+	if len(subscriptions) > 0 {
+		log.WithFields(log.Fields{"count": len(subscriptions)}).Info("renewed websub leases")
+	}
+}
+
+// WebSubHandler is the callback of the hub, see https://developers.google.com/youtube/v3/guides/push_notifications
+func (app *Application) WebSubHandler() http.Handler {
+	return &websub.Handler{
+		Secret: os.Getenv("WEBSUB_SECRET"),
+		Verify: app.verifyWebSub,
+		Notify: func(topic string, body []byte) {
+			go app.handleWebSubNotification(topic, body)
+		},
+	}
+}
+
+// verifyWebSub confirms subscriptions to feeds of subscribed channels and stores their lease, and confirms
+// unsubscriptions of every other feed
+func (app *Application) verifyWebSub(verification *websub.Verification) bool {
+	channelId := feed.TopicChannelId(verification.Topic)
+
+	if len(channelId) == 0 {
+		return false
+	}
+
+	if verification.Mode == websub.ModeUnsubscribe {
+		var subscribed bool
+
+		err := app.db.QueryRow("select exists(select 1 from channel_subscriptions where source = 'youtube' and channel_id = $1)", channelId).Scan(&subscribed)
+
+		return err == nil && !subscribed
+	}
+
+	result, err := app.db.Exec(
+		"update channel_subscriptions set websub_expires_at = $1 where source = 'youtube' and channel_id = $2",
+		time.Now().Add(verification.Lease), channelId)
+
+	if err != nil {
+		sentry.CaptureException(err)
+		return false
+	}
+
+	updated, err := result.RowsAffected()
+
+	if err != nil || updated == 0 {
+		return false
+	}
+
+	log.WithFields(log.Fields{"channel_id": channelId, "lease": verification.Lease}).Info("websub subscription verified")
+
+	return true
+}
+
+// handleWebSubNotification queues the livestreams of a pushed feed like PollSubscriptions does
+func (app *Application) handleWebSubNotification(topic string, body []byte) {
+	pushed, err := feed.Parse(bytes.NewReader(body))
+
+	if err != nil {
+		log.WithFields(log.Fields{"topic": topic, "error": err}).Warn("failed to parse websub notification")
+		return
+	}
+
+	for _, entry := range pushed.Entries {
+		var subscription ChannelSubscription
+
+		row := app.db.QueryRow("select * from channel_subscriptions where source = 'youtube' and channel_id = $1", entry.ChannelId)
+
+		if err := scanSubscription(row, &subscription); err != nil {
+			if err != sql.ErrNoRows {
+				sentry.CaptureException(err)
+			}
+
+			continue
+		}
+
+		unknown, err := app.unknownVideoIds(&subscription, []string{entry.VideoId})
+
+		if err != nil || len(unknown) == 0 {
+			continue
+		}
+
+		metadata, err := GetVideoMetadata(entry.VideoId)
+
+		if err != nil {
+			log.WithFields(log.Fields{"video_id": entry.VideoId, "error": err}).Warn("failed to get metadata of pushed video")
+			continue
+		}
+
+		if !isQueueable(metadata, subscriptionLookahead()) {
+			continue
+		}
+
+		log.WithFields(log.Fields{"video_id": entry.VideoId, "channel_id": entry.ChannelId}).Info("livestream pushed through websub")
+
+		app.queueSubscriptionLivestream(&subscription, metadata)
+	}
+}
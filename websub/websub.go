@@ -0,0 +1,229 @@
+// Package websub implements the subscriber side of WebSub (formerly PubSubHubbub), https://www.w3.org/TR/websub/
+package websub
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ModeSubscribe   = "subscribe"
+	ModeUnsubscribe = "unsubscribe"
+)
+
+// maxNotificationSize is the largest notification body which is accepted
+const maxNotificationSize = 1 << 20
+
+// Client (un)subscribes from topics at a hub
+type Client struct {
+	// Hub is the url of the hub, e.g. https://pubsubhubbub.appspot.com/subscribe
+	Hub string
+	// Secret makes the hub sign notifications, see ValidSignature
+	Secret string
+}
+
+// Subscribe asks the hub to send notifications of `topic` to `callback` for `lease`. The hub verifies the
+// subscription asynchronously by requesting `callback`, see Handler.
+func (c *Client) Subscribe(ctx context.Context, topic string, callback string, lease time.Duration) error {
+	form := url.Values{}
+	form.Set("hub.lease_seconds", strconv.Itoa(int(lease.Seconds())))
+
+	if len(c.Secret) > 0 {
+		form.Set("hub.secret", c.Secret)
+	}
+
+	return c.request(ctx, ModeSubscribe, topic, callback, form)
+}
+
+// Unsubscribe asks the hub to stop sending notifications of `topic` to `callback`
+func (c *Client) Unsubscribe(ctx context.Context, topic string, callback string) error {
+	return c.request(ctx, ModeUnsubscribe, topic, callback, url.Values{})
+}
+
+func (c *Client) request(ctx context.Context, mode string, topic string, callback string, form url.Values) error {
+	form.Set("hub.mode", mode)
+	form.Set("hub.topic", topic)
+	form.Set("hub.callback", callback)
+	form.Set("hub.verify", "async")
+
+	request, err := http.NewRequestWithContext(ctx, "POST", c.Hub, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("User-Agent", "pomu.app")
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+
+	// hubs answer with 202 Accepted, some older ones with 204 No Content
+	if response.StatusCode != http.StatusAccepted && response.StatusCode != http.StatusNoContent {
+		body, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		return fmt.Errorf("hub refused to %s: %s (%s)", mode, response.Status, strings.TrimSpace(string(body)))
+	}
+
+	return nil
+}
+
+// Verification is the hub asking the subscriber to confirm a subscription or unsubscription
+type Verification struct {
+	Mode      string
+	Topic     string
+	Challenge string
+	// Lease is how long the subscription lasts, only set when subscribing
+	Lease time.Duration
+}
+
+// ParseVerification reads the verification request of a hub
+func ParseVerification(r *http.Request) (*Verification, error) {
+	query := r.URL.Query()
+
+	verification := &Verification{
+		Mode:      query.Get("hub.mode"),
+		Topic:     query.Get("hub.topic"),
+		Challenge: query.Get("hub.challenge"),
+	}
+
+	if verification.Mode != ModeSubscribe && verification.Mode != ModeUnsubscribe {
+		return nil, fmt.Errorf("unknown mode %s", verification.Mode)
+	}
+
+	if len(verification.Topic) == 0 || len(verification.Challenge) == 0 {
+		return nil, errors.New("topic and challenge are required")
+	}
+
+	if lease := query.Get("hub.lease_seconds"); len(lease) > 0 {
+		seconds, err := strconv.Atoi(lease)
+		if err != nil {
+			return nil, fmt.Errorf("invalid lease: %w", err)
+		}
+
+		verification.Lease = time.Duration(seconds) * time.Second
+	}
+
+	return verification, nil
+}
+
+// Sign returns the X-Hub-Signature header of `body`
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write(body)
+
+	return "sha1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// ValidSignature checks the X-Hub-Signature header `signature` of `body`
+func ValidSignature(secret string, body []byte, signature string) bool {
+	method, signed, found := strings.Cut(signature, "=")
+	if !found {
+		return false
+	}
+
+	var hashFunc func() hash.Hash
+
+	switch method {
+	case "sha1":
+		hashFunc = sha1.New
+	case "sha256":
+		hashFunc = sha256.New
+	case "sha384":
+		hashFunc = sha512.New384
+	case "sha512":
+		hashFunc = sha512.New
+	default:
+		return false
+	}
+
+	expected, err := hex.DecodeString(signed)
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(hashFunc, []byte(secret))
+	mac.Write(body)
+
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// Topic returns the topic of a notification from its Link header, or an empty string if there is none
+func Topic(header http.Header) string {
+	for _, value := range header.Values("Link") {
+		for _, link := range strings.Split(value, ",") {
+			target, params, _ := strings.Cut(link, ";")
+
+			for _, param := range strings.Split(params, ";") {
+				name, rel, _ := strings.Cut(strings.TrimSpace(param), "=")
+
+				if name == "rel" && strings.Trim(rel, `"`) == "self" {
+					return strings.Trim(strings.TrimSpace(target), "<>")
+				}
+			}
+		}
+	}
+
+	return ""
+}
+
+// Handler is the callback endpoint the hub verifies subscriptions with and sends notifications to
+type Handler struct {
+	// Secret has to match the secret of the Client, notifications without a valid signature are ignored
+	Secret string
+	// Verify returns whether the (un)subscription is wanted
+	Verify func(verification *Verification) bool
+	// Notify receives every notification with a valid signature
+	Notify func(topic string, body []byte)
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		verification, err := ParseVerification(r)
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if !h.Verify(verification) {
+			http.Error(w, "not subscribed to topic", http.StatusNotFound)
+			return
+		}
+
+		_, _ = w.Write([]byte(verification.Challenge))
+	case http.MethodPost:
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxNotificationSize))
+
+		if err != nil {
+			http.Error(w, "failed to read notification", http.StatusBadRequest)
+			return
+		}
+
+		// the hub is not told about invalid signatures so that it cannot be used to guess the secret. Without a secret
+		// there is no way to tell forged notifications apart, so every notification is ignored.
+		if len(h.Secret) > 0 && ValidSignature(h.Secret, body, r.Header.Get("X-Hub-Signature")) {
+			h.Notify(Topic(r.Header), body)
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
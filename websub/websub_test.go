@@ -0,0 +1,223 @@
+package websub
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const topic = "https://www.youtube.com/xml/feeds/videos.xml?channel_id=UCl_gCybOJRIgOXw6Qb4qJzQ"
+
+type stubSubscription struct {
+	callback string
+	secret   string
+}
+
+// hubStub is a hub which verifies (un)subscriptions like https://pubsubhubbub.appspot.com does
+type hubStub struct {
+	mutex         sync.Mutex
+	subscriptions map[string]stubSubscription
+	// verified receives the mode of every verification the subscriber confirmed
+	verified chan string
+}
+
+func newHubStub() (*hubStub, *httptest.Server) {
+	hub := &hubStub{subscriptions: make(map[string]stubSubscription), verified: make(chan string, 1)}
+
+	return hub, httptest.NewServer(hub)
+}
+
+func (h *hubStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || len(r.PostForm.Get("hub.topic")) == 0 || len(r.PostForm.Get("hub.callback")) == 0 {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+
+	go h.verify(r.PostForm)
+}
+
+func (h *hubStub) verify(form url.Values) {
+	mode := form.Get("hub.mode")
+	query := url.Values{}
+	query.Set("hub.mode", mode)
+	query.Set("hub.topic", form.Get("hub.topic"))
+	query.Set("hub.challenge", "challenge-"+strconv.FormatInt(time.Now().UnixNano(), 10))
+
+	if mode == ModeSubscribe {
+		query.Set("hub.lease_seconds", form.Get("hub.lease_seconds"))
+	}
+
+	response, err := http.Get(form.Get("hub.callback") + "?" + query.Encode())
+	if err != nil {
+		return
+	}
+
+	defer response.Body.Close()
+
+	body, _ := io.ReadAll(response.Body)
+
+	if response.StatusCode != http.StatusOK || string(body) != query.Get("hub.challenge") {
+		h.verified <- "refused"
+		return
+	}
+
+	h.mutex.Lock()
+
+	if mode == ModeSubscribe {
+		h.subscriptions[form.Get("hub.topic")] = stubSubscription{callback: form.Get("hub.callback"), secret: form.Get("hub.secret")}
+	} else {
+		delete(h.subscriptions, form.Get("hub.topic"))
+	}
+
+	h.mutex.Unlock()
+
+	h.verified <- mode
+}
+
+// publish sends `body` to the subscriber of `topic`, signed with `secret`
+func (h *hubStub) publish(t *testing.T, topic string, body []byte, secret string) {
+	h.mutex.Lock()
+	subscription, ok := h.subscriptions[topic]
+	h.mutex.Unlock()
+
+	if !assert.True(t, ok, "topic has no subscriber") {
+		return
+	}
+
+	request, _ := http.NewRequest("POST", subscription.callback, bytes.NewReader(body))
+	request.Header.Set("Content-Type", "application/atom+xml")
+	request.Header.Set("Link", `<https://pubsubhubbub.appspot.com>; rel=hub, <`+topic+`>; rel=self`)
+	request.Header.Set("X-Hub-Signature", Sign(secret, body))
+
+	response, err := http.DefaultClient.Do(request)
+
+	if assert.NoError(t, err) {
+		response.Body.Close()
+		assert.Equal(t, http.StatusAccepted, response.StatusCode)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	hub, hubServer := newHubStub()
+	defer hubServer.Close()
+
+	var leases []time.Duration
+	notifications := make(chan string, 2)
+	wanted := true
+
+	subscriber := httptest.NewServer(&Handler{
+		Secret: "secret",
+		Verify: func(verification *Verification) bool {
+			assert.Equal(t, topic, verification.Topic)
+			leases = append(leases, verification.Lease)
+
+			return wanted
+		},
+		Notify: func(topic string, body []byte) {
+			notifications <- topic + " " + string(body)
+		},
+	})
+	defer subscriber.Close()
+
+	client := &Client{Hub: hubServer.URL, Secret: "secret"}
+
+	assert.NoError(t, client.Subscribe(context.Background(), topic, subscriber.URL, 5*24*time.Hour))
+	assert.Equal(t, ModeSubscribe, <-hub.verified)
+	assert.Equal(t, []time.Duration{5 * 24 * time.Hour}, leases)
+
+	hub.publish(t, topic, []byte("<feed/>"), "wrong secret")
+	hub.publish(t, topic, []byte("<feed/>"), "secret")
+
+	assert.Equal(t, topic+" <feed/>", <-notifications)
+	assert.Len(t, notifications, 0, "notification with invalid signature has been delivered")
+
+	wanted = false
+	assert.NoError(t, client.Unsubscribe(context.Background(), topic, subscriber.URL))
+	assert.Equal(t, "refused", <-hub.verified)
+
+	wanted = true
+	assert.NoError(t, client.Unsubscribe(context.Background(), topic, subscriber.URL))
+	assert.Equal(t, ModeUnsubscribe, <-hub.verified)
+	assert.Empty(t, hub.subscriptions)
+}
+
+func TestSubscribeRefused(t *testing.T) {
+	hub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Invalid value for hub.topic", http.StatusBadRequest)
+	}))
+	defer hub.Close()
+
+	client := &Client{Hub: hub.URL}
+
+	assert.Error(t, client.Subscribe(context.Background(), topic, "https://pomu.app/api/websub", time.Hour))
+}
+
+func TestValidSignature(t *testing.T) {
+	body := []byte("<feed/>")
+
+	assert.True(t, ValidSignature("secret", body, Sign("secret", body)))
+
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write(body)
+	assert.True(t, ValidSignature("secret", body, "sha256="+hex.EncodeToString(mac.Sum(nil))))
+
+	assert.False(t, ValidSignature("other", body, Sign("secret", body)))
+	assert.False(t, ValidSignature("secret", []byte("<feed></feed>"), Sign("secret", body)))
+	assert.False(t, ValidSignature("secret", body, "md5=abc"))
+	assert.False(t, ValidSignature("secret", body, "sha1=not hex"))
+	assert.False(t, ValidSignature("secret", body, ""))
+}
+
+func TestParseVerification(t *testing.T) {
+	request := httptest.NewRequest("GET", "/api/websub?hub.mode=subscribe&hub.topic=topic&hub.challenge=abc&hub.lease_seconds=432000", nil)
+	verification, err := ParseVerification(request)
+
+	assert.NoError(t, err)
+	assert.Equal(t, &Verification{Mode: ModeSubscribe, Topic: "topic", Challenge: "abc", Lease: 120 * time.Hour}, verification)
+
+	for _, query := range []string{
+		"hub.mode=publish&hub.topic=topic&hub.challenge=abc",
+		"hub.mode=subscribe&hub.challenge=abc",
+		"hub.mode=subscribe&hub.topic=topic",
+		"hub.mode=subscribe&hub.topic=topic&hub.challenge=abc&hub.lease_seconds=forever",
+	} {
+		_, err := ParseVerification(httptest.NewRequest("GET", "/api/websub?"+query, nil))
+		assert.Error(t, err, query)
+	}
+}
+
+func TestTopic(t *testing.T) {
+	header := http.Header{}
+	header.Add("Link", `<https://pubsubhubbub.appspot.com>; rel=hub, <`+topic+`>; rel="self"`)
+
+	assert.Equal(t, topic, Topic(header))
+	assert.Equal(t, "", Topic(http.Header{}))
+}
+
+func TestHandlerWithoutSecret(t *testing.T) {
+	notified := false
+	handler := &Handler{Notify: func(topic string, body []byte) { notified = true }}
+
+	request := httptest.NewRequest("POST", "/api/websub", bytes.NewReader([]byte("<feed/>")))
+	request.Header.Set("X-Hub-Signature", Sign("", []byte("<feed/>")))
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusAccepted, recorder.Code)
+	assert.False(t, notified, "unsigned notification has been delivered")
+}